@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/Iyusuf40/goBackendUtils/api/controllers/user_controller"
 	"github.com/Iyusuf40/goBackendUtils/config"
+	"github.com/Iyusuf40/goBackendUtils/storage"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -24,9 +25,16 @@ func ServeAPI() {
 		g.Use(middleware.CORS())
 	}
 	g.POST("/users", user_controller.SaveUser)
+	g.GET("/users", user_controller.SearchUsers)
 	g.GET("/users/:id", user_controller.GetUser)
 	g.PUT("/users/:id", user_controller.UpdateUser)
 	g.DELETE("/users/:id", user_controller.DeleteUser)
+	g.POST("/users/:id/restore", user_controller.RestoreUser)
+
+	if config.SoftDelete {
+		storage.StartPurgingDeletedOfTenants(user_controller.UserStorage,
+			config.SoftDeleteRetention, config.SoftDeletePurgeInterval)
+	}
 
 	// complete signup
 	g.GET("/complete_signup/:signupId", user_controller.CompleteSignup)
@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/Iyusuf40/goBackendUtils/mail"
@@ -13,56 +15,109 @@ import (
 )
 
 type SignupHandler struct {
-	temp_store  storage.TempStore
+	temp_store  storage.TempStoreV2
 	users_store storage.Storage[models.User]
+	tenant      string
 }
 
 const DEFAULT_SIGNUP_TIMEOUT = 86400.0
 
+// the namespace of the temp store pending signups are kept in
+const SIGNUP_NAMESPACE = "signup"
+
+// ForTenant returns a SignupHandler registering the users of tenant
+func (sighnup_h *SignupHandler) ForTenant(tenant string) (*SignupHandler, error) {
+	users_store, err := sighnup_h.users_store.ForTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+	return &SignupHandler{temp_store: sighnup_h.temp_store, users_store: users_store, tenant: tenant}, nil
+}
+
 func (sighnup_h *SignupHandler) HandleSignup(user models.User) string {
+	signupId, _ := sighnup_h.HandleSignupContext(context.Background(), user)
+	return signupId
+}
+
+// HandleSignupContext keeps user pending until its signup is
+// completed, see HandleCompleteSignup, and mails them the link to
+// complete it. It fails with an ErrBackend if the temp store does
+func (sighnup_h *SignupHandler) HandleSignupContext(ctx context.Context, user models.User) (string, error) {
 
 	userJson, err := json.Marshal(user)
 
 	if err != nil {
-		return ""
+		return "", err
 	}
 
 	signupId := uuid.NewString()
 
+	err = sighnup_h.temp_store.Set(ctx, signupId,
+		MakeTenantValue(sighnup_h.tenant, string(userJson)), DEFAULT_SIGNUP_TIMEOUT*time.Second)
+	if err != nil {
+		return "", err
+	}
+
 	userEmail := user.Email
 
 	go func() {
 		sighnup_h.sendEmailConfirmationMsg(userEmail, signupId)
 	}()
 
-	sighnup_h.temp_store.SetKeyToValWIthExpiry(signupId, string(userJson), DEFAULT_SIGNUP_TIMEOUT)
-
-	return signupId
+	return signupId, nil
 }
 
-func (sighnup_h *SignupHandler) HandleCompleteSignup(signupId string) (string, bool) {
+// HandleCompleteSignup saves the user of the pending signup with
+// signupId in the storage of the tenant it was made for. Handlers
+// of a tenant only complete the signups of that tenant
+func (sighnup_h *SignupHandler) HandleCompleteSignup(signupId string) (string, error) {
+	return sighnup_h.HandleCompleteSignupContext(context.Background(), signupId)
+}
 
-	userJson := sighnup_h.temp_store.GetVal(signupId)
+// HandleCompleteSignupContext is HandleCompleteSignup. A signup
+// missing is an ErrNotFound, a temp store failing an ErrBackend
+func (sighnup_h *SignupHandler) HandleCompleteSignupContext(ctx context.Context, signupId string) (string, error) {
 
-	if userJson == "" {
-		return "", false
+	stored, _, err := sighnup_h.temp_store.Get(ctx, signupId)
+	if err != nil {
+		return "", err
 	}
 
-	mapRep := map[string]any{}
+	tenant, userJson := SplitTenantValue(stored)
 
-	err := json.Unmarshal([]byte(userJson), &mapRep)
+	if userJson == "" || (sighnup_h.tenant != "" && tenant != sighnup_h.tenant) {
+		return "", fmt.Errorf("%w: no pending signup with id %s", storage.ErrNotFound, signupId)
+	}
 
+	// the signup is claimed at once, so that completing it twice,
+	// e.g. by clicking its link twice, saves the user only once
+	_, claimed, err := sighnup_h.temp_store.GetAndDelete(ctx, signupId)
 	if err != nil {
-		return "", false
+		return "", err
+	}
+	if !claimed {
+		return "", fmt.Errorf("%w: no pending signup with id %s", storage.ErrNotFound, signupId)
 	}
 
-	user := sighnup_h.users_store.BuildClient(mapRep)
+	users_store, err := sighnup_h.users_store.ForTenant(tenant)
+	if err != nil {
+		return "", err
+	}
 
-	userId, success := sighnup_h.users_store.Save(user)
+	mapRep := map[string]any{}
 
-	sighnup_h.temp_store.DelKey(signupId)
+	err = json.Unmarshal([]byte(userJson), &mapRep)
+
+	if err != nil {
+		return "", err
+	}
+
+	user, err := users_store.BuildClient(mapRep)
+	if err != nil {
+		return "", err
+	}
 
-	return userId, success
+	return users_store.Save(user)
 }
 
 func (sighnup_h *SignupHandler) sendEmailConfirmationMsg(email, signupId string) {
@@ -93,7 +148,8 @@ func (sighnup_h *SignupHandler) sendEmailConfirmationMsg(email, signupId string)
 
 func MakeSignupHandler(temp_store_db, users_store_db, recordsName string) *SignupHandler {
 	sighnup_h := new(SignupHandler)
-	sighnup_h.temp_store = storage.GET_TempStore(config.TempStoreType, temp_store_db, recordsName)
+	sighnup_h.temp_store = storage.TempStoreV2Of(storage.MakeNamespacedTempStore(
+		storage.GET_TempStore(config.TempStoreType, temp_store_db, recordsName), SIGNUP_NAMESPACE))
 	sighnup_h.users_store = storage.MakeUserStorage(users_store_db, recordsName)
 	return sighnup_h
 }
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/Iyusuf40/goBackendUtils/config"
+	"github.com/Iyusuf40/goBackendUtils/storage"
+	"github.com/labstack/echo/v4"
+)
+
+var ErrTenantMismatch = errors.New("session belongs to another tenant")
+
+// separates the tenant from the value it owns in a temp store,
+// tenants cannot contain it, see storage.ValidateTenant
+const TENANT_VALUE_SEPARATOR = "/"
+
+// MakeTenantValue returns value, to be kept in a temp store on
+// behalf of tenant, e.g. the user id of a session, marked with
+// tenant. Values of the default tenant are left as they are
+func MakeTenantValue(tenant, value string) string {
+	if tenant == "" {
+		return value
+	}
+	return tenant + TENANT_VALUE_SEPARATOR + value
+}
+
+// SplitTenantValue returns the tenant and the value of stored, a
+// value made with MakeTenantValue
+func SplitTenantValue(stored string) (tenant, value string) {
+	tenant, value, found := strings.Cut(stored, TENANT_VALUE_SEPARATOR)
+	if !found || tenant == "" || storage.ValidateTenant(tenant) != nil {
+		return "", stored
+	}
+	return tenant, value
+}
+
+// ResolveTenant returns the tenant of the request c, see
+// config.MultiTenant, or "" if multi tenancy is disabled
+func ResolveTenant(c echo.Context) (string, error) {
+	return ResolveTenantWithSession(c, c.Request().Header.Get(config.SessionHeader))
+}
+
+// ResolveTenantWithSession is ResolveTenant with sessionId as the
+// request's session. A tenant named by the request that is not
+// the tenant of its session is an ErrTenantMismatch, a session
+// store failing to read the session an ErrBackend
+func ResolveTenantWithSession(c echo.Context, sessionId string) (string, error) {
+	if !config.MultiTenant {
+		return "", nil
+	}
+
+	tenant := c.Request().Header.Get(config.TenantHeader)
+	if tenant == "" {
+		tenant = tenantOfHost(c.Request().Host)
+	}
+
+	if err := storage.ValidateTenant(tenant); err != nil {
+		return "", err
+	}
+
+	if sessionId == "" {
+		return tenant, nil
+	}
+
+	sessions := getSessionStore()
+	stored, _, err := storage.TempStoreV2Of(sessions).Get(c.Request().Context(), sessionId)
+	if err == nil && stored == "" {
+		stored, _, err = getLegacySession(c.Request().Context(), sessions, sessionId)
+	}
+	if err != nil {
+		return "", err
+	}
+	if stored == "" {
+		return tenant, nil
+	}
+
+	sessionTenant, _ := SplitTenantValue(stored)
+	if tenant != "" && tenant != sessionTenant {
+		return "", ErrTenantMismatch
+	}
+	return sessionTenant, nil
+}
+
+// tenantOfHost returns the subdomain of config.TenantDomain in host
+func tenantOfHost(host string) string {
+	if config.TenantDomain == "" {
+		return ""
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	tenant, found := strings.CutSuffix(strings.ToLower(host),
+		"."+strings.ToLower(config.TenantDomain))
+	if !found || strings.Contains(tenant, ".") {
+		return ""
+	}
+	return tenant
+}
+
+// the namespace of the temp store sessions are kept in
+const SESSIONS_NAMESPACE = "session"
+
+// SessionStore is the temp store the auth router keeps sessions
+// in, it is made on first use if nil
+var SessionStore storage.TempStore
+var sessionStoreMu sync.Mutex
+
+func getSessionStore() storage.TempStore {
+	sessionStoreMu.Lock()
+	defer sessionStoreMu.Unlock()
+
+	if SessionStore == nil {
+		SessionStore = storage.MakeNamespacedTempStore(storage.GET_TempStore(config.TempStoreType,
+			config.TempStoreDb, config.UsersRecords), SESSIONS_NAMESPACE)
+	}
+	return SessionStore
+}
+
+// getLegacySession reads sessionId as it was kept before sessions
+// had a namespace: un-prefixed in the store sessions is a view of.
+// The auth handler moves it into the namespace once it checks it,
+// see storage.AdoptLegacyKey
+func getLegacySession(ctx context.Context, sessions storage.TempStore, sessionId string) (string, bool, error) {
+	namespaced, ok := sessions.(*storage.NamespacedTempStore)
+	if !ok || strings.Contains(sessionId, ":") {
+		return "", false, nil
+	}
+	return storage.TempStoreV2Of(namespaced.Store()).Get(ctx, sessionId)
+}
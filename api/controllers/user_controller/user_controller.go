@@ -2,8 +2,11 @@ package user_controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/Iyusuf40/goBackendUtils/api/controllers"
 	"github.com/Iyusuf40/goBackendUtils/config"
@@ -17,7 +20,32 @@ var UserStorage = storage.MakeUserStorage(config.UsersDatabase,
 var SIGN_UP_HANDLER = controllers.MakeSignupHandler(config.TempStoreDb,
 	config.UsersDatabase, config.UsersRecords)
 
+// usersOf returns the storage of the users of the tenant of c,
+// reading the writes of the session of c
+func usersOf(c echo.Context) (storage.Storage[models.User], error) {
+	tenant, err := controllers.ResolveTenant(c)
+	if err != nil {
+		return nil, err
+	}
+	users, err := UserStorage.ForTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+	return users.ForSession(controllers.RequestSession(c)), nil
+}
+
 func SaveUser(c echo.Context) error {
+	tenant, err := controllers.ResolveTenant(c)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	tenantUsers, err := UserStorage.ForTenant(tenant)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+	users := tenantUsers.ForSession(controllers.RequestSession(c))
+
 	body := controllers.GetBodyInMap(c)
 	userDesc, ok := body["data"].(map[string]any)
 	response := map[string]string{}
@@ -27,42 +55,67 @@ func SaveUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, response)
 	}
 
-	user := UserStorage.BuildClient(userDesc)
+	user, err := users.BuildClient(userDesc)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	// validated here too as signups are only saved once completed
+	if err := storage.Validate(user); err != nil {
+		return controllers.RespondWithError(c, err)
+	}
 
-	if userWithEmailExist(user.Email) {
+	if userWithEmailExist(users, user.Email) {
 		response["error"] = "email already registered"
-		return c.JSON(http.StatusBadRequest, response)
+		return c.JSON(http.StatusConflict, response)
 	}
 
 	if config.RequireEmailVerification {
-		signupId := SIGN_UP_HANDLER.HandleSignup(user)
+		signupHandler, err := SIGN_UP_HANDLER.ForTenant(tenant)
+		if err != nil {
+			return controllers.RespondWithError(c, err)
+		}
+		signupId, err := signupHandler.HandleSignupContext(c.Request().Context(), user)
+		if err != nil {
+			return controllers.RespondWithError(c, err)
+		}
 		response["signupId"] = signupId
 		return c.JSON(http.StatusCreated, response)
 	}
 
-	msg, success := UserStorage.Save(user)
+	userId, err := users.Save(user)
 
-	if !success {
-		response["error"] = msg
-		return c.JSON(http.StatusBadRequest, response)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
 	}
 
-	response["userId"] = msg
+	response["userId"] = userId
 	return c.JSON(http.StatusCreated, response)
 }
 
-func userWithEmailExist(email string) bool {
-	users := UserStorage.GetByField("email", email)
-	return len(users) >= 1
+func userWithEmailExist(users storage.Storage[models.User], email string) bool {
+	return len(users.GetByField("email", email)) >= 1
 }
 
 func CompleteSignup(c echo.Context) error {
 	signupId := c.Param("signupId")
 	response := map[string]string{}
-	userId, success := SIGN_UP_HANDLER.HandleCompleteSignup(signupId)
-	if !success {
-		response["error"] = "failed to complete signup"
-		return c.JSON(http.StatusBadRequest, response)
+
+	// confirmation links carry no tenant, unless sent to its
+	// subdomain, the signup knows it
+	tenant, err := controllers.ResolveTenant(c)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	signupHandler, err := SIGN_UP_HANDLER.ForTenant(tenant)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	userId, err := signupHandler.HandleCompleteSignupContext(c.Request().Context(), signupId)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
 	}
 
 	response["userId"] = userId
@@ -70,18 +123,83 @@ func CompleteSignup(c echo.Context) error {
 }
 
 func GetUser(c echo.Context) error {
+	users, err := usersOf(c)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
 	userId := c.Param("id")
-	user, err := UserStorage.Get(userId)
-	response := map[string]string{}
+	user, err := users.Get(userId)
 	if err != nil {
-		response["error"] = "user with id " + userId + " doesn't exist"
-		return c.JSON(http.StatusNotFound, response)
+		return controllers.RespondWithError(c, err)
+	}
+
+	if user.Version > 0 {
+		c.Response().Header().Set("ETag", controllers.MakeETag(user.Version))
 	}
 
 	return c.JSON(http.StatusOK, getUserMapWithoutPassword(user))
 }
 
+// number of users SearchUsers responds with if no limit is asked
+const DEFAULT_SEARCH_LIMIT = 20
+
+// SearchUsers responds with the users matching the q query param,
+// best match first, each with its id and score. The fields param
+// lists the comma separated fields to search, email, firstName and
+// lastName by default, and the limit param caps the number of users
+func SearchUsers(c echo.Context) error {
+	response := map[string]any{}
+
+	query := c.QueryParam("q")
+	if query == "" {
+		response["error"] = "q query param is required"
+		return c.JSON(http.StatusBadRequest, response)
+	}
+
+	limit := DEFAULT_SEARCH_LIMIT
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 {
+			response["error"] = "limit query param must be a positive integer"
+			return c.JSON(http.StatusBadRequest, response)
+		}
+		limit = parsed
+	}
+
+	var fields []string
+	if fieldsParam := c.QueryParam("fields"); fieldsParam != "" {
+		fields = strings.Split(fieldsParam, ",")
+	}
+
+	users, err := usersOf(c)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	hits, err := users.Search(query, fields, limit)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	found := []map[string]any{}
+	for _, hit := range hits {
+		user := getUserMapWithoutPassword(hit.Data)
+		user["id"] = hit.ID
+		user["score"] = hit.Score
+		found = append(found, user)
+	}
+
+	response["users"] = found
+	return c.JSON(http.StatusOK, response)
+}
+
 func UpdateUser(c echo.Context) error {
+	users, err := usersOf(c)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
 	body := controllers.GetBodyInMap(c)
 	updateDesc, ok := body["data"].(map[string]any)
 	response := map[string]string{}
@@ -101,14 +219,54 @@ func UpdateUser(c echo.Context) error {
 
 	userId := c.Param("id")
 
-	updated := UserStorage.Update(userId, storage.UpdateDesc{Field: field,
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch != "" && ifMatch != "*" {
+		return updateUserIfVersion(c, users, userId, field, value, ifMatch)
+	}
+
+	err = users.Update(userId, storage.UpdateDesc{Field: field,
 		Value: value})
 
-	if !updated {
-		response["error"] = "update failed"
-		return c.JSON(http.StatusBadRequest, response)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	response["message"] = fmt.Sprintf("%s field of user succesfuly set to %s", field, value)
+	return c.JSON(http.StatusOK, response)
+}
+
+// updateUserIfVersion updates the user only if the version in the
+// If-Match header is the current version of the user
+func updateUserIfVersion(c echo.Context, users storage.Storage[models.User], userId, field string, value any, ifMatch string) error {
+	response := map[string]string{}
+
+	expectedVersion, ok := controllers.ParseIfMatch(ifMatch)
+	if !ok {
+		response["error"] = "If-Match header is not a valid ETag"
+		return c.JSON(http.StatusPreconditionFailed, response)
+	}
+
+	err := users.UpdateIfVersion(userId, storage.UpdateDesc{Field: field,
+		Value: value}, expectedVersion)
+
+	// no ETag was given out that could match
+	if errors.Is(err, storage.ErrVersionsNotTracked) {
+		response["error"] = "user versions are not tracked, retry without If-Match"
+		return c.JSON(http.StatusPreconditionFailed, response)
+	}
+
+	var conflict *storage.VersionConflictError
+	if errors.As(err, &conflict) {
+		c.Response().Header().Set("ETag", controllers.MakeETag(conflict.Actual))
+		response["error"] = "user was modified, fetch it and retry"
+		return c.JSON(http.StatusPreconditionFailed, response)
+	}
+
+	if err != nil {
+		return controllers.RespondWithError(c, err)
 	}
 
+	c.Response().Header().Set("ETag", controllers.MakeETag(expectedVersion+1))
 	response["message"] = fmt.Sprintf("%s field of user succesfuly set to %s", field, value)
 	return c.JSON(http.StatusOK, response)
 }
@@ -117,7 +275,33 @@ func DeleteUser(c echo.Context) error {
 	userId := c.Param("id")
 	response := map[string]string{"message": "deleted"}
 
-	UserStorage.Delete(userId)
+	users, err := usersOf(c)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	err = users.Delete(userId)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+func RestoreUser(c echo.Context) error {
+	userId := c.Param("id")
+	response := map[string]string{}
+
+	users, err := usersOf(c)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	err = users.Restore(userId)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	response["message"] = "restored"
 	return c.JSON(http.StatusOK, response)
 }
 
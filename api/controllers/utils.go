@@ -2,8 +2,16 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/Iyusuf40/goBackendUtils/config"
+	"github.com/Iyusuf40/goBackendUtils/storage"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
@@ -17,3 +25,71 @@ func ReadFromReaderIntoMap(r io.Reader) map[string]any {
 	json.Unmarshal(body, &bodyMap)
 	return bodyMap
 }
+
+// RequestSession returns the session whose writes the reads of c
+// must see, see storage.Storage.ForSession: the session in the
+// SessionHeader header, else one of c alone
+func RequestSession(c echo.Context) string {
+	if session := c.Request().Header.Get(config.SessionHeader); session != "" {
+		return session
+	}
+
+	session, ok := c.Get("requestSession").(string)
+	if !ok {
+		session = "request:" + uuid.NewString()
+		c.Set("requestSession", session)
+	}
+	return session
+}
+
+// MakeETag returns the ETag header value of a record version
+func MakeETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ParseIfMatch returns the version in an If-Match header value
+// created with MakeETag. weak ETags are accepted
+func ParseIfMatch(ifMatch string) (int, bool) {
+	ifMatch = strings.TrimPrefix(strings.TrimSpace(ifMatch), "W/")
+	version, err := strconv.Atoi(strings.Trim(ifMatch, `"`))
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// ErrorStatus returns the HTTP status code of err, an error
+// returned by storage or ResolveTenant
+func ErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTenantMismatch):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrDuplicate), errors.Is(err, storage.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrBackend):
+		// e.g. redis is unreachable, which may not last
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// RespondWithError responds with err in the error field of the
+// body and the status code of err. The fields rejected by a
+// *storage.ValidationError are also sent, in the fields field,
+// and every rule they failed in the errors field
+func RespondWithError(c echo.Context, err error) error {
+	response := map[string]any{"error": err.Error()}
+
+	var validationErr *storage.ValidationError
+	if errors.As(err, &validationErr) {
+		response["fields"] = validationErr.Fields
+		response["errors"] = validationErr.Errors
+	}
+
+	return c.JSON(ErrorStatus(err), response)
+}
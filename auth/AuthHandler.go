@@ -1,9 +1,13 @@
 package auth
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/Iyusuf40/goBackendUtils/api/controllers"
 	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/Iyusuf40/goBackendUtils/mail"
 	"github.com/Iyusuf40/goBackendUtils/models"
@@ -12,54 +16,220 @@ import (
 )
 
 type AuthHandler struct {
-	temp_store  storage.TempStore
-	users_store storage.Storage[models.User]
+	session_store storage.TempStoreV2
+	// sets of the session ids of each user, keyed by the value
+	// of their sessions
+	user_sessions_store storage.TempStoreV2
+	reset_token_store   storage.TempStoreV2
+	// the temp store sessions and reset tokens were kept in,
+	// un-prefixed, before they had a namespace
+	legacy_store storage.TempStoreV2
+	users_store  storage.Storage[models.User]
+	tenant       string
 }
 
 const DEFAULT_SESSION_TIMEOUT = 86400.0
 
+// the namespace of the temp store password reset tokens are kept in
+const PASSWORD_RESET_NAMESPACE = "reset"
+
+// the namespace of the temp store the sessions of each user are
+// listed in, to end them all without scanning every session
+const USER_SESSIONS_NAMESPACE = "user_sessions"
+
+// the methods ending in Context fail with an ErrBackend if the temp
+// store does, e.g. if redis is unreachable, the others read such a
+// failure as a missing session or token
+
+// ForTenant returns an AuthHandler logging in the users of tenant.
+// Its sessions are only valid for handlers of the same tenant
+func (auth_h *AuthHandler) ForTenant(tenant string) (*AuthHandler, error) {
+	users_store, err := auth_h.users_store.ForTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthHandler{session_store: auth_h.session_store, user_sessions_store: auth_h.user_sessions_store,
+		reset_token_store: auth_h.reset_token_store, legacy_store: auth_h.legacy_store,
+		users_store: users_store, tenant: tenant}, nil
+}
+
 func (auth_h *AuthHandler) HandleLogin(email, password string) string {
+	sessionId, _ := auth_h.HandleLoginContext(context.Background(), email, password)
+	return sessionId
+}
+
+// HandleLoginContext returns the id of a new session of the user
+// of email, or "" if the credentials are wrong
+func (auth_h *AuthHandler) HandleLoginContext(ctx context.Context, email, password string) (string, error) {
 	retrievedUsers := auth_h.users_store.GetByField("email", email)
 
 	if len(retrievedUsers) == 0 {
-		return ""
+		return "", nil
 	}
 
 	user := retrievedUsers[0]
 	if !user.IsCorrectPassword(password) {
-		return ""
+		return "", nil
 	}
 
 	sessionId := uuid.NewString()
 	userId := auth_h.users_store.GetIdByField("email", email)
+	owner := controllers.MakeTenantValue(auth_h.tenant, userId)
 
-	auth_h.temp_store.SetKeyToValWIthExpiry(sessionId, userId, DEFAULT_SESSION_TIMEOUT)
+	err := auth_h.session_store.Set(ctx, sessionId, owner, DEFAULT_SESSION_TIMEOUT*time.Second)
+	if err != nil {
+		return "", err
+	}
 
-	return sessionId
+	// listed after it is set, so that a session listed exists
+	// or expired
+	if _, err = auth_h.user_sessions_store.SAdd(ctx, owner, sessionId); err != nil {
+		return "", err
+	}
+	if err = auth_h.extendUserSessions(ctx, owner, DEFAULT_SESSION_TIMEOUT*time.Second); err != nil {
+		return "", err
+	}
+
+	return sessionId, nil
+}
+
+// extendUserSessions keeps the list of the sessions of owner for
+// ttl at least, as long as the sessions it lists may live
+func (auth_h *AuthHandler) extendUserSessions(ctx context.Context, owner string, ttl time.Duration) error {
+	current, _, err := auth_h.user_sessions_store.TTL(ctx, owner)
+	if err != nil || (current >= ttl && current != storage.TTL_NO_EXPIRY) {
+		return err
+	}
+	_, err = auth_h.user_sessions_store.Expire(ctx, owner, ttl)
+	return err
 }
 
 func (auth_h *AuthHandler) HandleLogout(sessionId string) {
-	auth_h.temp_store.DelKey(sessionId)
+	auth_h.HandleLogoutContext(context.Background(), sessionId)
+}
+
+func (auth_h *AuthHandler) HandleLogoutContext(ctx context.Context, sessionId string) error {
+	isLoggedIn, err := auth_h.IsLoggedInContext(ctx, sessionId)
+	if err != nil || !isLoggedIn {
+		return err
+	}
+
+	owner, _, err := auth_h.session_store.Get(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	if _, err = auth_h.session_store.Delete(ctx, sessionId); err != nil {
+		return err
+	}
+	_, err = auth_h.user_sessions_store.SRem(ctx, owner, sessionId)
+	return err
+}
+
+// HandleLogoutAll ends every session of the user of sessionId, e.g.
+// to log them out of the devices they lost, returning how many
+func (auth_h *AuthHandler) HandleLogoutAll(sessionId string) int {
+	loggedOut, _ := auth_h.HandleLogoutAllContext(context.Background(), sessionId)
+	return loggedOut
+}
+
+// HandleLogoutAllContext is HandleLogoutAll. The sessions ended
+// before the temp store failed, if it does, are counted
+func (auth_h *AuthHandler) HandleLogoutAllContext(ctx context.Context, sessionId string) (int, error) {
+	isLoggedIn, err := auth_h.IsLoggedInContext(ctx, sessionId)
+	if err != nil || !isLoggedIn {
+		return 0, err
+	}
+
+	owner, _, err := auth_h.session_store.Get(ctx, sessionId)
+	if err != nil {
+		return 0, err
+	}
+
+	sessionIds, err := auth_h.user_sessions_store.SMembers(ctx, owner)
+	if err != nil {
+		return 0, err
+	}
+	if !slices.Contains(sessionIds, sessionId) {
+		sessionIds = append(sessionIds, sessionId)
+	}
+
+	loggedOut := 0
+	for _, listedSessionId := range sessionIds {
+		deleted, err := auth_h.session_store.Delete(ctx, listedSessionId)
+		if err != nil {
+			return loggedOut, err
+		}
+		if deleted {
+			loggedOut++
+		}
+	}
+
+	// only the sessions ended are unlisted, a session made
+	// meanwhile stays listed
+	_, err = auth_h.user_sessions_store.SRem(ctx, owner, sessionIds...)
+	return loggedOut, err
+}
+
+// adoptLegacySession moves a session made before sessions had a
+// namespace into it, and lists it with the sessions of its user, so
+// that upgrading logs no one out. See storage.AdoptLegacyKey
+func (auth_h *AuthHandler) adoptLegacySession(ctx context.Context, sessionId string) error {
+	owner, adopted, err := storage.AdoptLegacyKey(ctx, auth_h.session_store, auth_h.legacy_store, sessionId)
+	if err != nil || !adopted {
+		return err
+	}
+
+	if _, err = auth_h.user_sessions_store.SAdd(ctx, owner, sessionId); err != nil {
+		return err
+	}
+	return auth_h.extendUserSessions(ctx, owner, DEFAULT_SESSION_TIMEOUT*time.Second)
 }
 
 func (auth_h *AuthHandler) IsLoggedIn(sessionId string) bool {
-	return auth_h.temp_store.GetVal(sessionId) != ""
+	isLoggedIn, _ := auth_h.IsLoggedInContext(context.Background(), sessionId)
+	return isLoggedIn
+}
+
+func (auth_h *AuthHandler) IsLoggedInContext(ctx context.Context, sessionId string) (bool, error) {
+	if err := auth_h.adoptLegacySession(ctx, sessionId); err != nil {
+		return false, err
+	}
+
+	stored, _, err := auth_h.session_store.Get(ctx, sessionId)
+	if err != nil {
+		return false, err
+	}
+	tenant, userId := controllers.SplitTenantValue(stored)
+	return userId != "" && tenant == auth_h.tenant, nil
 }
 
 func (auth_h *AuthHandler) HandleForgotPassword(email string) string {
+	passwordResetToken, _ := auth_h.HandleForgotPasswordContext(context.Background(), email)
+	return passwordResetToken
+}
+
+// HandleForgotPasswordContext mails the user of email a link to
+// reset their password, returning its token, or "" if there is no
+// user of email
+func (auth_h *AuthHandler) HandleForgotPasswordContext(ctx context.Context, email string) (string, error) {
 	userId := auth_h.users_store.GetIdByField("email", email)
 	if userId == "" {
 		fmt.Println("HandleForgotPassword: user does not exist")
-		return ""
+		return "", nil
 	}
 
 	passwordResetToken := uuid.New().String()
 
-	auth_h.sendPasswordResetEmail(email, passwordResetToken)
+	// kept first, so that no link is sent that cannot be used
+	err := auth_h.reset_token_store.Set(ctx, passwordResetToken,
+		controllers.MakeTenantValue(auth_h.tenant, userId), 3600*time.Second)
+	if err != nil {
+		return "", err
+	}
 
-	auth_h.temp_store.SetKeyToValWIthExpiry(passwordResetToken, userId, 3600)
+	auth_h.sendPasswordResetEmail(email, passwordResetToken)
 
-	return passwordResetToken
+	return passwordResetToken, nil
 }
 
 func (auth_h *AuthHandler) sendPasswordResetEmail(email, passwordResetToken string) {
@@ -89,36 +259,94 @@ func (auth_h *AuthHandler) sendPasswordResetEmail(email, passwordResetToken stri
 }
 
 func (auth_h *AuthHandler) HandleUpdatePassword(passwordResetToken, newPassword string) bool {
+	return auth_h.HandleUpdatePasswordContext(context.Background(), passwordResetToken, newPassword) == nil
+}
+
+// HandleUpdatePasswordContext sets the password of the user of
+// passwordResetToken to newPassword. An empty password is an
+// ErrValidation, a token missing or used an ErrNotFound
+func (auth_h *AuthHandler) HandleUpdatePasswordContext(ctx context.Context,
+	passwordResetToken, newPassword string) error {
 
 	if newPassword == "" {
-		fmt.Println("password cannot be empty")
-		return false
+		return fmt.Errorf("%w: password cannot be empty", storage.ErrValidation)
 	}
 
-	userId := auth_h.temp_store.GetVal(passwordResetToken)
-	if userId == "" {
-		fmt.Println("passwordResetToken has no value in store")
-		return false
+	_, _, err := storage.AdoptLegacyKey(ctx, auth_h.reset_token_store, auth_h.legacy_store, passwordResetToken)
+	if err != nil {
+		return err
+	}
+
+	stored, _, err := auth_h.reset_token_store.Get(ctx, passwordResetToken)
+	if err != nil {
+		return err
+	}
+
+	// reset links carry no tenant, the token knows it
+	tenant, userId := controllers.SplitTenantValue(stored)
+	if userId == "" || (auth_h.tenant != "" && tenant != auth_h.tenant) {
+		return fmt.Errorf("%w: passwordResetToken has no value in store", storage.ErrNotFound)
+	}
+
+	// reset tokens are single use
+	ttl, _, err := auth_h.reset_token_store.TTL(ctx, passwordResetToken)
+	if err != nil {
+		return err
+	}
+	tokenValue, claimed, err := auth_h.reset_token_store.GetAndDelete(ctx, passwordResetToken)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return fmt.Errorf("%w: passwordResetToken has no value in store", storage.ErrNotFound)
+	}
+
+	users_store, err := auth_h.users_store.ForTenant(tenant)
+	if err != nil {
+		return err
 	}
 
-	_, err := auth_h.users_store.Get(userId)
+	_, err = users_store.Get(userId)
 
 	if err != nil {
-		fmt.Println("user with userId:" + userId + " does not exist")
-		return false
+		return err
 	}
 
-	return auth_h.users_store.Update(userId,
+	err = users_store.Update(userId,
 		storage.UpdateDesc{Field: "password", Value: newPassword})
+
+	if err != nil {
+		// e.g. a password too weak, the token is given back to
+		// retry with another until it expires
+		if ttl > 0 {
+			auth_h.reset_token_store.SetNX(ctx, passwordResetToken, tokenValue, ttl)
+		}
+		return err
+	}
+	return nil
 }
 
 func (auth_h *AuthHandler) ExtendSession(sessionId string, duration float64) {
-	auth_h.temp_store.ChangeKeyEpiry(sessionId, duration)
+	ctx := context.Background()
+	ttl := time.Duration(duration * float64(time.Second))
+
+	auth_h.adoptLegacySession(ctx, sessionId)
+	owner, found, _ := auth_h.session_store.Get(ctx, sessionId)
+	if extended, _ := auth_h.session_store.Expire(ctx, sessionId, ttl); extended && found {
+		auth_h.extendUserSessions(ctx, owner, ttl)
+	}
 }
 
 func MakeAuthHandler(temp_store_db, users_store_db, recordsName string) *AuthHandler {
 	auth_h := new(AuthHandler)
-	auth_h.temp_store = storage.GET_TempStore(config.TempStoreType, temp_store_db, recordsName)
+	temp_store := storage.GET_TempStore(config.TempStoreType, temp_store_db, recordsName)
+	auth_h.session_store = storage.TempStoreV2Of(
+		storage.MakeNamespacedTempStore(temp_store, controllers.SESSIONS_NAMESPACE))
+	auth_h.user_sessions_store = storage.TempStoreV2Of(
+		storage.MakeNamespacedTempStore(temp_store, USER_SESSIONS_NAMESPACE))
+	auth_h.reset_token_store = storage.TempStoreV2Of(
+		storage.MakeNamespacedTempStore(temp_store, PASSWORD_RESET_NAMESPACE))
+	auth_h.legacy_store = storage.TempStoreV2Of(temp_store)
 	auth_h.users_store = storage.MakeUserStorage(users_store_db, recordsName)
 	return auth_h
 }
@@ -29,6 +29,7 @@ func ServeAUTH() {
 
 	g.POST("/login", Login)
 	g.GET("/logout", Logout)
+	g.GET("/logout_all", LogoutAll)
 
 	g.PUT("/isloggedin", IsLoggedIn)
 
@@ -41,6 +42,16 @@ func ServeAUTH() {
 var AUTH_HANDLER = MakeAuthHandler(config.TempStoreDb,
 	config.UsersDatabase, config.UsersRecords)
 
+// authHandlerOf returns the AuthHandler of the tenant of c, whose
+// session, if any, is sessionId
+func authHandlerOf(c echo.Context, sessionId string) (*AuthHandler, error) {
+	tenant, err := controllers.ResolveTenantWithSession(c, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	return AUTH_HANDLER.ForTenant(tenant)
+}
+
 func Login(c echo.Context) error {
 	body := controllers.GetBodyInMap(c)
 	userDesc, ok := body["data"].(map[string]any)
@@ -65,7 +76,15 @@ func Login(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, response)
 	}
 
-	sessionId := AUTH_HANDLER.HandleLogin(email, password)
+	authHandler, err := authHandlerOf(c, "")
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	sessionId, err := authHandler.HandleLoginContext(c.Request().Context(), email, password)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
 
 	if sessionId == "" {
 		response["error"] = "failed to login"
@@ -92,11 +111,51 @@ func Logout(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, response)
 	}
 
-	AUTH_HANDLER.HandleLogout(sessionId)
+	authHandler, err := authHandlerOf(c, sessionId)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	if err := authHandler.HandleLogoutContext(c.Request().Context(), sessionId); err != nil {
+		return controllers.RespondWithError(c, err)
+	}
 	response["message"] = "logged out"
 	return c.JSON(http.StatusOK, response)
 }
 
+// LogoutAll logs the user of the session out of all their sessions
+func LogoutAll(c echo.Context) error {
+	body := controllers.GetBodyInMap(c)
+	sessionDesc, ok := body["data"].(map[string]any)
+	response := map[string]any{}
+
+	if !ok {
+		response["error"] = "data payload is not decodeable into a map"
+		return c.JSON(http.StatusBadRequest, response)
+	}
+
+	sessionId, sessionId_ok := sessionDesc["sessionId"].(string)
+
+	if !sessionId_ok {
+		response["error"] = "sessionId required to logout"
+		return c.JSON(http.StatusBadRequest, response)
+	}
+
+	authHandler, err := authHandlerOf(c, sessionId)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	loggedOut, err := authHandler.HandleLogoutAllContext(c.Request().Context(), sessionId)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	response["message"] = "logged out"
+	response["sessions"] = loggedOut
+	return c.JSON(http.StatusOK, response)
+}
+
 func IsLoggedIn(c echo.Context) error {
 	body := controllers.GetBodyInMap(c)
 	sessionDesc, ok := body["data"].(map[string]any)
@@ -114,7 +173,16 @@ func IsLoggedIn(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, response)
 	}
 
-	isLoggedIn := AUTH_HANDLER.IsLoggedIn(sessionId)
+	authHandler, err := authHandlerOf(c, sessionId)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	// an unreachable temp store is not a logged out user
+	isLoggedIn, err := authHandler.IsLoggedInContext(c.Request().Context(), sessionId)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
 	response["isLoggedIn"] = isLoggedIn
 
 	return c.JSON(http.StatusOK, response)
@@ -128,7 +196,15 @@ func ForgotPassword(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, response)
 	}
 
-	passwordResetToken := AUTH_HANDLER.HandleForgotPassword(email)
+	authHandler, err := authHandlerOf(c, "")
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	passwordResetToken, err := authHandler.HandleForgotPasswordContext(c.Request().Context(), email)
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
 	if passwordResetToken == "" {
 		response := map[string]any{"error": "User not found or email sending failed"}
 		return c.JSON(http.StatusNotFound, response)
@@ -151,9 +227,17 @@ func ResetPassword(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, response)
 	}
 
-	if !AUTH_HANDLER.HandleUpdatePassword(passwordResetToken, newPassword) {
-		response := map[string]any{"error": "Failed to update password"}
-		return c.JSON(http.StatusInternalServerError, response)
+	// reset links carry no tenant, unless sent to its
+	// subdomain, the token knows it
+	authHandler, err := authHandlerOf(c, "")
+	if err != nil {
+		return controllers.RespondWithError(c, err)
+	}
+
+	err = authHandler.HandleUpdatePasswordContext(c.Request().Context(), passwordResetToken, newPassword)
+	if err != nil {
+		// an unknown or used token is a 404, a weak password a 400
+		return controllers.RespondWithError(c, err)
 	}
 
 	response := map[string]any{"message": "Password updated successfully"}
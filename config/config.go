@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 var ApiPort = "8081"
 var AuthPort = "8082"
 var BaseApiUrl = "http://localhost:" + ApiPort + "/api/"
@@ -35,6 +37,41 @@ var UsersDatabase = "test"
 var UsersRecords = "users"
 var UserPassowrdHashCost = 4
 
+// if TrackRecordMetadata is set to true, engines created afterwards
+// maintain a createdAt and updatedAt timestamp (unix milliseconds)
+// and a version, starting at 1 and incremented on every update,
+// on each record they save
+var TrackRecordMetadata = false
+
+// if SoftDelete is set to true, engines created afterwards mark
+// deleted records with a deletedAt timestamp instead of erasing
+// them. Such records are left out of reads and can be restored.
+// While serving the api, a background job erases records deleted
+// more than SoftDeleteRetention ago, every SoftDeletePurgeInterval
+var SoftDelete = false
+var SoftDeleteRetention = 30 * 24 * time.Hour
+var SoftDeletePurgeInterval = time.Hour
+
+// records saved with a TTL are left out of reads once expired,
+// and erased every ExpiredRecordsVacuumInterval: by postgres
+// engines, and by FileDbs holding such records, which also erase
+// them on load. mongo erases them itself, with a TTL index, about
+// every minute. 0 disables the periodic erasing
+var ExpiredRecordsVacuumInterval = time.Minute
+
+// if PostgresReplicaUrls is set, postgres engines created afterwards
+// send writes to the primary at DB_HOST and reads to these replicas
+// of it, in turn. A url naming no database gets the engine's one.
+// Replicas are checked every ReplicaHealthCheckInterval, the ones
+// failing are left out until they recover. For ReadYourWritesWindow
+// after a write made in a session, e.g. a login session, the reads
+// of that session go to the primary, so replication lag cannot hide
+// the write, 0 disables it. Other sessions keep reading from the
+// replicas, see storage.SessionDB_Engine
+var PostgresReplicaUrls = []string{}
+var ReplicaHealthCheckInterval = 10 * time.Second
+var ReadYourWritesWindow = time.Duration(0)
+
 func SetDBMS(dbms string) {
 	DBMS = dbms
 }
@@ -51,6 +88,38 @@ func SetDB_PASSWORD(db_password string) {
 	DB_PASSWORD = db_password
 }
 
+func SetPostgresReplicaUrls(urls ...string) {
+	PostgresReplicaUrls = urls
+}
+
+func SetReplicaHealthCheckInterval(interval time.Duration) {
+	ReplicaHealthCheckInterval = interval
+}
+
+func SetReadYourWritesWindow(window time.Duration) {
+	ReadYourWritesWindow = window
+}
+
+func SetExpiredRecordsVacuumInterval(interval time.Duration) {
+	ExpiredRecordsVacuumInterval = interval
+}
+
+func SetTrackRecordMetadata(track bool) {
+	TrackRecordMetadata = track
+}
+
+func SetSoftDelete(softDelete bool) {
+	SoftDelete = softDelete
+}
+
+func SetSoftDeleteRetention(retention time.Duration) {
+	SoftDeleteRetention = retention
+}
+
+func SetSoftDeletePurgeInterval(interval time.Duration) {
+	SoftDeletePurgeInterval = interval
+}
+
 func SetUsersDatabase(usersDatabase string) {
 	UsersDatabase = usersDatabase
 }
@@ -63,12 +132,50 @@ func SetUserPasswordHashCost(cost int) {
 	UserPassowrdHashCost = cost
 }
 
-// set to one of "file" or "redis". If you set it as redis ensure
-// to have a running instance setup properly
+// set to one of "file", "redis" or "memory". If you set it as redis
+// ensure to have a running instance setup properly. A memory temp
+// store lives in the process and is lost when it exits, unless
+// MemoryTempStoreSnapshotInterval is set
 var TempStoreType = "file"
 var TempStoreDb = "test"
+
+// how often memory temp stores are snapshot to a file, next to
+// TempStoreDb, they are reloaded from. 0 disables snapshots
+var MemoryTempStoreSnapshotInterval time.Duration = 0
+
+// RedisUrl is either host:port or a redis:// url, rediss:// for
+// TLS, which may name the username, password and db. RedisUsername,
+// RedisPassword and RedisDb, if set, override those of the url
 var RedisUrl = "localhost:6379"
+var RedisUsername = ""
 var RedisPassword = ""
+var RedisDb = 0
+
+// if RedisTLS is set, or any of the files, redis is connected to
+// over TLS, presenting the client certificate RedisTLSCertFile and
+// RedisTLSKeyFile and trusting the certificates in RedisTLSCAFile,
+// if set, over the system ones
+var RedisTLS = false
+var RedisTLSCertFile = ""
+var RedisTLSKeyFile = ""
+var RedisTLSCAFile = ""
+
+// the size of the connection pool and the timeouts of the redis
+// clients, 0 keeps the defaults of go-redis
+var RedisPoolSize = 0
+var RedisDialTimeout = time.Duration(0)
+var RedisReadTimeout = time.Duration(0)
+var RedisWriteTimeout = time.Duration(0)
+
+// if RedisSentinelMaster is set, redis is reached through the
+// sentinels at RedisSentinelAddrs, which fail over to a replica
+// if the master fails, RedisUrl is then only read for its options.
+// If RedisClusterAddrs is set instead, the cluster these nodes are
+// part of is connected to, which only has db 0
+var RedisSentinelMaster = ""
+var RedisSentinelAddrs = []string{}
+var RedisSentinelPassword = ""
+var RedisClusterAddrs = []string{}
 
 var GmailPassword = "your mail's app password"
 var GmailSource = "your_mail@mail.com"
@@ -111,6 +218,27 @@ var PasswordResetMessage = `
 // EmailConfirmationMessage
 var LinkSubstitute = "##link##"
 
+// CacheTTLs maps the name of records, e.g. UsersRecords, to how
+// long the engines made for them afterwards cache the records they
+// read in a temp store of TempStoreType. Records not in it are not
+// cached. The absence of a record is cached for CacheNegativeTTL.
+// With a file temp store, the cache is kept in process memory instead
+var CacheTTLs = map[string]time.Duration{}
+var CacheNegativeTTL = time.Minute
+var CacheDb = "cache"
+
+func SetCacheTTL(recordsName string, ttl time.Duration) {
+	CacheTTLs[recordsName] = ttl
+}
+
+func SetCacheNegativeTTL(ttl time.Duration) {
+	CacheNegativeTTL = ttl
+}
+
+func SetCacheDb(cacheDb string) {
+	CacheDb = cacheDb
+}
+
 func SetTempStoreType(storeType string) {
 	TempStoreType = storeType
 }
@@ -119,6 +247,10 @@ func SetTempStoreDb(tempStoreDb string) {
 	TempStoreDb = tempStoreDb
 }
 
+func SetMemoryTempStoreSnapshotInterval(interval time.Duration) {
+	MemoryTempStoreSnapshotInterval = interval
+}
+
 func SetRedisUrl(redisUrl string) {
 	RedisUrl = redisUrl
 }
@@ -127,6 +259,41 @@ func SetRedisPassword(redisPassword string) {
 	RedisPassword = redisPassword
 }
 
+func SetRedisUsername(redisUsername string) {
+	RedisUsername = redisUsername
+}
+
+func SetRedisDb(redisDb int) {
+	RedisDb = redisDb
+}
+
+func SetRedisTLS(certFile, keyFile, caFile string) {
+	RedisTLS = true
+	RedisTLSCertFile = certFile
+	RedisTLSKeyFile = keyFile
+	RedisTLSCAFile = caFile
+}
+
+func SetRedisPoolSize(poolSize int) {
+	RedisPoolSize = poolSize
+}
+
+func SetRedisTimeouts(dial, read, write time.Duration) {
+	RedisDialTimeout = dial
+	RedisReadTimeout = read
+	RedisWriteTimeout = write
+}
+
+func SetRedisSentinel(masterName, password string, addrs ...string) {
+	RedisSentinelMaster = masterName
+	RedisSentinelPassword = password
+	RedisSentinelAddrs = addrs
+}
+
+func SetRedisClusterAddrs(addrs ...string) {
+	RedisClusterAddrs = addrs
+}
+
 func SetGmailPassword(gmailPassword string) {
 	GmailPassword = gmailPassword
 }
@@ -152,3 +319,30 @@ func SetLinkSubstitute(linkSubstitute string) {
 }
 
 var AllowAllOrigin = false
+
+// if MultiTenant is set to true, the api and auth routers serve
+// the users of the tenant of each request, read from the
+// TenantHeader header, else from the subdomain of TenantDomain
+// the request was sent to, e.g. acme for acme.example.com, else
+// from the session whose id is in the SessionHeader header.
+// Requests with no tenant are served the default tenant's users
+var MultiTenant = false
+var TenantHeader = "X-Tenant-ID"
+var TenantDomain = ""
+var SessionHeader = "X-Session-ID"
+
+func SetMultiTenant(multiTenant bool) {
+	MultiTenant = multiTenant
+}
+
+func SetTenantHeader(header string) {
+	TenantHeader = header
+}
+
+func SetTenantDomain(domain string) {
+	TenantDomain = domain
+}
+
+func SetSessionHeader(header string) {
+	SessionHeader = header
+}
@@ -0,0 +1,177 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/config"
+	"github.com/Iyusuf40/goBackendUtils/storage"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotAcquired is returned when a lock is held by another
+	// owner for longer than the caller waits
+	ErrNotAcquired = errors.New("lock is held by another owner")
+	// ErrNotHeld is returned renewing or releasing a lock whose
+	// lease expired, it may since be held by another owner
+	ErrNotHeld = errors.New("lock is no longer held")
+)
+
+// the namespace of the temp store locks are kept in
+const LOCKS_NAMESPACE = "lock"
+
+// how often Acquire tries again to take a lock held by another
+const LOCK_RETRY_INTERVAL = 50 * time.Millisecond
+
+// Locker hands out locks shared by every instance using the same
+// temp store, e.g. a redis. The locks of file and memory temp
+// stores only exclude the users of that store, in the process.
+// A lock is a key holding the token of its owner for the length
+// of its lease, so that a crashed owner does not hold it forever.
+// Errors of the temp store wrap storage.ErrBackend
+type Locker struct {
+	store storage.TempStoreV2
+}
+
+func (locker *Locker) New(store storage.TempStore) *Locker {
+	locker.store = storage.TempStoreV2Of(storage.MakeNamespacedTempStore(store, LOCKS_NAMESPACE))
+	return locker
+}
+
+// Lock is a lock held, until its lease ends, by its owner
+type Lock struct {
+	store storage.TempStoreV2
+	name  string
+	token string
+	lease time.Duration
+}
+
+// TryAcquire takes the lock name for lease, or fails with
+// ErrNotAcquired at once if another owner holds it
+func (locker *Locker) TryAcquire(ctx context.Context, name string, lease time.Duration) (*Lock, error) {
+	if lease <= 0 {
+		return nil, fmt.Errorf("%w: lease of lock %s must be positive", storage.ErrValidation, name)
+	}
+
+	token := uuid.NewString()
+	acquired, err := locker.store.SetNX(ctx, name, token, lease)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, fmt.Errorf("%w: %s", ErrNotAcquired, name)
+	}
+	return &Lock{store: locker.store, name: name, token: token, lease: lease}, nil
+}
+
+// Acquire takes the lock name for lease, waiting up to timeout for
+// its owner to release it or let its lease end
+func (locker *Locker) Acquire(ctx context.Context, name string, lease, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := locker.TryAcquire(ctx, name, lease)
+		if !errors.Is(err, ErrNotAcquired) || time.Now().After(deadline) {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(LOCK_RETRY_INTERVAL, time.Until(deadline))):
+		}
+	}
+}
+
+// WithLock runs fn holding the lock name, acquired as Acquire does.
+// The lease is renewed while fn runs, so that fn may outlast it. If
+// a renewal fails, the ctx of fn is cancelled, as the lock may be
+// taken by another owner, and the error of the renewal returned
+func (locker *Locker) WithLock(ctx context.Context, name string, lease, timeout time.Duration,
+	fn func(ctx context.Context) error) error {
+
+	lock, err := locker.Acquire(ctx, name, lease, timeout)
+	if err != nil {
+		return err
+	}
+
+	fnCtx, cancel := context.WithCancel(ctx)
+	renewed := make(chan error, 1)
+	go func() {
+		renewed <- lock.keepRenewed(fnCtx)
+		cancel()
+	}()
+
+	err = fn(fnCtx)
+	cancel()
+	if renewErr := <-renewed; renewErr != nil {
+		return renewErr
+	}
+
+	if releaseErr := lock.Release(context.WithoutCancel(ctx)); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// keepRenewed renews the lease of lock every third of it, until ctx
+// ends or a renewal fails
+func (lock *Lock) keepRenewed(ctx context.Context) error {
+	ticker := time.NewTicker(lock.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := lock.Renew(ctx); err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+func (lock *Lock) Name() string {
+	return lock.name
+}
+
+// Token is the token of the owner of lock, unique to each time a
+// lock is acquired
+func (lock *Lock) Token() string {
+	return lock.token
+}
+
+// Renew starts a new lease of lock, or fails with ErrNotHeld if its
+// lease ended
+func (lock *Lock) Renew(ctx context.Context) error {
+	renewed, err := lock.store.CompareAndExpire(ctx, lock.name, lock.token, lock.lease)
+	if err != nil {
+		return err
+	}
+	if !renewed {
+		return fmt.Errorf("%w: %s", ErrNotHeld, lock.name)
+	}
+	return nil
+}
+
+// Release releases lock, or fails with ErrNotHeld if its lease
+// ended, leaving the lock to whoever took it since
+func (lock *Lock) Release(ctx context.Context) error {
+	released, err := lock.store.CompareAndDelete(ctx, lock.name, lock.token)
+	if err != nil {
+		return err
+	}
+	if !released {
+		return fmt.Errorf("%w: %s", ErrNotHeld, lock.name)
+	}
+	return nil
+}
+
+// MakeLocker returns the Locker of the temp store of recordsName of
+// temp_store_db, of config.TempStoreType
+func MakeLocker(temp_store_db, recordsName string) *Locker {
+	return new(Locker).New(storage.GET_TempStore(config.TempStoreType, temp_store_db, recordsName))
+}
@@ -6,11 +6,17 @@ import (
 )
 
 type User struct {
-	Email     string `json:"email"`
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
-	Phone     int    `json:"phone"`
-	Password  string `json:"password"`
+	Email     string `json:"email" validate:"required,email,max=128"`
+	FirstName string `json:"firstName" validate:"max=128"`
+	LastName  string `json:"lastName" validate:"max=128"`
+	Phone     int    `json:"phone" validate:"min=0"`
+	Password  string `json:"password" validate:"required,min=8,max=128"`
+	// set by the storage engine if config.TrackRecordMetadata is true
+	CreatedAt int64 `json:"createdAt,omitempty"`
+	UpdatedAt int64 `json:"updatedAt,omitempty"`
+	Version   int   `json:"version,omitempty"`
+	// set by the storage engine on soft delete, see config.SoftDelete
+	DeletedAt int64 `json:"deletedAt,omitempty"`
 }
 
 func (user *User) HashPassword() {
@@ -2,10 +2,12 @@ package storage
 
 import (
 	"encoding/json"
-	"errors"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/google/uuid"
 )
 
@@ -14,6 +16,14 @@ type FileDb struct {
 	recordsName                string
 	inMemoryStore              map[string]any
 	RECORDS_NAME_KEY_SEPARATOR string
+	mu                         sync.RWMutex
+	trackMetadata              bool
+	softDelete                 bool
+	notifier                   changeNotifier
+	searchIndex                lazySearchIndex
+	// stops erasing expired records, nil until records
+	// with an expiry are saved or loaded
+	stopVacuuming func()
 }
 
 func (db *FileDb) New(db_path, recordsName string) (*FileDb, error) {
@@ -23,52 +33,195 @@ func (db *FileDb) New(db_path, recordsName string) (*FileDb, error) {
 	db.path = db_path
 	db.recordsName = recordsName
 	db.RECORDS_NAME_KEY_SEPARATOR = "-"
+	db.trackMetadata = config.TrackRecordMetadata
+	db.softDelete = config.SoftDelete
 	err := db.Reload()
 	return db, err
 }
 
 func (db *FileDb) Reload() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	db.inMemoryStore = make(map[string]any)
 	content, _ := os.ReadFile(db.path)
 
 	json.Unmarshal(content, &(db.inMemoryStore))
+	db.searchIndex.reset()
+
+	if db.purgeExpired() > 0 || db.hasExpiringRecords() {
+		db.startVacuuming()
+	}
 
 	return nil
 }
 
 func (db *FileDb) AllRecordsCount() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	return len(db.inMemoryStore)
 }
 
 func (db *FileDb) Save(obj any) (string, error) {
-	uid := uuid.NewString()
-	id := db.recordsName + db.RECORDS_NAME_KEY_SEPARATOR + uid
-	json_rep, err := json.Marshal(obj) // test if it can be jsoned
+	return db.SaveWithOptions(obj, SaveOptions{})
+}
+
+// SaveWithOptions is Save with options, a record saved with a TTL
+// is erased on load and every config.ExpiredRecordsVacuumInterval
+// once expired
+func (db *FileDb) SaveWithOptions(obj any, options SaveOptions) (string, error) {
+	saved_version, err := getMapRep(obj)
 	if err != nil {
 		return "", err
 	}
 
-	// save map[string]any rep
-	var saved_version map[string]any
-	json.Unmarshal(json_rep, &saved_version)
+	if db.trackMetadata && saved_version != nil {
+		setMetadataOnSave(saved_version)
+	}
+
+	if db.softDelete {
+		delete(saved_version, DELETED_AT_FIELD)
+	}
+
+	delete(saved_version, EXPIRES_AT_FIELD)
+	expiresAt, expires := options.expiresAt()
+	if expires && saved_version != nil {
+		saved_version[EXPIRES_AT_FIELD] = expiresAt
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
+	if expires {
+		db.startVacuuming()
+	}
+
+	id := db.newId()
 	db.inMemoryStore[id] = saved_version
+	db.notifyChange(INSERT_OP, id, saved_version)
 
 	return id, nil
 }
 
+// Upsert merges obj into the record whose field equals value,
+// or saves it as a new record if none matches. The check and the
+// write happen under the same lock so concurrent upserts of the
+// same value cannot both create a record. A field of "id" matches
+// on the record id. A soft deleted or expired match is restored
+// and no longer expires
+func (db *FileDb) Upsert(field string, value any, obj any) (string, bool, error) {
+	mapRep, err := getMapRep(obj)
+	if err != nil {
+		return "", false, err
+	}
+	if mapRep == nil {
+		mapRep = map[string]any{}
+	}
+
+	if db.trackMetadata {
+		removeMetadataFields(mapRep)
+	}
+
+	if db.softDelete {
+		delete(mapRep, DELETED_AT_FIELD)
+	}
+
+	delete(mapRep, EXPIRES_AT_FIELD)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	id := ""
+	if field == "id" {
+		id, _ = value.(string)
+		if !strings.HasPrefix(id, db.recordsName+db.RECORDS_NAME_KEY_SEPARATOR) {
+			return "", false, newValidationError("id", "format", "is not an id of "+db.recordsName)
+		}
+		if _, exists := db.inMemoryStore[id]; !exists {
+			db.insertUpserted(id, mapRep)
+			return id, true, nil
+		}
+	} else {
+		id = db.getIdByFieldAndValue(field, value, true)
+		if id == "" {
+			setValInMapOrNestedMap(field, value, &mapRep)
+			id = db.newId()
+			db.insertUpserted(id, mapRep)
+			return id, true, nil
+		}
+	}
+
+	stored := db.inMemoryStore[id].(map[string]any)
+	for key, val := range mapRep {
+		stored[key] = val
+	}
+
+	if db.softDelete {
+		delete(stored, DELETED_AT_FIELD)
+	}
+
+	delete(stored, EXPIRES_AT_FIELD)
+
+	if db.trackMetadata {
+		setMetadataOnUpdate(stored)
+	}
+
+	db.notifyChange(UPDATE_OP, id, stored)
+	return id, false, nil
+}
+
+func (db *FileDb) insertUpserted(id string, mapRep map[string]any) {
+	if db.trackMetadata {
+		setMetadataOnSave(mapRep)
+	}
+	db.inMemoryStore[id] = mapRep
+	db.notifyChange(INSERT_OP, id, mapRep)
+}
+
+func (db *FileDb) newId() string {
+	return db.recordsName + db.RECORDS_NAME_KEY_SEPARATOR + uuid.NewString()
+}
+
 // returns objects with any type so users can rebuild
-// objects with their type builders
+// objects with their type builders. Reads return copies of
+// the records, which writers change in place
 func (db *FileDb) Get(id string) (any, error) {
-	stored, found := db.inMemoryStore[id]
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stored, found := db.getLiveRecord(id)
 	if found {
-		return stored, nil
+		return copyRecord(stored), nil
 	}
-	return nil, errors.New("FileDb: Get: failed to get object with id: " + id)
+	return nil, notFoundError(id)
+}
+
+// getLiveRecord returns the record with id unless it is soft
+// deleted or expired
+func (db *FileDb) getLiveRecord(id string) (map[string]any, bool) {
+	stored, found := db.inMemoryStore[id].(map[string]any)
+	if !found || !db.isLive(stored) {
+		return nil, false
+	}
+	return stored, true
+}
+
+func (db *FileDb) isLive(record map[string]any) bool {
+	return (!db.softDelete || record[DELETED_AT_FIELD] == nil) && !isExpired(record, metadataNow())
 }
 
 func (db *FileDb) GetRecordsByField(field string, value any) ([]map[string]any, error) {
-	var listOfRecordsOfSameType = db.GetAllOfRecords()
+	return copyRecords(db.storedRecordsByField(field, value)), nil
+}
+
+// storedRecordsByField is GetRecordsByField returning the stored
+// maps themselves, for TempStoreFileDbImpl which changes them in place
+func (db *FileDb) storedRecordsByField(field string, value any) []map[string]any {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var listOfRecordsOfSameType = db.getAllOfRecords(false)
 
 	var listOfMatchedRecords []map[string]any
 	var compValue any
@@ -88,11 +241,17 @@ func (db *FileDb) GetRecordsByField(field string, value any) ([]map[string]any,
 		}
 	}
 
-	return listOfMatchedRecords, nil
+	return listOfMatchedRecords
 }
 
 func (db *FileDb) GetIdByFieldAndValue(field string, value any) string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getIdByFieldAndValue(field, value, false)
+}
 
+func (db *FileDb) getIdByFieldAndValue(field string, value any, includeDeleted bool) string {
 	recordsName := db.recordsName
 	for key, val := range db.inMemoryStore {
 		if strings.HasPrefix(key, recordsName) {
@@ -102,6 +261,10 @@ func (db *FileDb) GetIdByFieldAndValue(field string, value any) string {
 					map[string]any type` + recordsName)
 			}
 
+			if !includeDeleted && !db.isLive(concVal) {
+				continue
+			}
+
 			valAtField, exists := getValInNestedFieldOfMap(field, concVal)
 
 			if !exists {
@@ -123,6 +286,31 @@ func (db *FileDb) GetIdByFieldAndValue(field string, value any) string {
 }
 
 func (db *FileDb) GetAllOfRecords() []map[string]any {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return copyRecords(db.getAllOfRecords(false))
+}
+
+// GetAllOfRecordsIncludingDeleted is GetAllOfRecords with
+// soft deleted records included
+func (db *FileDb) GetAllOfRecordsIncludingDeleted() []map[string]any {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return copyRecords(db.getAllOfRecords(true))
+}
+
+func copyRecords(records []map[string]any) []map[string]any {
+	for i, record := range records {
+		records[i] = copyRecord(record)
+	}
+	return records
+}
+
+// getAllOfRecords returns the stored maps themselves, they must
+// be copied before db.mu is released
+func (db *FileDb) getAllOfRecords(includeDeleted bool) []map[string]any {
 	var listOfRecordsOfSameType []map[string]any
 	recordsName := db.recordsName
 	for key, val := range db.inMemoryStore {
@@ -132,6 +320,13 @@ func (db *FileDb) GetAllOfRecords() []map[string]any {
 				panic(`FileDb: GetRecordsByField: records found for is not of  
 					map[string]any type` + recordsName)
 			}
+			if !includeDeleted && !db.isLive(concVal) {
+				continue
+			}
+			// expired records are never read
+			if includeDeleted && isExpired(concVal, metadataNow()) {
+				continue
+			}
 			listOfRecordsOfSameType = append(listOfRecordsOfSameType, concVal)
 		}
 	}
@@ -139,28 +334,268 @@ func (db *FileDb) GetAllOfRecords() []map[string]any {
 	return listOfRecordsOfSameType
 }
 
-func (db *FileDb) Delete(id string) {
-	delete(db.inMemoryStore, id)
+// Delete erases the record, or if config.SoftDelete was set,
+// marks it deleted with a deletedAt timestamp
+func (db *FileDb) Delete(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, found := db.getLiveRecord(id)
+	if !found {
+		return notFoundError(id)
+	}
+
+	if db.softDelete {
+		stored[DELETED_AT_FIELD] = metadataNow()
+	} else {
+		delete(db.inMemoryStore, id)
+	}
+	db.notifyChange(DELETE_OP, id, nil)
+	return nil
+}
+
+// Restore undoes the soft delete of the record with id
+func (db *FileDb) Restore(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, found := db.inMemoryStore[id].(map[string]any)
+	if !found || !db.softDelete || stored[DELETED_AT_FIELD] == nil {
+		return notFoundError(id)
+	}
+
+	delete(stored, DELETED_AT_FIELD)
+	db.notifyChange(UPDATE_OP, id, stored)
+	return nil
+}
+
+func (db *FileDb) GetDeleted(id string) (any, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stored, found := db.inMemoryStore[id].(map[string]any)
+	if !found || !db.softDelete || stored[DELETED_AT_FIELD] == nil {
+		return nil, notFoundError(id)
+	}
+	return copyRecord(stored), nil
+}
+
+// PurgeDeleted erases records soft deleted more than olderThan
+// ago and returns how many were erased
+func (db *FileDb) PurgeDeleted(olderThan time.Duration) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	cutoff := float64(time.Now().Add(-olderThan).UnixMilli())
+	purged := 0
+	for key, val := range db.inMemoryStore {
+		record, ok := val.(map[string]any)
+		if !ok || !strings.HasPrefix(key, db.recordsName) {
+			continue
+		}
+		deletedAt, isDeleted := getFloat64Equivalent(record[DELETED_AT_FIELD])
+		if isDeleted && deletedAt <= cutoff {
+			delete(db.inMemoryStore, key)
+			purged++
+		}
+	}
+
+	// soft deleted records are not indexed for search
+	return purged, nil
+}
+
+// PurgeExpired erases expired records and returns how many
+// were erased
+func (db *FileDb) PurgeExpired() (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.purgeExpired(), nil
+}
+
+func (db *FileDb) purgeExpired() int {
+	now := metadataNow()
+	purged := 0
+	for key, val := range db.inMemoryStore {
+		record, ok := val.(map[string]any)
+		if ok && strings.HasPrefix(key, db.recordsName) && isExpired(record, now) {
+			delete(db.inMemoryStore, key)
+			db.searchIndex.update(key, nil)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+func (db *FileDb) hasExpiringRecords() bool {
+	for key, val := range db.inMemoryStore {
+		record, ok := val.(map[string]any)
+		if ok && strings.HasPrefix(key, db.recordsName) && record[EXPIRES_AT_FIELD] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// startVacuuming erases expired records, and commits the
+// erasure, every config.ExpiredRecordsVacuumInterval. It must
+// be called with db.mu held
+func (db *FileDb) startVacuuming() {
+	interval := config.ExpiredRecordsVacuumInterval
+	if db.stopVacuuming != nil || interval <= 0 {
+		return
+	}
+
+	db.stopVacuuming = runEvery(interval, func() {
+		purged, _ := db.PurgeExpired()
+		if purged > 0 {
+			db.Commit()
+		}
+	})
+}
+
+// StopVacuuming stops erasing expired records in the background,
+// RemoveDbSingleton and DeleteDb call it
+func (db *FileDb) StopVacuuming() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.stopVacuuming != nil {
+		db.stopVacuuming()
+		db.stopVacuuming = nil
+	}
+}
+
+func (db *FileDb) Update(id string, data UpdateDesc) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.update(id, data)
 }
 
-func (db *FileDb) Update(id string, data UpdateDesc) bool {
-	_, exists := db.inMemoryStore[id]
+// UpdateIfVersion updates the record only if its version is
+// expectedVersion, else it returns a *VersionConflictError
+func (db *FileDb) UpdateIfVersion(id string, data UpdateDesc, expectedVersion int) error {
+	if !db.trackMetadata {
+		return ErrVersionsNotTracked
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, exists := db.getLiveRecord(id)
+	if !exists {
+		return notFoundError(id)
+	}
+
+	version, _ := getFloat64Equivalent(stored[VERSION_FIELD])
+	if int(version) != expectedVersion {
+		return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: int(version)}
+	}
+
+	return db.update(id, data)
+}
+
+func (db *FileDb) update(id string, data UpdateDesc) error {
+	obj, exists := db.getLiveRecord(id)
 	if !exists {
-		return false
+		return notFoundError(id)
+	}
+
+	// metadata is maintained by the engine only
+	if db.trackMetadata && IsMetadataField(data.Field) {
+		return newValidationError(data.Field, "readonly", "is maintained by the engine")
+	}
+
+	if (db.softDelete && data.Field == DELETED_AT_FIELD) || data.Field == EXPIRES_AT_FIELD {
+		return newValidationError(data.Field, "readonly", "is maintained by the engine")
+	}
+
+	if _, ok := getValInNestedFieldOfMap(data.Field, obj); !ok {
+		return newValidationError(data.Field, "unknown", "does not exist on record")
 	}
+	setValInMapOrNestedMap(data.Field, data.Value, &obj)
 
-	obj := db.inMemoryStore[id].(map[string]any)
+	if db.trackMetadata {
+		setMetadataOnUpdate(obj)
+	}
 
-	if _, ok := getValInNestedFieldOfMap(data.Field, obj); ok {
-		setValInMapOrNestedMap(data.Field, data.Value, &obj)
+	db.notifyChange(UPDATE_OP, id, obj)
+	return nil
+}
+
+// Search returns the records whose fields contain every term of
+// query, or a word the term is the start of, best match first. At
+// most limit are returned, if limit is above 0. Terms are runs of
+// letters and digits, matched regardless of case
+func (db *FileDb) Search(query string, fields []string, limit int) ([]SearchResult, error) {
+	if err := validateSearchFields(fields); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	index := db.searchIndex.get(func() map[string]map[string]any {
+		records := map[string]map[string]any{}
+		for key, val := range db.inMemoryStore {
+			record, ok := val.(map[string]any)
+			if ok && strings.HasPrefix(key, db.recordsName) && db.isLive(record) {
+				records[key] = record
+			}
+		}
+		return records
+	})
+
+	// records can expire after they are indexed. Results get
+	// copies of the records, which writers change in place
+	now := metadataNow()
+	results := []SearchResult{}
+	for _, result := range index.search(query, fields, 0) {
+		if limit > 0 && len(results) == limit {
+			break
+		}
+		if !isExpired(result.Record, now) {
+			result.Record = copyRecord(result.Record)
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// Watch delivers the changes made through this FileDb to records
+// matching filter, until stop is called
+func (db *FileDb) Watch(filter WatchFilter) (<-chan ChangeEvent, func(), error) {
+	events, stop := db.notifier.watch(filter)
+	return events, stop, nil
+}
+
+// notifyChange is called on every change of a record, it updates
+// the record in the search index and emits a copy of record, so
+// watchers never share the stored map
+func (db *FileDb) notifyChange(op ChangeOp, id string, record map[string]any) {
+	if record != nil && db.isLive(record) {
+		db.searchIndex.update(id, record)
 	} else {
-		panic("typeof inMemoryStore[id] is not map[string]any")
+		db.searchIndex.update(id, nil)
 	}
 
-	return true
+	if !db.notifier.hasWatchers() {
+		return
+	}
+
+	var data map[string]any
+	if record != nil {
+		data, _ = getMapRep(record)
+	}
+	db.notifier.notify(ChangeEvent{Op: op, ID: id, Data: data})
 }
 
 func (db *FileDb) Commit() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	json_rep, err := json.Marshal(db.inMemoryStore)
 	if err != nil {
 		return err
@@ -171,7 +606,10 @@ func (db *FileDb) Commit() error {
 }
 
 func (db *FileDb) DeleteDb() error {
+	db.StopVacuuming()
+	fileDbsMu.Lock()
 	delete(FILE_DB_MAP, db.path)
+	fileDbsMu.Unlock()
 	err := os.Remove(db.path)
 	return err
 }
@@ -244,6 +682,7 @@ func setValInMapOrNestedMap(field string, value any, map_ *map[string]any) bool
 }
 
 var FILE_DB_MAP = map[string]*FileDb{}
+var fileDbsMu sync.Mutex
 
 func MakeFileDb(db_path string, recordsName string) (*FileDb, error) {
 	path := db_path
@@ -256,6 +695,9 @@ func MakeFileDb(db_path string, recordsName string) (*FileDb, error) {
 		panic("MakeFileDb: db_path cannot be empty")
 	}
 
+	fileDbsMu.Lock()
+	defer fileDbsMu.Unlock()
+
 	key := path + recordsName
 	// implements singleton pattern
 	if FILE_DB_MAP[key] != nil {
@@ -265,7 +707,7 @@ func MakeFileDb(db_path string, recordsName string) (*FileDb, error) {
 	file_db, err := new(FileDb).New(path, recordsName)
 
 	if err != nil {
-		panic("MakeFileDb: " + err.Error())
+		return nil, backendError(err)
 	}
 
 	FILE_DB_MAP[key] = file_db
@@ -281,6 +723,12 @@ func RemoveDbSingleton(db_path, recordsName string) {
 		panic("RemoveDbSingleton: db_path cannot be empty")
 	}
 
+	fileDbsMu.Lock()
+	defer fileDbsMu.Unlock()
+
 	key := db_path + recordsName
+	if db, exists := FILE_DB_MAP[key]; exists {
+		db.StopVacuuming()
+	}
 	delete(FILE_DB_MAP, key)
 }
@@ -3,9 +3,16 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Iyusuf40/goBackendUtils/config"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -16,6 +23,9 @@ type MongoWrapper struct {
 	client        *mongo.Client
 	collection    *mongo.Collection
 	database_name string
+	trackMetadata bool
+	softDelete    bool
+	ttlIndexed    atomic.Bool
 }
 
 func (db *MongoWrapper) New(database, collection string) (*MongoWrapper, error) {
@@ -26,6 +36,8 @@ func (db *MongoWrapper) New(database, collection string) (*MongoWrapper, error)
 	}
 	db.client = client
 	db.database_name = database
+	db.trackMetadata = config.TrackRecordMetadata
+	db.softDelete = config.SoftDelete
 	db.collection = client.Database(database).Collection(collection)
 	return db, err
 }
@@ -41,6 +53,13 @@ func (db *MongoWrapper) AllRecordsCount() int {
 }
 
 func (db *MongoWrapper) Save(obj any) (string, error) {
+	return db.SaveWithOptions(obj, SaveOptions{})
+}
+
+// SaveWithOptions is Save with options. A document saved with a
+// TTL is deleted by mongo's TTL index on expiresAt, created on
+// first use, within about a minute of expiring
+func (db *MongoWrapper) SaveWithOptions(obj any, options SaveOptions) (string, error) {
 
 	id := ""
 
@@ -52,11 +71,27 @@ func (db *MongoWrapper) Save(obj any) (string, error) {
 	var mapRep map[string]any
 	json.Unmarshal(json_rep, &mapRep)
 
+	if db.trackMetadata && mapRep != nil {
+		setMetadataOnSave(mapRep)
+	}
+
+	if db.softDelete {
+		delete(mapRep, DELETED_AT_FIELD)
+	}
+
+	delete(mapRep, EXPIRES_AT_FIELD)
+	if expiresAt, expires := options.expiresAt(); expires && mapRep != nil {
+		if err := db.ensureTTLIndex(); err != nil {
+			return "", mongoError(err, "")
+		}
+		mapRep[EXPIRES_AT_FIELD] = primitive.NewDateTimeFromTime(time.UnixMilli(expiresAt))
+	}
+
 	bsonD := db.makeBsonDSlice(mapRep)
 	result, err := db.collection.InsertOne(context.Background(), bsonD)
 
 	if err != nil {
-		return "", err
+		return "", mongoError(err, "")
 	}
 
 	id = result.InsertedID.(primitive.ObjectID).Hex()
@@ -64,6 +99,95 @@ func (db *MongoWrapper) Save(obj any) (string, error) {
 	return id, nil
 }
 
+// ensureTTLIndex creates the index deleting documents
+// once their expiresAt is past, if it does not exist
+func (db *MongoWrapper) ensureTTLIndex() error {
+	if db.ttlIndexed.Load() {
+		return nil
+	}
+
+	_, err := db.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: EXPIRES_AT_FIELD, Value: 1}},
+		Options: options.Index().SetName(EXPIRES_AT_FIELD + "_ttl").SetExpireAfterSeconds(0),
+	})
+	if err == nil {
+		db.ttlIndexed.Store(true)
+	}
+	return err
+}
+
+// Upsert sets obj's fields on the document whose field equals value,
+// inserting a new document if none matches, in a single UpdateOne
+// with upsert enabled. A field of "id" matches on the document _id.
+// A soft deleted or expired match is restored, and no longer
+// expires. created reports whether a new document was inserted
+func (db *MongoWrapper) Upsert(field string, value any, obj any) (string, bool, error) {
+	mapRep, err := getMapRep(obj)
+	if err != nil {
+		return "", false, err
+	}
+	delete(mapRep, "id")
+
+	var filter bson.D
+	if field == "id" {
+		id, _ := value.(string)
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return "", false, newValidationError("id", "format", "is not a valid ObjectID")
+		}
+		filter = bson.D{{Key: "_id", Value: objectId}}
+	} else {
+		filter = bson.D{{Key: field, Value: value}}
+	}
+
+	update := bson.D{}
+	if db.trackMetadata {
+		removeMetadataFields(mapRep)
+		now := metadataNow()
+		mapRep[UPDATED_AT_FIELD] = now
+		// $inc on a missing field sets it, so inserts get version 1
+		update = append(update,
+			bson.E{Key: "$setOnInsert", Value: bson.D{{Key: CREATED_AT_FIELD, Value: now}}},
+			bson.E{Key: "$inc", Value: bson.D{{Key: VERSION_FIELD, Value: 1}}})
+	}
+
+	delete(mapRep, EXPIRES_AT_FIELD)
+	unset := bson.D{{Key: EXPIRES_AT_FIELD, Value: ""}}
+	if db.softDelete {
+		delete(mapRep, DELETED_AT_FIELD)
+		unset = append(unset, bson.E{Key: DELETED_AT_FIELD, Value: ""})
+	}
+	update = append(update, bson.E{Key: "$unset", Value: unset})
+
+	if len(mapRep) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: db.makeBsonDSlice(mapRep)})
+	} else {
+		// an empty update is rejected by mongo
+		update = append(update, bson.E{Key: "$setOnInsert", Value: filter})
+	}
+
+	result, err := db.collection.UpdateOne(context.Background(), filter, update,
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return "", false, mongoError(err, "")
+	}
+
+	if result.UpsertedID != nil {
+		return result.UpsertedID.(primitive.ObjectID).Hex(), true, nil
+	}
+
+	var matched struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err = db.collection.FindOne(context.Background(), filter,
+		options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})).Decode(&matched)
+	if err != nil {
+		return "", false, mongoError(err, "")
+	}
+
+	return matched.ID.Hex(), false, nil
+}
+
 func (db *MongoWrapper) makeBsonDSlice(mapRep map[string]any) bson.D {
 	bsonD := bson.D{}
 
@@ -77,12 +201,28 @@ func (db *MongoWrapper) makeBsonDSlice(mapRep map[string]any) bson.D {
 // returns objects with any type so users can rebuild
 // objects with their type builders
 func (db *MongoWrapper) Get(id string) (any, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, notFoundError(id)
+	}
+	return db.findOne(id, db.liveFilter(bson.D{{Key: "_id", Value: objectId}}))
+}
+
+func (db *MongoWrapper) GetDeleted(id string) (any, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil || !db.softDelete {
+		return nil, notFoundError(id)
+	}
+	return db.findOne(id, bson.D{{Key: "_id", Value: objectId},
+		{Key: DELETED_AT_FIELD, Value: bson.D{{Key: "$ne", Value: nil}}}})
+}
+
+// findOne returns the document with id matching filter as a map
+func (db *MongoWrapper) findOne(id string, filter bson.D) (any, error) {
 	var result any
-	objectId, _ := primitive.ObjectIDFromHex(id)
-	err := db.collection.FindOne(context.Background(),
-		bson.D{{Key: "_id", Value: objectId}}).Decode(&result)
+	err := db.collection.FindOne(context.Background(), filter).Decode(&result)
 	if err != nil {
-		return nil, err
+		return nil, mongoError(err, id)
 	}
 
 	jsonRep, err := json.Marshal(result)
@@ -119,6 +259,8 @@ func (db *MongoWrapper) getObjectAsMap(sliceRep []map[string]any) map[string]any
 				nestedMaps = append(nestedMaps, nested.(map[string]any))
 			}
 			objectAsMap[key] = db.getObjectAsMap(nestedMaps)
+		} else if key == EXPIRES_AT_FIELD {
+			objectAsMap[key] = unixMilliOfDate(val)
 		} else {
 			objectAsMap[key] = val
 		}
@@ -128,23 +270,27 @@ func (db *MongoWrapper) getObjectAsMap(sliceRep []map[string]any) map[string]any
 
 // a field of "" and value of "" will return all records in the collection
 func (db *MongoWrapper) GetRecordsByField(field string, value any) ([]map[string]any, error) {
-	var results []any
-
 	var filter bson.D
 	if field == "" && value == "" {
-		filter = bson.D{{}}
+		filter = bson.D{}
 	} else {
 		filter = bson.D{{Key: field, Value: value}}
 	}
-	cursor, err := db.collection.Find(context.Background(), filter)
+	return db.findRecords(db.liveFilter(filter))
+}
+
+func (db *MongoWrapper) findRecords(filter bson.D, opts ...*options.FindOptions) ([]map[string]any, error) {
+	var results []any
+
+	cursor, err := db.collection.Find(context.Background(), filter, opts...)
 	if err != nil {
-		return nil, err
+		return nil, mongoError(err, "")
 	}
 
 	err = cursor.All(context.Background(), &results)
 
 	if err != nil {
-		return nil, err
+		return nil, mongoError(err, "")
 	}
 
 	jsonRep, err := json.Marshal(results)
@@ -181,7 +327,9 @@ func (db *MongoWrapper) GetIdByFieldAndValue(field string, value any) string {
 	}
 
 	if len(records) > 1 {
-		panic("MongoWrapper.GetIdByFieldAndValue: length of records returned should be 1")
+		err = errors.New("MongoWrapper.GetIdByFieldAndValue: returned list cannot be more than 1")
+		fmt.Fprintln(os.Stderr, err.Error())
+		return ""
 	}
 
 	return records[0]["id"].(string)
@@ -192,29 +340,421 @@ func (db *MongoWrapper) GetAllOfRecords() []map[string]any {
 	return records
 }
 
-func (db *MongoWrapper) Delete(id string) {
+// GetAllOfRecordsIncludingDeleted is GetAllOfRecords with
+// soft deleted documents included
+func (db *MongoWrapper) GetAllOfRecordsIncludingDeleted() []map[string]any {
+	records, _ := db.findRecords(bson.D{db.unexpiredCondition()})
+	return records
+}
+
+// Search ranks documents by their text score over the text index
+// of the collection, see CreateSearchIndex, and keeps the ones whose
+// fields contain every term. Unlike FileDb and postgres, terms only
+// match whole words, jo does not find john
+func (db *MongoWrapper) Search(query string, fields []string, limit int) ([]SearchResult, error) {
+	if err := validateSearchFields(fields); err != nil {
+		return nil, err
+	}
+
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// the text index may cover more fields than searched, each
+	// term must also be a word of one of fields
+	allTerms := bson.A{}
+	for _, term := range terms {
+		pattern := `(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(term) + `($|[^\p{L}\p{N}])`
+		inFields := bson.A{}
+		for _, field := range fields {
+			inFields = append(inFields, bson.D{{Key: field, Value: primitive.Regex{Pattern: pattern, Options: "i"}}})
+		}
+		allTerms = append(allTerms, bson.D{{Key: "$or", Value: inFields}})
+	}
+
+	// quoted terms must all match
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+
+	score := bson.D{{Key: SEARCH_SCORE_FIELD, Value: bson.D{{Key: "$meta", Value: "textScore"}}}}
+	opts := options.Find().SetProjection(score).SetSort(score)
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	filter := bson.D{
+		{Key: "$text", Value: bson.D{{Key: "$search", Value: strings.Join(terms, " ")}}},
+		{Key: "$and", Value: allTerms},
+	}
+	records, err := db.findRecords(db.liveFilter(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(records))
+	for _, record := range records {
+		score, _ := getFloat64Equivalent(record[SEARCH_SCORE_FIELD])
+		delete(record, SEARCH_SCORE_FIELD)
+		id, _ := record["id"].(string)
+		results = append(results, SearchResult{ID: id, Score: score, Record: record})
+	}
+	return results, nil
+}
+
+// name of the text index Search uses
+const MONGO_SEARCH_INDEX = "search_text"
+
+// code of the error of a $text query without a text index
+const MONGO_INDEX_NOT_FOUND = 27
+
+// CreateSearchIndex creates the text index of fields Search uses, if
+// it does not exist. A collection can only have one text index, so
+// it must cover every field searched, and be created once, e.g.
+// when the collection is set up. Words are matched as is, without
+// the stemming of a language
+func (db *MongoWrapper) CreateSearchIndex(fields ...string) error {
+	if err := validateSearchFields(fields); err != nil {
+		return err
+	}
+
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+	}
+	_, err := db.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(MONGO_SEARCH_INDEX).SetDefaultLanguage("none"),
+	})
+	if err != nil {
+		return mongoError(err, "")
+	}
+	return nil
+}
+
+// unixMilliOfDate returns the unix milliseconds of a date decoded
+// from json, as expiresAt is read back in the format of FileDb and
+// postgres. Other values are returned as is
+func unixMilliOfDate(val any) any {
+	date, ok := val.(string)
+	if !ok {
+		return val
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return val
+	}
+	return parsed.UnixMilli()
+}
+
+// liveFilter adds the conditions leaving soft deleted and
+// expired documents out to filter
+func (db *MongoWrapper) liveFilter(filter bson.D) bson.D {
+	filter = append(filter, db.unexpiredCondition())
+	if !db.softDelete {
+		return filter
+	}
+	// nil matches both a null and a missing field
+	return append(filter, bson.E{Key: DELETED_AT_FIELD, Value: nil})
+}
+
+// unexpiredCondition leaves out documents the TTL index has not
+// deleted yet
+func (db *MongoWrapper) unexpiredCondition() bson.E {
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.D{{Key: EXPIRES_AT_FIELD, Value: nil}},
+		bson.D{{Key: EXPIRES_AT_FIELD, Value: bson.D{{Key: "$gt", Value: primitive.NewDateTimeFromTime(time.Now())}}}},
+	}}
+}
+
+// Delete deletes the document, or if config.SoftDelete was set,
+// marks it deleted with a deletedAt timestamp
+func (db *MongoWrapper) Delete(id string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return notFoundError(id)
+	}
+
+	if db.softDelete {
+		result, err := db.collection.UpdateOne(context.Background(),
+			db.liveFilter(bson.D{{Key: "_id", Value: objectId}}),
+			bson.D{{Key: "$set", Value: bson.D{{Key: DELETED_AT_FIELD, Value: metadataNow()}}}})
+		if err != nil {
+			return mongoError(err, id)
+		}
+		if result.MatchedCount != 1 {
+			return notFoundError(id)
+		}
+		return nil
+	}
+
+	result, err := db.collection.DeleteOne(context.Background(), db.liveFilter(bson.D{{Key: "_id", Value: objectId}}))
+	if err != nil {
+		return mongoError(err, id)
+	}
+	if result.DeletedCount != 1 {
+		return notFoundError(id)
+	}
+	return nil
+}
+
+// Restore undoes the soft delete of the document with id
+func (db *MongoWrapper) Restore(id string) error {
 	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil || !db.softDelete {
+		return notFoundError(id)
+	}
+
+	result, err := db.collection.UpdateOne(context.Background(),
+		bson.D{{Key: "_id", Value: objectId},
+			{Key: DELETED_AT_FIELD, Value: bson.D{{Key: "$ne", Value: nil}}}},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: DELETED_AT_FIELD, Value: ""}}}})
 	if err != nil {
-		return
+		return mongoError(err, id)
 	}
 
-	db.collection.DeleteOne(context.Background(), bson.D{{Key: "_id", Value: objectId}})
+	if result.ModifiedCount != 1 {
+		return notFoundError(id)
+	}
+	return nil
 }
 
-func (db *MongoWrapper) Update(id string, data UpdateDesc) bool {
+// PurgeDeleted deletes documents soft deleted more than olderThan
+// ago and returns how many were deleted
+func (db *MongoWrapper) PurgeDeleted(olderThan time.Duration) (int, error) {
+	if !db.softDelete {
+		return 0, nil
+	}
+
+	cutoff := time.Now().Add(-olderThan).UnixMilli()
+	result, err := db.collection.DeleteMany(context.Background(),
+		bson.D{{Key: DELETED_AT_FIELD, Value: bson.D{{Key: "$lte", Value: cutoff}}}})
+	if err != nil {
+		return 0, mongoError(err, "")
+	}
+
+	return int(result.DeletedCount), nil
+}
+
+// PurgeExpired deletes expired documents the TTL index has not
+// deleted yet and returns how many were deleted
+func (db *MongoWrapper) PurgeExpired() (int, error) {
+	result, err := db.collection.DeleteMany(context.Background(),
+		bson.D{{Key: EXPIRES_AT_FIELD, Value: bson.D{{Key: "$lte", Value: primitive.NewDateTimeFromTime(time.Now())}}}})
+	if err != nil {
+		return 0, mongoError(err, "")
+	}
+
+	return int(result.DeletedCount), nil
+}
+
+func (db *MongoWrapper) Update(id string, data UpdateDesc) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return notFoundError(id)
+	}
+
+	// metadata is maintained by the engine only
+	if db.trackMetadata && IsMetadataField(data.Field) {
+		return newValidationError(data.Field, "readonly", "is maintained by the engine")
+	}
+
+	if (db.softDelete && data.Field == DELETED_AT_FIELD) || data.Field == EXPIRES_AT_FIELD {
+		return newValidationError(data.Field, "readonly", "is maintained by the engine")
+	}
+
+	set := bson.D{{Key: data.Field, Value: data.Value}}
+	update := bson.D{}
+	if db.trackMetadata {
+		set = append(set, bson.E{Key: UPDATED_AT_FIELD, Value: metadataNow()})
+		update = append(update, bson.E{Key: "$inc", Value: bson.D{{Key: VERSION_FIELD, Value: 1}}})
+	}
+	update = append(update, bson.E{Key: "$set", Value: set})
+
+	result, err := db.collection.UpdateOne(context.Background(),
+		db.liveFilter(bson.D{{Key: "_id", Value: objectId}}), update)
+	if err != nil {
+		return mongoError(err, id)
+	}
+
+	if result.MatchedCount != 1 {
+		return notFoundError(id)
+	}
+	return nil
+}
+
+// UpdateIfVersion updates the document only if its version is
+// expectedVersion, else it returns a *VersionConflictError
+func (db *MongoWrapper) UpdateIfVersion(id string, data UpdateDesc, expectedVersion int) error {
+	if !db.trackMetadata {
+		return ErrVersionsNotTracked
+	}
+
+	if IsMetadataField(data.Field) || (db.softDelete && data.Field == DELETED_AT_FIELD) ||
+		data.Field == EXPIRES_AT_FIELD {
+		return newValidationError(data.Field, "readonly", "is maintained by the engine")
+	}
+
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return false
+		return notFoundError(id)
+	}
+
+	filter := db.liveFilter(bson.D{{Key: "_id", Value: objectId}, {Key: VERSION_FIELD, Value: expectedVersion}})
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: VERSION_FIELD, Value: 1}}},
+		{Key: "$set", Value: bson.D{
+			{Key: data.Field, Value: data.Value},
+			{Key: UPDATED_AT_FIELD, Value: metadataNow()}}},
+	}
+
+	result, err := db.collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return mongoError(err, id)
+	}
+
+	if result.MatchedCount == 1 {
+		return nil
 	}
 
-	result, err := db.collection.UpdateByID(context.Background(),
-		objectId,
-		bson.D{{Key: "$set", Value: bson.D{{Key: data.Field, Value: data.Value}}}})
+	// nothing matched, find out whether the document is missing
+	// or has another version
+	var stored struct {
+		Version int `bson:"version"`
+	}
+	err = db.collection.FindOne(context.Background(), db.liveFilter(bson.D{{Key: "_id", Value: objectId}}),
+		options.FindOne().SetProjection(bson.D{{Key: VERSION_FIELD, Value: 1}})).Decode(&stored)
 	if err != nil {
-		return false
+		return mongoError(err, id)
 	}
 
-	return result.ModifiedCount == 1
+	return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: stored.Version}
+}
+
+// Watch delivers changes to documents matching filter, made by any
+// client of the collection, until stop is called. It is built on
+// change streams, which mongo only serves from a replica set
+func (db *MongoWrapper) Watch(filter WatchFilter) (<-chan ChangeEvent, func(), error) {
+	match := bson.D{{Key: "operationType", Value: bson.D{
+		{Key: "$in", Value: bson.A{"insert", "update", "replace", "delete"}}}}}
+
+	if len(filter.IDs) > 0 {
+		objectIds := bson.A{}
+		for _, id := range filter.IDs {
+			objectId, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return nil, nil, err
+			}
+			objectIds = append(objectIds, objectId)
+		}
+		match = append(match, bson.E{Key: "documentKey._id", Value: bson.D{{Key: "$in", Value: objectIds}}})
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := db.collection.Watch(ctx, pipeline,
+		options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		defer stream.Close(context.Background())
+		for stream.Next(ctx) {
+			event, err := db.parseChange(stream.Current)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "MongoWrapper.Watch:", err.Error())
+				continue
+			}
+
+			if !filter.matches(event) {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			fmt.Fprintln(os.Stderr, "MongoWrapper.Watch:", err.Error())
+		}
+	}()
+
+	return events, cancel, nil
+}
+
+func (db *MongoWrapper) parseChange(raw bson.Raw) (ChangeEvent, error) {
+	var change struct {
+		OperationType string `bson:"operationType"`
+		DocumentKey   struct {
+			ID primitive.ObjectID `bson:"_id"`
+		} `bson:"documentKey"`
+		FullDocument bson.D `bson:"fullDocument"`
+	}
+
+	err := bson.Unmarshal(raw, &change)
+	if err != nil {
+		return ChangeEvent{}, err
+	}
+
+	event := ChangeEvent{Op: ChangeOp(change.OperationType), ID: change.DocumentKey.ID.Hex()}
+	if event.Op == "replace" {
+		event.Op = UPDATE_OP
+	}
+
+	if event.Op == DELETE_OP {
+		return event, nil
+	}
+
+	// the document may be gone by the time an update is looked up
+	if change.FullDocument == nil {
+		event.Data = map[string]any{"id": event.ID}
+		return event, nil
+	}
+
+	jsonRep, err := json.Marshal(change.FullDocument)
+	if err != nil {
+		return ChangeEvent{}, err
+	}
+
+	var sliceRep []map[string]any
+	err = json.Unmarshal(jsonRep, &sliceRep)
+	if err != nil {
+		return ChangeEvent{}, err
+	}
+
+	event.Data = db.getObjectAsMap(sliceRep)
+
+	// a soft delete is an update setting deletedAt
+	if db.softDelete && event.Op == UPDATE_OP && event.Data[DELETED_AT_FIELD] != nil {
+		event.Op = DELETE_OP
+		event.Data = nil
+	}
+
+	return event, nil
+}
+
+// mongoError wraps err, returned by an operation on the document
+// with id, in the storage error it stands for
+func mongoError(err error, id string) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return notFoundError(id)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return duplicateError("%s", err.Error())
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(MONGO_INDEX_NOT_FOUND) {
+		return fmt.Errorf("%w: %s, see CreateSearchIndex", ErrValidation, err.Error())
+	}
+	return backendError(err)
 }
 
 func (db *MongoWrapper) DeleteDb() error {
@@ -226,6 +766,7 @@ func (db *MongoWrapper) Commit() error {
 }
 
 var MONGO_WRAPPER_MAP = map[string]*MongoWrapper{}
+var mongoWrappersMu sync.Mutex
 
 func MakeMongoWrapper(database string, collection string) (*MongoWrapper, error) {
 
@@ -237,6 +778,9 @@ func MakeMongoWrapper(database string, collection string) (*MongoWrapper, error)
 		panic("MakeMongoWrapper: database cannot be empty")
 	}
 
+	mongoWrappersMu.Lock()
+	defer mongoWrappersMu.Unlock()
+
 	key := database + collection
 	// implements singleton pattern
 	if MONGO_WRAPPER_MAP[key] != nil {
@@ -246,7 +790,7 @@ func MakeMongoWrapper(database string, collection string) (*MongoWrapper, error)
 	file_db, err := new(MongoWrapper).New(database, collection)
 
 	if err != nil {
-		panic("MakeMongoWrapper: " + err.Error())
+		return nil, backendError(err)
 	}
 
 	MONGO_WRAPPER_MAP[key] = file_db
@@ -262,6 +806,9 @@ func RemoveMongoSingleton(database, collection string, shouldDeleteDatabase ...b
 		panic("RemoveMongoSingleton: database cannot be empty")
 	}
 
+	mongoWrappersMu.Lock()
+	defer mongoWrappersMu.Unlock()
+
 	key := database + collection
 
 	MongoEng, exists := MONGO_WRAPPER_MAP[key]
@@ -7,19 +7,48 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type PostgresEngine struct {
-	tableName string
-	conn      *pgx.Conn
+	tableName     string
+	schema        string
+	postgresUrl   string
+	conn          *pgx.Conn
+	replicas      *postgresReplicas
+	primaryReads  bool
+	session       string
+	trackMetadata bool
+	softDelete    bool
+	stopReaper    func()
+	changeTrigger *postgresChangeTrigger
+}
+
+// postgresChangeTrigger records whether an engine created the
+// change trigger of its table, which is then never dropped
+type postgresChangeTrigger struct {
+	mu      sync.Mutex
+	created bool
 }
 
 type SQL_TABLE_COLUMN_FIELD_AND_DESC [2]string
 
+var metadataColumns = []SQL_TABLE_COLUMN_FIELD_AND_DESC{
+	{CREATED_AT_FIELD, "BIGINT"},
+	{UPDATED_AT_FIELD, "BIGINT"},
+	{VERSION_FIELD, "INTEGER"}}
+
+var deletedAtColumn = SQL_TABLE_COLUMN_FIELD_AND_DESC{DELETED_AT_FIELD, "BIGINT"}
+
+var expiresAtColumn = SQL_TABLE_COLUMN_FIELD_AND_DESC{EXPIRES_AT_FIELD, "BIGINT"}
+
 func (db *PostgresEngine) New(database, tableName string, fieldAndDesc ...SQL_TABLE_COLUMN_FIELD_AND_DESC) (*PostgresEngine, error) {
 	if database == "" || tableName == "" {
 		panic("PostgresEngine.New: db_path and objectType must not be empty")
@@ -32,7 +61,30 @@ func (db *PostgresEngine) New(database, tableName string, fieldAndDesc ...SQL_TA
 		return nil, err
 	}
 
+	// tables of a tenant live in its schema
+	if db.schema != "" {
+		_, err = conn.Exec(context.Background(), fmt.Sprintf(
+			`CREATE SCHEMA IF NOT EXISTS "%s"; SET search_path TO "%s";`, db.schema, db.schema))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "PostgresEngine.New: Failed to use schema %s: %v", db.schema, err)
+			return nil, err
+		}
+	}
+
 	db.tableName = tableName
+	db.postgresUrl = postgresUrl
+	db.trackMetadata = config.TrackRecordMetadata
+	db.softDelete = config.SoftDelete
+	db.changeTrigger = &postgresChangeTrigger{}
+
+	engineColumns := []SQL_TABLE_COLUMN_FIELD_AND_DESC{expiresAtColumn}
+	if db.trackMetadata {
+		engineColumns = append(engineColumns, metadataColumns...)
+	}
+	if db.softDelete {
+		engineColumns = append(engineColumns, deletedAtColumn)
+	}
+	fieldAndDesc = append(fieldAndDesc, engineColumns...)
 
 	createTableStmt := db.makeCreateTableStmt(fieldAndDesc...)
 
@@ -43,11 +95,109 @@ func (db *PostgresEngine) New(database, tableName string, fieldAndDesc ...SQL_TA
 		return nil, err
 	}
 
+	// tables created before expiry, metadata or soft delete
+	// were enabled lack the columns
+	for _, column := range engineColumns {
+		_, err = conn.Exec(context.Background(), fmt.Sprintf(
+			`ALTER TABLE "%s" ADD COLUMN IF NOT EXISTS "%s" %s;`,
+			db.tableName, column[0], column[1]))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "PostgresEngine.New: Failed to add column %s: %v", column[0], err)
+			return nil, err
+		}
+	}
+
 	db.conn = conn
 
+	db.replicas, err = makePostgresReplicas(config.PostgresReplicaUrls, database, db.schema,
+		config.ReplicaHealthCheckInterval, config.ReadYourWritesWindow)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "PostgresEngine.New: Failed to set up replicas: %v", err)
+		return nil, err
+	}
+
+	db.stopReaper = db.startReaper(config.ExpiredRecordsVacuumInterval)
+
 	return db, err
 }
 
+// startReaper erases expired rows every interval. It sweeps on a
+// connection of its own, as a pgx connection cannot be shared
+// between goroutines
+func (db *PostgresEngine) startReaper(interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	return runEvery(interval, func() {
+		conn, err := db.connect()
+		if err == nil {
+			_, err = db.purgeExpired(conn)
+			conn.Close(context.Background())
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "PostgresEngine: failed to erase expired rows:", err.Error())
+		}
+	})
+}
+
+// connect opens a new connection to the primary, using db's schema
+func (db *PostgresEngine) connect() (*pgx.Conn, error) {
+	conn, err := pgx.Connect(context.Background(), db.postgresUrl)
+	if err == nil && db.schema != "" {
+		_, err = conn.Exec(context.Background(), fmt.Sprintf(`SET search_path TO "%s";`, db.schema))
+		if err != nil {
+			conn.Close(context.Background())
+		}
+	}
+	return conn, err
+}
+
+// PrimaryReads returns db reading from the primary only, for
+// callers that must see their writes at once, e.g. the rest of a
+// request after a write. It shares db's connections
+func (db *PostgresEngine) PrimaryReads() *PostgresEngine {
+	primaryReads := *db
+	primaryReads.primaryReads = true
+	return &primaryReads
+}
+
+// Session returns db reading its own writes: for
+// config.ReadYourWritesWindow after a write made through an engine
+// of session, e.g. a login session or a request id, the reads of
+// the engines of session go to the primary, so replication lag
+// cannot hide the write. Other sessions keep reading from the
+// replicas. It shares db's connections
+func (db *PostgresEngine) Session(session string) *PostgresEngine {
+	sessionEngine := *db
+	sessionEngine.session = session
+	return &sessionEngine
+}
+
+// HealthyReplicas returns the number of replicas db reads from
+func (db *PostgresEngine) HealthyReplicas() int {
+	return db.replicas.healthy()
+}
+
+// read runs the read only statement stmt on the next healthy
+// replica, or on the primary if there is none or the replica fails
+func (db *PostgresEngine) read(stmt string, args ...any) ([]map[string]any, error) {
+	if !db.primaryReads {
+		if replica := db.replicas.pick(db.session); replica != nil {
+			records, err := replica.read(stmt, args...)
+			if err == nil {
+				return records, nil
+			}
+		}
+	}
+
+	rows, err := db.conn.Query(context.Background(), stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToMap)
+}
+
 // makeCreateTableStmt: creates the statement to create the table.
 // params - fieldAndDesc ([2]string): the field is retrieved from index 0
 // while the type, constaraint and all other field description is
@@ -89,6 +239,13 @@ func (db *PostgresEngine) AllRecordsCount() int {
 }
 
 func (db *PostgresEngine) Save(obj any) (string, error) {
+	return db.SaveWithOptions(obj, SaveOptions{})
+}
+
+// SaveWithOptions is Save with options, a row saved with a TTL is
+// erased every config.ExpiredRecordsVacuumInterval once expired
+func (db *PostgresEngine) SaveWithOptions(obj any, options SaveOptions) (string, error) {
+	defer db.replicas.wrote(db.session)
 	id := uuid.NewString()
 	json_rep, err := json.Marshal(obj) // test if it can be jsoned
 	if err != nil {
@@ -100,17 +257,134 @@ func (db *PostgresEngine) Save(obj any) (string, error) {
 
 	mapRep["id"] = id
 
+	if db.trackMetadata {
+		setMetadataOnSave(mapRep)
+	}
+
+	if db.softDelete {
+		delete(mapRep, DELETED_AT_FIELD)
+	}
+
+	delete(mapRep, EXPIRES_AT_FIELD)
+	if expiresAt, expires := options.expiresAt(); expires {
+		mapRep[EXPIRES_AT_FIELD] = expiresAt
+	}
+
 	insertStmt, parameters := db.makeInsertStmtAndParameters(mapRep)
 
 	_, err = db.conn.Exec(context.Background(), insertStmt, parameters...)
 
 	if err != nil {
-		return "", err
+		return "", postgresError(err, id)
 	}
 
 	return id, nil
 }
 
+// Upsert inserts obj as a new row or, if a row already has value
+// at field, sets that row's columns to obj's values in a single
+// INSERT ... ON CONFLICT statement. A field of "id" upserts on the
+// primary key, any other field needs a unique index, see
+// CreateUniqueIndex. A soft deleted or expired row that conflicts
+// is restored, and no longer expires. created reports whether a
+// new row was inserted
+func (db *PostgresEngine) Upsert(field string, value any, obj any) (string, bool, error) {
+	defer db.replicas.wrote(db.session)
+	mapRep, err := getMapRep(obj)
+	if err != nil {
+		return "", false, err
+	}
+	if mapRep == nil {
+		mapRep = map[string]any{}
+	}
+
+	if db.trackMetadata {
+		setMetadataOnSave(mapRep)
+	}
+
+	if db.softDelete {
+		delete(mapRep, DELETED_AT_FIELD)
+	}
+
+	delete(mapRep, EXPIRES_AT_FIELD)
+
+	if field == "id" {
+		id, ok := value.(string)
+		if !ok || id == "" {
+			return "", false, newValidationError("id", "format", "must be a non empty string")
+		}
+		mapRep["id"] = id
+	} else {
+		mapRep[field] = value
+		mapRep["id"] = uuid.NewString()
+	}
+
+	insertStmt, parameters := db.makeInsertStmtAndParameters(mapRep)
+	stmt := fmt.Sprintf(`%s %s RETURNING "id", (xmax = 0);`,
+		strings.TrimSuffix(insertStmt, ";"), db.makeOnConflictClause(field, mapRep))
+
+	var id string
+	var created bool
+	err = db.conn.QueryRow(context.Background(), stmt, parameters...).Scan(&id, &created)
+	if err != nil {
+		return "", false, postgresError(err, "")
+	}
+
+	return id, created, nil
+}
+
+// makeOnConflictClause - creates the ON CONFLICT clause of an upsert
+// on field, updating every column in mapRep except the id and,
+// if metadata is tracked, createdAt and version which is incremented.
+// xmax of the returned row is 0 only if the row was inserted
+func (db *PostgresEngine) makeOnConflictClause(field string, mapRep map[string]any) string {
+	columns := []string{}
+	for column := range mapRep {
+		if column == "id" {
+			continue
+		}
+		if db.trackMetadata && (column == CREATED_AT_FIELD || column == VERSION_FIELD) {
+			continue
+		}
+		columns = append(columns, column)
+	}
+
+	// DO NOTHING would return no row, so always set something
+	if len(columns) == 0 {
+		columns = append(columns, field)
+	}
+
+	sort.Strings(columns)
+
+	assignments := []string{}
+	for _, column := range columns {
+		assignments = append(assignments, fmt.Sprintf(`"%s" = EXCLUDED."%s"`, column, column))
+	}
+
+	if db.trackMetadata {
+		assignments = append(assignments, fmt.Sprintf(`"%s" = COALESCE("%s"."%s", 0) + 1`,
+			VERSION_FIELD, db.tableName, VERSION_FIELD))
+	}
+
+	if db.softDelete {
+		assignments = append(assignments, fmt.Sprintf(`"%s" = NULL`, DELETED_AT_FIELD))
+	}
+
+	assignments = append(assignments, fmt.Sprintf(`"%s" = NULL`, EXPIRES_AT_FIELD))
+
+	return fmt.Sprintf(`ON CONFLICT ("%s") DO UPDATE SET %s`, field, strings.Join(assignments, ", "))
+}
+
+// CreateUniqueIndex creates a unique index on field if it does not
+// exist, as Upsert on a field other than id requires one. It fails
+// if field already holds duplicate values, soft deleted rows included
+func (db *PostgresEngine) CreateUniqueIndex(field string) error {
+	stmt := fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS "%s_%s_key" ON "%s" ("%s");`,
+		db.tableName, field, db.tableName, field)
+	_, err := db.conn.Exec(context.Background(), stmt)
+	return postgresError(err, "")
+}
+
 // makeInsertStmtAndParameters - creates an insert statment from mapRep.
 // param mapRep - a map of all the columns and their values.
 // makeInsertStmt constructs the insert statment by sorting
@@ -162,32 +436,25 @@ func (db *PostgresEngine) makeInsertStmtAndParameters(mapRep map[string]any) (st
 // returns objects with any type so users can rebuild
 // objects with their type builders
 func (db *PostgresEngine) Get(id string) (any, error) {
-	stmt := fmt.Sprintf(`SELECT * FROM "%s" WHERE id = $1;`, db.tableName)
-	row, err := db.conn.Query(context.Background(), stmt, id)
+	stmt := fmt.Sprintf(`SELECT * FROM "%s" WHERE id = $1%s;`, db.tableName, db.liveCondition())
+	listOfmapReps, err := db.read(stmt, id)
 
 	if err != nil {
-		return nil, err
+		return nil, postgresError(err, id)
 	}
 
-	mapRep, err := pgx.CollectOneRow(row, pgx.RowToMap)
-	if err != nil {
-		return nil, err
+	if len(listOfmapReps) == 0 {
+		return nil, notFoundError(id)
 	}
-	return mapRep, nil
+	return listOfmapReps[0], nil
 }
 
 func (db *PostgresEngine) GetRecordsByField(field string, value any) ([]map[string]any, error) {
-	stmt := fmt.Sprintf(`SELECT * FROM "%s" WHERE "%s" = $1;`, db.tableName, field)
-
-	row, err := db.conn.Query(context.Background(), stmt, value)
-
-	if err != nil {
-		return nil, err
-	}
+	stmt := fmt.Sprintf(`SELECT * FROM "%s" WHERE "%s" = $1%s;`, db.tableName, field, db.liveCondition())
 
-	listOfmapReps, err := pgx.CollectRows(row, pgx.RowToMap)
+	listOfmapReps, err := db.read(stmt, value)
 	if err != nil {
-		return nil, err
+		return nil, postgresError(err, "")
 	}
 	return listOfmapReps, nil
 }
@@ -212,30 +479,431 @@ func (db *PostgresEngine) GetIdByFieldAndValue(field string, value any) string {
 }
 
 func (db *PostgresEngine) GetAllOfRecords() []map[string]any {
-	stmt := fmt.Sprintf(`SELECT * FROM "%s";`, db.tableName)
+	stmt := fmt.Sprintf(`SELECT * FROM "%s" WHERE %s;`,
+		db.tableName, strings.TrimPrefix(db.liveCondition(), " AND "))
+
+	listOfmapReps, _ := db.read(stmt)
 
-	row, _ := db.conn.Query(context.Background(), stmt)
+	return listOfmapReps
+}
 
-	listOfmapReps, _ := pgx.CollectRows(row, pgx.RowToMap)
+// GetAllOfRecordsIncludingDeleted is GetAllOfRecords with
+// soft deleted rows included
+func (db *PostgresEngine) GetAllOfRecordsIncludingDeleted() []map[string]any {
+	stmt := fmt.Sprintf(`SELECT * FROM "%s" WHERE %s;`, db.tableName, db.unexpiredCondition())
+
+	listOfmapReps, _ := db.read(stmt)
 
 	return listOfmapReps
 }
 
-func (db *PostgresEngine) Delete(id string) {
-	stmt := fmt.Sprintf(`DELETE FROM "%s" WHERE id = $1;`, db.tableName)
-	_, err := db.conn.Exec(context.Background(), stmt, id)
+// Search ranks rows with ts_rank over a 'simple' text search vector
+// of fields. Without an index of fields, see CreateSearchIndex, every
+// row is scanned
+func (db *PostgresEngine) Search(query string, fields []string, limit int) ([]SearchResult, error) {
+	if err := validateSearchFields(fields); err != nil {
+		return nil, err
+	}
+
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	vector := db.makeSearchVector(fields)
+
+	// every term, or a word it starts
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	tsQuery := strings.Join(terms, " & ")
+
+	stmt := fmt.Sprintf(`SELECT *, ts_rank(%s, to_tsquery('simple', $1)) AS "%s" FROM "%s"
+		WHERE %s @@ to_tsquery('simple', $1)%s ORDER BY "%s" DESC, id`,
+		vector, SEARCH_SCORE_FIELD, db.tableName, vector, db.liveCondition(), SEARCH_SCORE_FIELD)
+	if limit > 0 {
+		stmt += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	records, err := db.read(stmt+";", tsQuery)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+		return nil, postgresError(err, "")
+	}
+
+	results := make([]SearchResult, 0, len(records))
+	for _, record := range records {
+		score, _ := getFloat64Equivalent(record[SEARCH_SCORE_FIELD])
+		delete(record, SEARCH_SCORE_FIELD)
+		id, _ := record["id"].(string)
+		results = append(results, SearchResult{ID: id, Score: score, Record: record})
+	}
+	return results, nil
+}
+
+// makeSearchVector returns the text search vector expression of
+// fields, in sorted order so that searches of the same fields in any
+// order use the same index. Punctuation is replaced by spaces first,
+// else the simple parser keeps emails as a single word
+func (db *PostgresEngine) makeSearchVector(fields []string) string {
+	columns := []string{}
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+	for _, field := range sorted {
+		columns = append(columns, fmt.Sprintf(`coalesce("%s"::text, '')`, field))
+	}
+	return fmt.Sprintf(`to_tsvector('simple', regexp_replace(%s, '[^[:alnum:]]+', ' ', 'g'))`,
+		strings.Join(columns, " || ' ' || "))
+}
+
+// CreateSearchIndex creates a GIN index of the search vector of
+// fields if it does not exist, for Search of fields to use. It is
+// built CONCURRENTLY, so writes go on meanwhile, and should be
+// created once, e.g. when the engine is set up, as it scans the table
+func (db *PostgresEngine) CreateSearchIndex(fields ...string) error {
+	if err := validateSearchFields(fields); err != nil {
+		return err
+	}
+
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+	stmt := fmt.Sprintf(`CREATE INDEX CONCURRENTLY IF NOT EXISTS "%s_%s_search" ON "%s" USING GIN (%s);`,
+		db.tableName, strings.Join(sorted, "_"), db.tableName, db.makeSearchVector(fields))
+	_, err := db.conn.Exec(context.Background(), stmt)
+	return postgresError(err, "")
+}
+
+// liveCondition returns the condition leaving soft deleted
+// and expired rows out of a WHERE clause
+func (db *PostgresEngine) liveCondition() string {
+	condition := ""
+	if db.softDelete {
+		condition = fmt.Sprintf(` AND "%s" IS NULL`, DELETED_AT_FIELD)
 	}
+	return fmt.Sprintf(`%s AND %s`, condition, db.unexpiredCondition())
+}
+
+// unexpiredCondition leaves expired rows out, by the clock of the
+// server so that every engine agrees on which rows expired
+func (db *PostgresEngine) unexpiredCondition() string {
+	return fmt.Sprintf(`("%s" IS NULL OR "%s" > %s)`, EXPIRES_AT_FIELD, EXPIRES_AT_FIELD, PG_NOW_MILLIS)
 }
 
-func (db *PostgresEngine) Update(id string, data UpdateDesc) bool {
-	stmt := fmt.Sprintf(`UPDATE "%s" SET "%s" = $1 WHERE id = $2;`, db.tableName, data.Field)
-	cmdTag, err := db.conn.Exec(context.Background(), stmt, data.Value, id)
+// the current time of the server, in unix milliseconds
+const PG_NOW_MILLIS = `(extract(epoch FROM now()) * 1000)`
+
+// Delete deletes the row, or if config.SoftDelete was set,
+// marks it deleted with a deletedAt timestamp
+func (db *PostgresEngine) Delete(id string) error {
+	defer db.replicas.wrote(db.session)
+	stmt := fmt.Sprintf(`DELETE FROM "%s" WHERE id = $1%s;`, db.tableName, db.liveCondition())
+	parameters := []any{id}
+	if db.softDelete {
+		stmt = fmt.Sprintf(`UPDATE "%s" SET "%s" = $2 WHERE id = $1%s;`,
+			db.tableName, DELETED_AT_FIELD, db.liveCondition())
+		parameters = append(parameters, metadataNow())
+	}
+
+	cmdTag, err := db.conn.Exec(context.Background(), stmt, parameters...)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+		return postgresError(err, id)
+	}
+	if cmdTag.RowsAffected() != 1 {
+		return notFoundError(id)
+	}
+	return nil
+}
+
+// Restore undoes the soft delete of the row with id
+func (db *PostgresEngine) Restore(id string) error {
+	defer db.replicas.wrote(db.session)
+	if !db.softDelete {
+		return notFoundError(id)
+	}
+
+	stmt := fmt.Sprintf(`UPDATE "%s" SET "%s" = NULL WHERE id = $1 AND "%s" IS NOT NULL;`,
+		db.tableName, DELETED_AT_FIELD, DELETED_AT_FIELD)
+	cmdTag, err := db.conn.Exec(context.Background(), stmt, id)
+	if err != nil {
+		return postgresError(err, id)
+	}
+	if cmdTag.RowsAffected() != 1 {
+		return notFoundError(id)
+	}
+	return nil
+}
+
+func (db *PostgresEngine) GetDeleted(id string) (any, error) {
+	if !db.softDelete {
+		return nil, notFoundError(id)
+	}
+
+	stmt := fmt.Sprintf(`SELECT * FROM "%s" WHERE id = $1 AND "%s" IS NOT NULL;`,
+		db.tableName, DELETED_AT_FIELD)
+	listOfmapReps, err := db.read(stmt, id)
+
+	if err != nil {
+		return nil, postgresError(err, id)
+	}
+
+	if len(listOfmapReps) == 0 {
+		return nil, notFoundError(id)
+	}
+	return listOfmapReps[0], nil
+}
+
+// PurgeDeleted deletes rows soft deleted more than olderThan
+// ago and returns how many were deleted
+func (db *PostgresEngine) PurgeDeleted(olderThan time.Duration) (int, error) {
+	defer db.replicas.wrote(db.session)
+	if !db.softDelete {
+		return 0, nil
+	}
+
+	stmt := fmt.Sprintf(`DELETE FROM "%s" WHERE "%s" <= $1;`, db.tableName, DELETED_AT_FIELD)
+	cmdTag, err := db.conn.Exec(context.Background(), stmt, time.Now().Add(-olderThan).UnixMilli())
+	if err != nil {
+		return 0, postgresError(err, "")
+	}
+	return int(cmdTag.RowsAffected()), nil
+}
+
+// PurgeExpired deletes expired rows and returns how many were
+// deleted. Rows are also deleted in the background, every
+// config.ExpiredRecordsVacuumInterval
+func (db *PostgresEngine) PurgeExpired() (int, error) {
+	defer db.replicas.wrote(db.session)
+	return db.purgeExpired(db.conn)
+}
+
+func (db *PostgresEngine) purgeExpired(conn *pgx.Conn) (int, error) {
+	stmt := fmt.Sprintf(`DELETE FROM "%s" WHERE "%s" <= %s;`, db.tableName, EXPIRES_AT_FIELD, PG_NOW_MILLIS)
+	cmdTag, err := conn.Exec(context.Background(), stmt)
+	if err != nil {
+		return 0, postgresError(err, "")
+	}
+	return int(cmdTag.RowsAffected()), nil
+}
+
+func (db *PostgresEngine) Update(id string, data UpdateDesc) error {
+	defer db.replicas.wrote(db.session)
+	// metadata is maintained by the engine only
+	if db.trackMetadata && IsMetadataField(data.Field) {
+		return newValidationError(data.Field, "readonly", "is maintained by the engine")
+	}
+
+	if (db.softDelete && data.Field == DELETED_AT_FIELD) || data.Field == EXPIRES_AT_FIELD {
+		return newValidationError(data.Field, "readonly", "is maintained by the engine")
+	}
+
+	setClause := fmt.Sprintf(`"%s" = $1`, data.Field)
+	parameters := []any{data.Value, id}
+	if db.trackMetadata {
+		setClause += fmt.Sprintf(`, "%s" = $3, "%s" = COALESCE("%s", 0) + 1`,
+			UPDATED_AT_FIELD, VERSION_FIELD, VERSION_FIELD)
+		parameters = append(parameters, metadataNow())
+	}
+
+	stmt := fmt.Sprintf(`UPDATE "%s" SET %s WHERE id = $2%s;`, db.tableName, setClause, db.liveCondition())
+	cmdTag, err := db.conn.Exec(context.Background(), stmt, parameters...)
+	if err != nil {
+		return postgresError(err, id)
+	}
+	if cmdTag.RowsAffected() != 1 {
+		return notFoundError(id)
+	}
+	return nil
+}
+
+// UpdateIfVersion updates the row only if its version is
+// expectedVersion, else it returns a *VersionConflictError
+func (db *PostgresEngine) UpdateIfVersion(id string, data UpdateDesc, expectedVersion int) error {
+	defer db.replicas.wrote(db.session)
+	if !db.trackMetadata {
+		return ErrVersionsNotTracked
+	}
+
+	if IsMetadataField(data.Field) || (db.softDelete && data.Field == DELETED_AT_FIELD) ||
+		data.Field == EXPIRES_AT_FIELD {
+		return newValidationError(data.Field, "readonly", "is maintained by the engine")
+	}
+
+	// rows saved before versions were tracked have none, they are
+	// at version 0 as for Update
+	stmt := fmt.Sprintf(`UPDATE "%s" SET "%s" = $1, "%s" = $3, "%s" = COALESCE("%s", 0) + 1 WHERE id = $2 AND COALESCE("%s", 0) = $4%s;`,
+		db.tableName, data.Field, UPDATED_AT_FIELD, VERSION_FIELD, VERSION_FIELD, VERSION_FIELD, db.liveCondition())
+	cmdTag, err := db.conn.Exec(context.Background(), stmt, data.Value, id, metadataNow(), expectedVersion)
+	if err != nil {
+		return postgresError(err, id)
+	}
+
+	if cmdTag.RowsAffected() == 1 {
+		return nil
 	}
-	return cmdTag.RowsAffected() == 1
+
+	// nothing matched, find out whether the row is missing
+	// or has another version
+	var actual *int
+	stmt = fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE id = $1%s;`, VERSION_FIELD, db.tableName, db.liveCondition())
+	err = db.conn.QueryRow(context.Background(), stmt, id).Scan(&actual)
+	if err != nil {
+		return postgresError(err, id)
+	}
+
+	conflict := &VersionConflictError{ID: id, Expected: expectedVersion}
+	if actual != nil {
+		conflict.Actual = *actual
+	}
+	return conflict
+}
+
+// Watch delivers changes to rows matching filter, made by any
+// client of the table, until stop is called. A trigger sends the
+// operation and id of changed rows with pg_notify to a dedicated
+// connection listening on the table's channel, which then reads
+// the row. The trigger is created on first Watch and left on the
+// table, as watchers of other processes share it
+func (db *PostgresEngine) Watch(filter WatchFilter) (<-chan ChangeEvent, func(), error) {
+	listenConn, err := db.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = listenConn.Exec(context.Background(), fmt.Sprintf(`LISTEN "%s";`, db.changesChannel()))
+	if err == nil {
+		err = db.ensureChangeTrigger(listenConn)
+	}
+	if err != nil {
+		listenConn.Close(context.Background())
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		defer listenConn.Close(context.Background())
+		for {
+			notification, err := listenConn.WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Fprintln(os.Stderr, "PostgresEngine.Watch:", err.Error())
+				}
+				return
+			}
+
+			event, found, err := db.readChange(ctx, listenConn, notification.Payload)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "PostgresEngine.Watch:", err.Error())
+				continue
+			}
+
+			// the row was deleted since, its delete event follows
+			if !found || !filter.matches(event) {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, cancel, nil
+}
+
+// changesChannel is qualified by the schema as
+// channels are shared by the whole database
+func (db *PostgresEngine) changesChannel() string {
+	if db.schema != "" {
+		return db.schema + "_" + db.tableName + "_changes"
+	}
+	return db.tableName + "_changes"
+}
+
+func (db *PostgresEngine) changeTriggerName() string {
+	return db.tableName + "_notify_change"
+}
+
+// ensureChangeTrigger creates the change trigger, on conn, unless
+// db already did
+func (db *PostgresEngine) ensureChangeTrigger(conn *pgx.Conn) error {
+	db.changeTrigger.mu.Lock()
+	defer db.changeTrigger.mu.Unlock()
+
+	if db.changeTrigger.created {
+		return nil
+	}
+	err := db.createChangeTrigger(conn)
+	db.changeTrigger.created = err == nil
+	return err
+}
+
+// createChangeTrigger creates the trigger notifying the table's
+// channel of every inserted, updated or deleted row. Only the
+// operation and id are sent, as NOTIFY payloads are limited to
+// 8000 bytes and a larger one would fail the write
+func (db *PostgresEngine) createChangeTrigger(conn *pgx.Conn) error {
+	function := db.changeTriggerName()
+	stmt := fmt.Sprintf(`
+		CREATE OR REPLACE FUNCTION "%s"() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				PERFORM pg_notify('%s', json_build_object('op', lower(TG_OP), 'id', OLD.id)::text);
+				RETURN OLD;
+			END IF;
+			PERFORM pg_notify('%s', json_build_object('op', lower(TG_OP), 'id', NEW.id)::text);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`, function, db.changesChannel(), db.changesChannel())
+
+	_, err := conn.Exec(context.Background(), stmt)
+	if err != nil {
+		return err
+	}
+
+	stmt = fmt.Sprintf(`CREATE OR REPLACE TRIGGER "%s" AFTER INSERT OR UPDATE OR DELETE ON "%s"
+		FOR EACH ROW EXECUTE FUNCTION "%s"();`, function, db.tableName, function)
+	_, err = conn.Exec(context.Background(), stmt)
+	return err
+}
+
+// readChange parses the notification payload and reads, on conn,
+// the inserted or updated row. found is false if the row is gone
+func (db *PostgresEngine) readChange(ctx context.Context, conn *pgx.Conn, payload string) (ChangeEvent, bool, error) {
+	var notification struct {
+		Op ChangeOp `json:"op"`
+		ID string   `json:"id"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &notification)
+	if err != nil {
+		return ChangeEvent{}, false, err
+	}
+
+	event := ChangeEvent{Op: notification.Op, ID: notification.ID}
+	if event.Op == DELETE_OP {
+		return event, true, nil
+	}
+
+	rows, err := conn.Query(ctx, fmt.Sprintf(`SELECT * FROM "%s" WHERE id = $1;`, db.tableName), event.ID)
+	if err != nil {
+		return event, false, err
+	}
+	records, err := pgx.CollectRows(rows, pgx.RowToMap)
+	if err != nil || len(records) == 0 {
+		return event, false, err
+	}
+	event.Data = records[0]
+
+	// a soft delete is an update setting deletedAt
+	if db.softDelete && event.Op == UPDATE_OP && event.Data[DELETED_AT_FIELD] != nil {
+		event.Op = DELETE_OP
+		event.Data = nil
+	}
+
+	return event, true, nil
 }
 
 func (db *PostgresEngine) Commit() error {
@@ -243,17 +911,57 @@ func (db *PostgresEngine) Commit() error {
 }
 
 func (db *PostgresEngine) CloseConnection() error {
+	db.stopReaper()
+	db.replicas.close()
 	return db.conn.Close(context.Background())
 }
 
+// postgres error codes, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	PG_UNIQUE_VIOLATION = "23505"
+	PG_UNDEFINED_COLUMN = "42703"
+	// ON CONFLICT on a column without a unique index
+	PG_NO_CONFLICT_TARGET = "42P10"
+)
+
+// postgresError wraps err, returned by a statement on the row with
+// id, in the storage error it stands for
+func postgresError(err error, id string) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return notFoundError(id)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case PG_UNIQUE_VIOLATION:
+			return duplicateError("%s", pgErr.Detail)
+		case PG_UNDEFINED_COLUMN:
+			return fmt.Errorf("%w: %s", ErrValidation, pgErr.Message)
+		case PG_NO_CONFLICT_TARGET:
+			return fmt.Errorf("%w: %s, see CreateUniqueIndex", ErrValidation, pgErr.Message)
+		}
+	}
+
+	return backendError(err)
+}
+
 func (db *PostgresEngine) DeleteTable() error {
 	_, err := db.conn.Exec(context.Background(), fmt.Sprintf("DROP TABLE %s;", db.tableName))
 	return err
 }
 
 var POSTGRES_ENGINE_MAP = map[string]*PostgresEngine{}
+var postgresEnginesMu sync.Mutex
 
 func MakePostgresEngine(database string, tableName string, fieldAndDesc ...SQL_TABLE_COLUMN_FIELD_AND_DESC) (*PostgresEngine, error) {
+	return MakePostgresEngineInSchema(database, "", tableName, fieldAndDesc...)
+}
+
+// MakePostgresEngineInSchema is MakePostgresEngine for a table in
+// schema, created if need be. "" is the default, public, schema
+func MakePostgresEngineInSchema(database, schema, tableName string, fieldAndDesc ...SQL_TABLE_COLUMN_FIELD_AND_DESC) (*PostgresEngine, error) {
 	if tableName == "" {
 		panic("MakePostgresEngine: tableName cannot be empty")
 	}
@@ -262,24 +970,39 @@ func MakePostgresEngine(database string, tableName string, fieldAndDesc ...SQL_T
 		panic("MakePostgresEngine: database cannot be empty")
 	}
 
-	key := database + tableName
+	postgresEnginesMu.Lock()
+	defer postgresEnginesMu.Unlock()
 
+	key := postgresEngineKey(database, schema, tableName)
 	// implements singleton pattern
 	if POSTGRES_ENGINE_MAP[key] != nil {
 		return POSTGRES_ENGINE_MAP[key], nil
 	}
 
-	postgresEng, err := new(PostgresEngine).New(database, tableName, fieldAndDesc...)
+	postgresEng, err := (&PostgresEngine{schema: schema}).New(database, tableName, fieldAndDesc...)
 
 	if err != nil {
-		panic("MakePostgresEngine: " + err.Error())
+		return nil, backendError(err)
 	}
 
 	POSTGRES_ENGINE_MAP[key] = postgresEng
 	return postgresEng, nil
 }
 
+func postgresEngineKey(database, schema, tableName string) string {
+	if schema != "" {
+		return database + schema + "." + tableName
+	}
+	return database + tableName
+}
+
 func RemovePostgressEngineSingleton(database, tableName string, shouldDeleteTable ...bool) {
+	RemovePostgressEngineSingletonInSchema(database, "", tableName, shouldDeleteTable...)
+}
+
+// RemovePostgressEngineSingletonInSchema is RemovePostgressEngineSingleton
+// for the engine of the table in schema, see MakePostgresEngineInSchema
+func RemovePostgressEngineSingletonInSchema(database, schema, tableName string, shouldDeleteTable ...bool) {
 	if tableName == "" {
 		panic("RemoveDbSingleton: tableName cannot be empty")
 	}
@@ -288,7 +1011,10 @@ func RemovePostgressEngineSingleton(database, tableName string, shouldDeleteTabl
 		panic("RemoveDbSingleton: database cannot be empty")
 	}
 
-	key := database + tableName
+	postgresEnginesMu.Lock()
+	defer postgresEnginesMu.Unlock()
+
+	key := postgresEngineKey(database, schema, tableName)
 	postgresEng, exists := POSTGRES_ENGINE_MAP[key]
 	if exists {
 		// this is just to make deleting a table dificult and intentional
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Iyusuf40/goBackendUtils/config"
+)
+
+// PubSub sends messages, e.g. "session revoked", to the subscribers
+// of their channel at the time they are published. Messages are not
+// kept, a subscriber missing them, e.g. while reconnecting, does not
+// get them later. Errors wrap ErrBackend if the broker failed, e.g.
+// redis is unreachable, or ctx ended
+type PubSub interface {
+	// Publish sends message on channel, returning to how many
+	// subscriptions
+	Publish(ctx context.Context, channel string, message string) (int, error)
+	// Subscribe subscribes to channels
+	Subscribe(ctx context.Context, channels ...string) (*Subscription, error)
+	// PSubscribe subscribes to the channels matching patterns,
+	// globs as the ones of redis: * matches any characters, ? one
+	// and [a-z] one of a set, \ escapes them
+	PSubscribe(ctx context.Context, patterns ...string) (*Subscription, error)
+}
+
+// PUBSUB_BUFFER_SIZE is the number of messages a subscription keeps
+// until they are read. Messages to a subscription whose buffer is
+// full are dropped, as redis drops them for slow subscribers
+const PUBSUB_BUFFER_SIZE = 100
+
+// Message is a message published on Channel, Pattern being the one
+// it was received for, if received on a pattern subscription
+type Message struct {
+	Channel string
+	Pattern string
+	Payload string
+}
+
+// Subscription receives the messages of the channels subscribed to
+type Subscription struct {
+	messages    chan Message
+	done        chan struct{}
+	unsubscribe func() error
+	once        sync.Once
+	err         error
+}
+
+func newSubscription(unsubscribe func() error) *Subscription {
+	return &Subscription{
+		messages:    make(chan Message, PUBSUB_BUFFER_SIZE),
+		done:        make(chan struct{}),
+		unsubscribe: unsubscribe,
+	}
+}
+
+// Messages returns the channel messages are received on, closed
+// once unsubscribed
+func (sub *Subscription) Messages() <-chan Message {
+	return sub.messages
+}
+
+// Unsubscribe stops receiving messages, the ones received until then
+// can still be read from Messages. It is safe to call more than once
+func (sub *Subscription) Unsubscribe() error {
+	sub.once.Do(func() {
+		close(sub.done)
+		sub.err = sub.unsubscribe()
+	})
+	return sub.err
+}
+
+func pubSubValidationError(field string) error {
+	return newValidationError(field, "required", "at least one is required to subscribe")
+}
+
+// GET_PubSub returns the PubSub of the temp stores of typ, see
+// GET_TempStore. The messages of redis go to every instance using
+// it, the ones of file and memory temp stores to the process only
+func GET_PubSub(typ string) PubSub {
+	if typ == "redis" {
+		return MakeRedisPubSub(config.RedisDb)
+	}
+	return MakeMemoryPubSub()
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPubSub is a PubSub of the process, for the file and memory
+// temp stores
+type MemoryPubSub struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]memoryTopics
+}
+
+// the channels or patterns of a subscription
+type memoryTopics struct {
+	channels []string
+	patterns []string
+}
+
+func (MP *MemoryPubSub) New() *MemoryPubSub {
+	MP.subscriptions = map[*Subscription]memoryTopics{}
+	return MP
+}
+
+// Publish does not block on a slow subscriber, the messages it
+// cannot keep are dropped and not counted
+func (MP *MemoryPubSub) Publish(ctx context.Context, channel string, message string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, backendError(err)
+	}
+
+	MP.mu.RLock()
+	defer MP.mu.RUnlock()
+
+	received := 0
+	for sub, topics := range MP.subscriptions {
+		for _, subscribed := range topics.channels {
+			if subscribed == channel && deliver(sub, Message{Channel: channel, Payload: message}) {
+				received++
+			}
+		}
+		for _, pattern := range topics.patterns {
+			if matchGlob(pattern, channel) &&
+				deliver(sub, Message{Channel: channel, Pattern: pattern, Payload: message}) {
+				received++
+			}
+		}
+	}
+	return received, nil
+}
+
+// deliver sends message to sub unless its buffer is full
+func deliver(sub *Subscription, message Message) bool {
+	select {
+	case sub.messages <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (MP *MemoryPubSub) Subscribe(ctx context.Context, channels ...string) (*Subscription, error) {
+	if len(channels) == 0 {
+		return nil, pubSubValidationError("channels")
+	}
+	return MP.subscribe(ctx, memoryTopics{channels: channels})
+}
+
+func (MP *MemoryPubSub) PSubscribe(ctx context.Context, patterns ...string) (*Subscription, error) {
+	if len(patterns) == 0 {
+		return nil, pubSubValidationError("patterns")
+	}
+	return MP.subscribe(ctx, memoryTopics{patterns: patterns})
+}
+
+func (MP *MemoryPubSub) subscribe(ctx context.Context, topics memoryTopics) (*Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, backendError(err)
+	}
+
+	var sub *Subscription
+	sub = newSubscription(func() error {
+		MP.mu.Lock()
+		delete(MP.subscriptions, sub)
+		MP.mu.Unlock()
+
+		// no publisher sends to sub anymore
+		close(sub.messages)
+		return nil
+	})
+
+	MP.mu.Lock()
+	MP.subscriptions[sub] = topics
+	MP.mu.Unlock()
+	return sub, nil
+}
+
+// matchGlob reports whether name matches pattern, a glob as the
+// ones of redis, see PSubscribe
+func matchGlob(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		case '[':
+			if name == "" {
+				return false
+			}
+			matched, rest := matchGlobClass(pattern[1:], name[0])
+			if !matched {
+				return false
+			}
+			pattern, name = rest, name[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if name == "" || pattern[0] != name[0] {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+	return name == ""
+}
+
+// matchGlobClass reports whether c is in the class starting pattern,
+// [a-z] without its [, ^ negating it, and returns what follows it
+func matchGlobClass(pattern string, c byte) (bool, string) {
+	negated := len(pattern) > 0 && pattern[0] == '^'
+	if negated {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			low, high := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+			matched = matched || (low <= c && c <= high)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return matched != negated, pattern
+}
+
+var memoryPubSub = new(MemoryPubSub).New()
+
+// MakeMemoryPubSub returns the MemoryPubSub of the process
+func MakeMemoryPubSub() PubSub {
+	return memoryPubSub
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisPubSub is the PubSub of a redis. A subscription outlives the
+// connections it loses, go-redis reconnects and subscribes again,
+// losing the messages published meanwhile
+type RedisPubSub struct {
+	client redis.UniversalClient
+}
+
+func (RP *RedisPubSub) New(client redis.UniversalClient) *RedisPubSub {
+	RP.client = client
+	return RP
+}
+
+func (RP *RedisPubSub) Publish(ctx context.Context, channel string, message string) (int, error) {
+	received, err := RP.client.Publish(ctx, channel, message).Result()
+	return int(received), backendError(err)
+}
+
+func (RP *RedisPubSub) Subscribe(ctx context.Context, channels ...string) (*Subscription, error) {
+	if len(channels) == 0 {
+		return nil, pubSubValidationError("channels")
+	}
+	return RP.subscribe(ctx, RP.client.Subscribe(ctx, channels...))
+}
+
+func (RP *RedisPubSub) PSubscribe(ctx context.Context, patterns ...string) (*Subscription, error) {
+	if len(patterns) == 0 {
+		return nil, pubSubValidationError("patterns")
+	}
+	return RP.subscribe(ctx, RP.client.PSubscribe(ctx, patterns...))
+}
+
+func (RP *RedisPubSub) subscribe(ctx context.Context, pubsub *redis.PubSub) (*Subscription, error) {
+	// the reply to the subscription tells whether redis is reachable
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, backendError(err)
+	}
+
+	sub := newSubscription(pubsub.Close)
+	received := pubsub.Channel(redis.WithChannelSize(PUBSUB_BUFFER_SIZE))
+	go func() {
+		defer close(sub.messages)
+		for {
+			select {
+			case <-sub.done:
+				return
+			case message, open := <-received:
+				if !open {
+					return
+				}
+				select {
+				case sub.messages <- Message{Channel: message.Channel, Pattern: message.Pattern,
+					Payload: message.Payload}:
+				case <-sub.done:
+					return
+				}
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// MakeRedisPubSub returns the RedisPubSub sharing the connections of
+// the RedisStore of db, see MakeRedisWrapper. Channels are not
+// kept apart by db
+func MakeRedisPubSub(db int) PubSub {
+	return new(RedisPubSub).New(MakeRedisWrapper(db).(*RedisWrapper).redis.client)
+}
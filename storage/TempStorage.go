@@ -1,17 +1,129 @@
 package storage
 
+import (
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/config"
+)
+
 type TempStore interface {
 	GetVal(key string) string
 	SetKeyToVal(key string, value string) bool
 	SetKeyToValWIthExpiry(key string, value string, expiry float64) bool
 	ChangeKeyEpiry(key string, newExpiry float64) bool
 	DelKey(key string) bool
+
+	// counters are kept as base 10 strings, GetVal reads them. A
+	// missing key counts from 0. expiry, in seconds, is set if the
+	// counter has none, e.g. as it is created, 0 sets none. They
+	// return the new count, or an ErrValidation if key holds
+	// something other than an integer
+	Incr(key string, expiry float64) (int64, error)
+	IncrBy(key string, by int64, expiry float64) (int64, error)
+	Decr(key string, expiry float64) (int64, error)
+
+	// SetNX sets key to value, expiring in expiry seconds if not 0,
+	// only if key does not exist. It reports whether it did
+	SetNX(key string, value string, expiry float64) bool
+	// GetAndDelete returns the value of key and deletes it at once,
+	// so that only one caller ever gets it. "" if key does not exist
+	GetAndDelete(key string) string
+	// CompareAndSwap sets key to newValue, keeping its expiry, only
+	// if it holds oldValue. It reports whether it did
+	CompareAndSwap(key string, oldValue string, newValue string) bool
+	// CompareAndDelete deletes key, and CompareAndExpire expires it
+	// in ttl, only if it holds value, e.g. the token of the owner of
+	// a lock. They report whether they did
+	CompareAndDelete(key string, value string) bool
+	CompareAndExpire(key string, value string, ttl time.Duration) bool
+	// GetTTL returns the seconds key lives for, TTL_NO_EXPIRY if it
+	// does not expire and TTL_NO_KEY if it does not exist
+	GetTTL(key string) float64
+
+	// expiries as durations, kept to the millisecond. SetWithTTL
+	// sets key to value expiring in ttl, 0 for never. Expire
+	// reports whether key exists, Persist whether it removed an
+	// expiry of key. TTL is GetTTL as a duration, TTL_NO_EXPIRY and
+	// TTL_NO_KEY then being nanoseconds
+	SetWithTTL(key string, value string, ttl time.Duration) bool
+	Expire(key string, ttl time.Duration) bool
+	Persist(key string) bool
+	TTL(key string) time.Duration
+
+	// Scan returns the keys starting with prefix, "" for all, in
+	// no particular order
+	Scan(prefix string) []string
+	// DelPrefix deletes the keys starting with prefix, returning
+	// how many it deleted
+	DelPrefix(prefix string) int
+	// Count returns the number of keys starting with prefix
+	Count(prefix string) int
+
+	// hashes, sets and lists are kept under a key as strings are,
+	// the key expiring as set by ChangeKeyEpiry and going with
+	// DelKey. A key emptied of its fields, members or values is
+	// deleted. Used on a key holding another kind of value, they
+	// read nothing and write nothing
+
+	// HSet sets the fields of the hash of key to their values
+	HSet(key string, fields map[string]string) bool
+	HGet(key string, field string) string
+	HGetAll(key string) map[string]string
+	// HDel returns how many fields it deleted
+	HDel(key string, fields ...string) int
+
+	// SAdd and SRem return how many members they added or removed
+	SAdd(key string, members ...string) int
+	// SMembers returns the members in no particular order
+	SMembers(key string) []string
+	SRem(key string, members ...string) int
+
+	// LPush and RPush push values to the head, the first value
+	// pushed first, or to the tail of the list of key, returning
+	// its length. The pops return "" if the list is empty
+	LPush(key string, values ...string) int
+	RPush(key string, values ...string) int
+	LPop(key string) string
+	RPop(key string) string
+	// LRange returns the values from start to stop, included,
+	// negative indexes counting from the tail, -1 being the last
+	LRange(key string, start, stop int) []string
 }
 
+const (
+	TTL_NO_EXPIRY = -1.0
+	TTL_NO_KEY    = -2.0
+)
+
+// ttlSeconds returns ttl, as returned by TTL, in seconds, as
+// GetTTL returns it
+func ttlSeconds(ttl time.Duration) float64 {
+	if ttl == TTL_NO_EXPIRY || ttl == TTL_NO_KEY {
+		return float64(ttl)
+	}
+	return ttl.Seconds()
+}
+
+func notACounterError(key string) error {
+	return newValidationError(key, "type", "does not hold an integer")
+}
+
+func wrongKindError(key string) error {
+	return newValidationError(key, "type", "holds another kind of value")
+}
+
+// GET_TempStore returns the temp store of recordsName of database,
+// typ being one of "file", "redis" or "memory". Redis dbs being
+// numbered, a redis store is in the db of config.RedisDb whatever
+// database
 func GET_TempStore(typ, database, recordsName string) TempStore {
 	if typ == "redis" {
-		DEFAULT_REDIS_DB := 0
-		return MakeRedisWrapper(DEFAULT_REDIS_DB)
+		// the keys of recordsName are kept apart from the ones of
+		// other records in the db of config.RedisDb
+		return MakeNamespacedTempStore(MakeRedisWrapper(config.RedisDb), recordsName)
+	}
+	if typ == "memory" {
+		return MakeMemoryTempStore(database, recordsName)
 	}
 	return MakeTempStoreFileDbImpl(database, recordsName)
 }
@@ -2,9 +2,17 @@ package storage
 
 import (
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// every TempStoreFileDbImpl reads and writes its maps under it,
+// as the ones on the same file share them, which also makes
+// e.g. counters and GetAndDelete atomic
+var fileTempStoreMu sync.Mutex
+
 type TempStoreFileDbImpl struct {
 	db       *FileDb
 	MapStore map[string]any
@@ -24,6 +32,9 @@ func (TS *TempStoreFileDbImpl) New(dbFile, recordsName string) TempStore {
 }
 
 func (TS *TempStoreFileDbImpl) reload() {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
 	mapStore := TS.getMapStore()
 	if mapStore == nil {
 		TS.MapStore = map[string]any{}
@@ -37,6 +48,9 @@ func (TS *TempStoreFileDbImpl) reload() {
 }
 
 func (TS *TempStoreFileDbImpl) GetVal(key string) string {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
 	mapStore := TS.getMapStore()
 
 	if mapStore == nil {
@@ -53,6 +67,13 @@ func (TS *TempStoreFileDbImpl) GetVal(key string) string {
 }
 
 func (TS *TempStoreFileDbImpl) SetKeyToVal(key string, value string) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	return TS.setKeyToVal(key, value)
+}
+
+func (TS *TempStoreFileDbImpl) setKeyToVal(key string, value string) bool {
 	mapStore := TS.getMapStore()
 	if mapStore == nil {
 		return false
@@ -62,29 +83,107 @@ func (TS *TempStoreFileDbImpl) SetKeyToVal(key string, value string) bool {
 	return true
 }
 
-// expiry is in seconds
-func (TS *TempStoreFileDbImpl) setKeyToExpiry(key string, expiry float64) bool {
+// the TimerMap holds the unix milliseconds keys expire at
+func (TS *TempStoreFileDbImpl) setKeyToExpiry(key string, ttl time.Duration) bool {
 	timerStore := TS.getTimerMap()
-	expiryTime := time.Now().Add(time.Second * time.Duration(expiry)).Unix()
-	timerStore[key] = float64(expiryTime)
+	timerStore[key] = float64(time.Now().Add(ttl).UnixMilli())
 	TS.commit()
 	return true
 }
 
+// OLDEST_EXPIRY_MILLIS is the least unix milliseconds of the
+// TimerMap, it held unix seconds, far less, in former versions
+const OLDEST_EXPIRY_MILLIS = 1e11
+
+// expiresAtOfKey returns the unix milliseconds key expires at,
+// false if it does not expire
+func (TS *TempStoreFileDbImpl) expiresAtOfKey(key string) (int64, bool) {
+	expiresAt, exists := TS.getTimerMap()[key].(float64)
+	if !exists {
+		return 0, false
+	}
+	if expiresAt < OLDEST_EXPIRY_MILLIS {
+		expiresAt *= 1000
+	}
+	return int64(expiresAt), true
+}
+
 // sets key to val in MapSTore
 // sets key to expiry in TimerMap
 func (TS *TempStoreFileDbImpl) SetKeyToValWIthExpiry(key string, value string, expiry float64) bool {
-	TS.setKeyToExpiry(key, expiry)
-	TS.SetKeyToVal(key, value)
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	TS.setKeyToExpiry(key, secondsToDuration(expiry))
+	TS.setKeyToVal(key, value)
 	return true
 }
 
 func (TS *TempStoreFileDbImpl) ChangeKeyEpiry(key string, newExpiry float64) bool {
-	TS.setKeyToExpiry(key, newExpiry)
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	TS.setKeyToExpiry(key, secondsToDuration(newExpiry))
 	return true
 }
 
+// SetWithTTL deletes key if ttl is negative
+func (TS *TempStoreFileDbImpl) SetWithTTL(key string, value string, ttl time.Duration) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	if TS.getMapStore() == nil {
+		return false
+	}
+
+	switch {
+	case ttl < 0:
+		TS.deleteKeyTimerAndValue(key)
+		return true
+	case ttl == 0:
+		delete(TS.getTimerMap(), key)
+	default:
+		TS.setKeyToExpiry(key, ttl)
+	}
+	return TS.setKeyToVal(key, value)
+}
+
+func (TS *TempStoreFileDbImpl) Expire(key string, ttl time.Duration) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	if !TS.exists(key) {
+		return false
+	}
+	return TS.setKeyToExpiry(key, ttl)
+}
+
+func (TS *TempStoreFileDbImpl) Persist(key string) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	if !TS.exists(key) || !TS.keyExistsInTimerMap(key) {
+		return false
+	}
+	delete(TS.getTimerMap(), key)
+	TS.commit()
+	return true
+}
+
+// exists reports whether key holds a value that has not expired
+func (TS *TempStoreFileDbImpl) exists(key string) bool {
+	mapStore := TS.getMapStore()
+	if mapStore == nil || TS.isExpired(key) {
+		return false
+	}
+	_, exists := mapStore[key]
+	return exists
+}
+
 func (TS *TempStoreFileDbImpl) DelKey(key string) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
 	mapStore := TS.getMapStore()
 
 	if TS.keyExistsInTimerMap(key) {
@@ -96,6 +195,384 @@ func (TS *TempStoreFileDbImpl) DelKey(key string) bool {
 	return true
 }
 
+func (TS *TempStoreFileDbImpl) Incr(key string, expiry float64) (int64, error) {
+	return TS.IncrBy(key, 1, expiry)
+}
+
+func (TS *TempStoreFileDbImpl) IncrBy(key string, by int64, expiry float64) (int64, error) {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	mapStore := TS.getMapStore()
+	if mapStore == nil {
+		return 0, notFoundError(key)
+	}
+
+	if TS.isExpired(key) {
+		TS.deleteKeyTimerAndValueHelper(key)
+	}
+
+	count := int64(0)
+	if val, exists := mapStore[key]; exists {
+		strVal, _ := val.(string)
+		parsed, err := strconv.ParseInt(strVal, 10, 64)
+		if err != nil {
+			return 0, notACounterError(key)
+		}
+		count = parsed
+	}
+	count += by
+	mapStore[key] = strconv.FormatInt(count, 10)
+
+	if expiry > 0 && !TS.keyExistsInTimerMap(key) {
+		// commits
+		TS.setKeyToExpiry(key, secondsToDuration(expiry))
+	} else {
+		TS.commit()
+	}
+	return count, nil
+}
+
+func (TS *TempStoreFileDbImpl) Decr(key string, expiry float64) (int64, error) {
+	return TS.IncrBy(key, -1, expiry)
+}
+
+func (TS *TempStoreFileDbImpl) SetNX(key string, value string, expiry float64) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	mapStore := TS.getMapStore()
+	if mapStore == nil {
+		return false
+	}
+
+	if TS.isExpired(key) {
+		TS.deleteKeyTimerAndValueHelper(key)
+	}
+	if _, exists := mapStore[key]; exists {
+		return false
+	}
+
+	mapStore[key] = value
+	if expiry != 0 {
+		// commits
+		TS.setKeyToExpiry(key, secondsToDuration(expiry))
+	} else {
+		TS.commit()
+	}
+	return true
+}
+
+func (TS *TempStoreFileDbImpl) GetAndDelete(key string) string {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	mapStore := TS.getMapStore()
+	if mapStore == nil {
+		return ""
+	}
+
+	stored, exists := mapStore[key]
+	val, isString := stored.(string)
+	if TS.isExpired(key) {
+		val = ""
+	} else if exists && !isString {
+		return ""
+	}
+	if exists || TS.keyExistsInTimerMap(key) {
+		TS.deleteKeyTimerAndValue(key)
+	}
+	return val
+}
+
+func (TS *TempStoreFileDbImpl) CompareAndSwap(key string, oldValue string, newValue string) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	mapStore := TS.getMapStore()
+	if mapStore == nil || TS.isExpired(key) {
+		return false
+	}
+
+	val, exists := mapStore[key].(string)
+	if !exists || val != oldValue {
+		return false
+	}
+	mapStore[key] = newValue
+	TS.commit()
+	return true
+}
+
+func (TS *TempStoreFileDbImpl) CompareAndDelete(key string, value string) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	if !TS.holds(key, value) {
+		return false
+	}
+	TS.deleteKeyTimerAndValue(key)
+	return true
+}
+
+func (TS *TempStoreFileDbImpl) CompareAndExpire(key string, value string, ttl time.Duration) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	if !TS.holds(key, value) {
+		return false
+	}
+	return TS.setKeyToExpiry(key, ttl)
+}
+
+// holds reports whether key holds value and has not expired
+func (TS *TempStoreFileDbImpl) holds(key string, value string) bool {
+	mapStore := TS.getMapStore()
+	if mapStore == nil || TS.isExpired(key) {
+		return false
+	}
+	val, exists := mapStore[key].(string)
+	return exists && val == value
+}
+
+func (TS *TempStoreFileDbImpl) GetTTL(key string) float64 {
+	return ttlSeconds(TS.TTL(key))
+}
+
+func (TS *TempStoreFileDbImpl) TTL(key string) time.Duration {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	if !TS.exists(key) {
+		return TTL_NO_KEY
+	}
+
+	expiresAt, expires := TS.expiresAtOfKey(key)
+	if !expires {
+		return TTL_NO_EXPIRY
+	}
+	return time.Duration(expiresAt-time.Now().UnixMilli()) * time.Millisecond
+}
+
+func (TS *TempStoreFileDbImpl) Scan(prefix string) []string {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	return TS.scan(prefix)
+}
+
+func (TS *TempStoreFileDbImpl) scan(prefix string) []string {
+	keys := []string{}
+	for key := range TS.getMapStore() {
+		if strings.HasPrefix(key, prefix) && !TS.isExpired(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (TS *TempStoreFileDbImpl) DelPrefix(prefix string) int {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	keys := TS.scan(prefix)
+	for _, key := range keys {
+		TS.deleteKeyTimerAndValueHelper(key)
+	}
+	if len(keys) > 0 {
+		TS.commit()
+	}
+	return len(keys)
+}
+
+func (TS *TempStoreFileDbImpl) Count(prefix string) int {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	return len(TS.scan(prefix))
+}
+
+// update hands change the live entry of key, or a new entry of kind
+// if key does not exist, and stores it, unless key holds another
+// kind of value. It reports whether it did
+func (TS *TempStoreFileDbImpl) update(key string, kind valueKind, change func(entry *tempEntry)) bool {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	mapStore := TS.getMapStore()
+	if mapStore == nil {
+		return false
+	}
+
+	if TS.isExpired(key) {
+		TS.deleteKeyTimerAndValueHelper(key)
+	}
+
+	entry := newTempEntry(kind)
+	if stored, exists := mapStore[key]; exists {
+		entry = tempEntryOfStored(stored)
+	}
+	if entry.kind() != kind {
+		return false
+	}
+
+	change(&entry)
+	if entry.isEmpty() {
+		TS.deleteKeyTimerAndValueHelper(key)
+	} else {
+		mapStore[key] = storedOfTempEntry(entry)
+	}
+	TS.commit()
+	return true
+}
+
+// read hands read the live entry of key, if it holds kind
+func (TS *TempStoreFileDbImpl) read(key string, kind valueKind, read func(entry tempEntry)) {
+	fileTempStoreMu.Lock()
+	defer fileTempStoreMu.Unlock()
+
+	mapStore := TS.getMapStore()
+	if mapStore == nil || TS.isExpired(key) {
+		return
+	}
+
+	stored, exists := mapStore[key]
+	if !exists {
+		return
+	}
+	if entry := tempEntryOfStored(stored); entry.kind() == kind {
+		read(entry)
+	}
+}
+
+// hashes, sets and lists are kept in MapStore as {"hash": {field:
+// value}}, {"set": [member]} and {"list": [value]}, the way they
+// read back from the json file
+func tempEntryOfStored(stored any) tempEntry {
+	object, isObject := stored.(map[string]any)
+	if !isObject {
+		value, _ := stored.(string)
+		return tempEntry{Value: value}
+	}
+
+	entry := tempEntry{}
+	if hash, ok := object["hash"].(map[string]any); ok {
+		entry.Hash = make(map[string]string, len(hash))
+		for field, value := range hash {
+			entry.Hash[field], _ = value.(string)
+		}
+	}
+	if set, ok := object["set"].([]any); ok {
+		entry.Set = make(map[string]struct{}, len(set))
+		for _, member := range set {
+			member, _ := member.(string)
+			entry.Set[member] = struct{}{}
+		}
+	}
+	if list, ok := object["list"].([]any); ok {
+		entry.List = make([]string, 0, len(list))
+		for _, value := range list {
+			value, _ := value.(string)
+			entry.List = append(entry.List, value)
+		}
+	}
+	return entry
+}
+
+func storedOfTempEntry(entry tempEntry) any {
+	switch entry.kind() {
+	case hashKind:
+		hash := make(map[string]any, len(entry.Hash))
+		for field, value := range entry.Hash {
+			hash[field] = value
+		}
+		return map[string]any{"hash": hash}
+	case setKind:
+		set := make([]any, 0, len(entry.Set))
+		for member := range entry.Set {
+			set = append(set, member)
+		}
+		return map[string]any{"set": set}
+	case listKind:
+		list := make([]any, 0, len(entry.List))
+		for _, value := range entry.List {
+			list = append(list, value)
+		}
+		return map[string]any{"list": list}
+	}
+	return entry.Value
+}
+
+func (TS *TempStoreFileDbImpl) HSet(key string, fields map[string]string) bool {
+	return len(fields) > 0 &&
+		TS.update(key, hashKind, func(entry *tempEntry) { entry.hSet(fields) })
+}
+
+func (TS *TempStoreFileDbImpl) HGet(key string, field string) string {
+	value := ""
+	TS.read(key, hashKind, func(entry tempEntry) { value = entry.Hash[field] })
+	return value
+}
+
+func (TS *TempStoreFileDbImpl) HGetAll(key string) map[string]string {
+	fields := map[string]string{}
+	TS.read(key, hashKind, func(entry tempEntry) { fields = entry.Hash })
+	return fields
+}
+
+func (TS *TempStoreFileDbImpl) HDel(key string, fields ...string) int {
+	deleted := 0
+	TS.update(key, hashKind, func(entry *tempEntry) { deleted = entry.hDel(fields) })
+	return deleted
+}
+
+func (TS *TempStoreFileDbImpl) SAdd(key string, members ...string) int {
+	added := 0
+	TS.update(key, setKind, func(entry *tempEntry) { added = entry.sAdd(members) })
+	return added
+}
+
+func (TS *TempStoreFileDbImpl) SMembers(key string) []string {
+	members := []string{}
+	TS.read(key, setKind, func(entry tempEntry) { members = entry.sMembers() })
+	return members
+}
+
+func (TS *TempStoreFileDbImpl) SRem(key string, members ...string) int {
+	removed := 0
+	TS.update(key, setKind, func(entry *tempEntry) { removed = entry.sRem(members) })
+	return removed
+}
+
+func (TS *TempStoreFileDbImpl) LPush(key string, values ...string) int {
+	length := 0
+	TS.update(key, listKind, func(entry *tempEntry) { length = entry.lPush(values) })
+	return length
+}
+
+func (TS *TempStoreFileDbImpl) RPush(key string, values ...string) int {
+	length := 0
+	TS.update(key, listKind, func(entry *tempEntry) { length = entry.rPush(values) })
+	return length
+}
+
+func (TS *TempStoreFileDbImpl) LPop(key string) string {
+	value := ""
+	TS.update(key, listKind, func(entry *tempEntry) { value = entry.lPop() })
+	return value
+}
+
+func (TS *TempStoreFileDbImpl) RPop(key string) string {
+	value := ""
+	TS.update(key, listKind, func(entry *tempEntry) { value = entry.rPop() })
+	return value
+}
+
+func (TS *TempStoreFileDbImpl) LRange(key string, start, stop int) []string {
+	values := []string{}
+	TS.read(key, listKind, func(entry tempEntry) { values = entry.lRange(start, stop) })
+	return values
+}
+
 func (TS *TempStoreFileDbImpl) DeleteDb() {
 	TS.db.DeleteDb()
 }
@@ -122,15 +599,8 @@ func (TS *TempStoreFileDbImpl) runVacuum() {
 }
 
 func (TS *TempStoreFileDbImpl) isExpired(key string) bool {
-	if TS.keyExistsInTimerMap(key) {
-		unixNow := time.Now().Unix()
-		timeToExpire := TS.getTimerMap()[key].(float64)
-		if timeToExpire <= float64(unixNow) {
-			return true
-		}
-
-	}
-	return false
+	expiresAt, expires := TS.expiresAtOfKey(key)
+	return expires && expiresAt <= time.Now().UnixMilli()
 }
 
 func (TS *TempStoreFileDbImpl) deleteKeyTimerAndValue(key string) {
@@ -146,7 +616,7 @@ func (TS *TempStoreFileDbImpl) deleteKeyTimerAndValueHelper(key string) {
 }
 
 func (TS *TempStoreFileDbImpl) getMapStore() map[string]any {
-	mapStoreList, _ := TS.db.GetRecordsByField("Init", true)
+	mapStoreList := TS.db.storedRecordsByField("Init", true)
 	if mapStoreList == nil {
 		return nil
 	}
@@ -160,7 +630,7 @@ func (TS *TempStoreFileDbImpl) getMapStore() map[string]any {
 }
 
 func (TS *TempStoreFileDbImpl) getTimerMap() map[string]any {
-	mapStoreList, _ := TS.db.GetRecordsByField("Init", true)
+	mapStoreList := TS.db.storedRecordsByField("Init", true)
 	if mapStoreList == nil {
 		return nil
 	}
@@ -0,0 +1,762 @@
+package storage
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/config"
+)
+
+// number of shards keys are spread over, each with its own lock
+const MEMORY_TEMP_STORE_SHARDS = 32
+
+// the longest the sweeper sleeps when no key expires sooner
+const MEMORY_TEMP_STORE_MAX_SWEEP_WAIT = time.Minute
+
+type MemoryTempStoreOptions struct {
+	// file the keys are snapshot to every SnapshotInterval and on
+	// Close, and loaded from when the store is made. "" keeps the
+	// keys in memory only
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+}
+
+type memoryShard struct {
+	mu      sync.RWMutex
+	entries map[string]tempEntry
+}
+
+// expiryItem schedules the expiry of key at. A key has one item at
+// most, moved when the key gets another expiry. Items are not removed
+// when their key is deleted or persisted, the sweeper skips the ones
+// that no longer match their entry
+type expiryItem struct {
+	key string
+	at  int64
+	// position of the item in the heap
+	index int
+}
+
+// expiryHeap is a min-heap of expiries, the next one first
+type expiryHeap []*expiryItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *expiryHeap) Push(item any) {
+	item.(*expiryItem).index = len(*h)
+	*h = append(*h, item.(*expiryItem))
+}
+func (h *expiryHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// MemoryTempStore is a TempStore kept in the memory of the process.
+// Keys are spread over shards so that concurrent calls seldom wait
+// on each other. Expired keys are never read, and are erased by a
+// background sweeper when they expire. Close stops the background
+// goroutines, taking a last snapshot if snapshots are enabled
+type MemoryTempStore struct {
+	shards   [MEMORY_TEMP_STORE_SHARDS]memoryShard
+	expiryMu sync.Mutex
+	expiries expiryHeap
+	// the item of each key in expiries
+	expiryOf map[string]*expiryItem
+	// wakes the sweeper up when a key expires before the others
+	wake       chan struct{}
+	options    MemoryTempStoreOptions
+	snapshotMu sync.Mutex
+	done       chan struct{}
+	// the sweeper and snapshotter, Close waits for them to return
+	running   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func (MS *MemoryTempStore) New(options MemoryTempStoreOptions) *MemoryTempStore {
+	for i := range MS.shards {
+		MS.shards[i].entries = map[string]tempEntry{}
+	}
+	MS.expiryOf = map[string]*expiryItem{}
+	MS.wake = make(chan struct{}, 1)
+	MS.done = make(chan struct{})
+	MS.options = options
+
+	if options.SnapshotPath != "" {
+		if err := MS.loadSnapshot(); err != nil {
+			fmt.Fprintln(os.Stderr, "MemoryTempStore: failed to load snapshot:", err.Error())
+		}
+	}
+
+	MS.running.Add(1)
+	go MS.sweep()
+
+	if options.SnapshotPath != "" && options.SnapshotInterval > 0 {
+		MS.running.Add(1)
+		go MS.snapshotEvery(options.SnapshotInterval)
+	}
+
+	return MS
+}
+
+func (MS *MemoryTempStore) shardOf(key string) *memoryShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return &MS.shards[hash.Sum32()%MEMORY_TEMP_STORE_SHARDS]
+}
+
+func (MS *MemoryTempStore) GetVal(key string) string {
+	shard := MS.shardOf(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, exists := shard.entries[key]
+	if !exists || entry.isExpired(time.Now().UnixNano()) {
+		return ""
+	}
+	return entry.Value
+}
+
+// SetKeyToVal sets key to value, the key no longer expires
+func (MS *MemoryTempStore) SetKeyToVal(key string, value string) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.entries[key] = tempEntry{Value: value}
+	return true
+}
+
+// expiry is in seconds
+func (MS *MemoryTempStore) SetKeyToValWIthExpiry(key string, value string, expiry float64) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := tempEntry{Value: value, ExpiresAt: expiresAtOf(expiry)}
+	shard.entries[key] = entry
+	MS.scheduleExpiry(key, entry.ExpiresAt)
+	return true
+}
+
+// ChangeKeyEpiry returns false if key does not exist
+func (MS *MemoryTempStore) ChangeKeyEpiry(key string, newExpiry float64) bool {
+	return MS.Expire(key, secondsToDuration(newExpiry))
+}
+
+// SetWithTTL deletes key if ttl is negative
+func (MS *MemoryTempStore) SetWithTTL(key string, value string, ttl time.Duration) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	switch {
+	case ttl < 0:
+		delete(shard.entries, key)
+	case ttl == 0:
+		shard.entries[key] = tempEntry{Value: value}
+	default:
+		entry := tempEntry{Value: value, ExpiresAt: expiresAtIn(ttl)}
+		shard.entries[key] = entry
+		MS.scheduleExpiry(key, entry.ExpiresAt)
+	}
+	return true
+}
+
+func (MS *MemoryTempStore) Expire(key string, ttl time.Duration) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if !exists || entry.isExpired(time.Now().UnixNano()) {
+		return false
+	}
+
+	entry.ExpiresAt = expiresAtIn(ttl)
+	shard.entries[key] = entry
+	MS.scheduleExpiry(key, entry.ExpiresAt)
+	return true
+}
+
+func (MS *MemoryTempStore) Persist(key string) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if !exists || entry.ExpiresAt == 0 || entry.isExpired(time.Now().UnixNano()) {
+		return false
+	}
+
+	// the expiry scheduled is skipped, as it is not the key's
+	entry.ExpiresAt = 0
+	shard.entries[key] = entry
+	return true
+}
+
+func (MS *MemoryTempStore) DelKey(key string) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	delete(shard.entries, key)
+	return true
+}
+
+func (MS *MemoryTempStore) Incr(key string, expiry float64) (int64, error) {
+	return MS.IncrBy(key, 1, expiry)
+}
+
+func (MS *MemoryTempStore) IncrBy(key string, by int64, expiry float64) (int64, error) {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if !exists || entry.isExpired(time.Now().UnixNano()) {
+		entry = tempEntry{Value: "0"}
+	}
+
+	count, err := strconv.ParseInt(entry.Value, 10, 64)
+	if err != nil {
+		return 0, notACounterError(key)
+	}
+	count += by
+	entry.Value = strconv.FormatInt(count, 10)
+
+	if entry.ExpiresAt == 0 && expiry > 0 {
+		entry.ExpiresAt = expiresAtOf(expiry)
+		MS.scheduleExpiry(key, entry.ExpiresAt)
+	}
+	shard.entries[key] = entry
+	return count, nil
+}
+
+func (MS *MemoryTempStore) Decr(key string, expiry float64) (int64, error) {
+	return MS.IncrBy(key, -1, expiry)
+}
+
+func (MS *MemoryTempStore) SetNX(key string, value string, expiry float64) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if exists && !entry.isExpired(time.Now().UnixNano()) {
+		return false
+	}
+
+	entry = tempEntry{Value: value}
+	if expiry != 0 {
+		entry.ExpiresAt = expiresAtOf(expiry)
+		MS.scheduleExpiry(key, entry.ExpiresAt)
+	}
+	shard.entries[key] = entry
+	return true
+}
+
+func (MS *MemoryTempStore) GetAndDelete(key string) string {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if exists && entry.kind() != stringKind && !entry.isExpired(time.Now().UnixNano()) {
+		return ""
+	}
+	delete(shard.entries, key)
+	if !exists || entry.isExpired(time.Now().UnixNano()) {
+		return ""
+	}
+	return entry.Value
+}
+
+func (MS *MemoryTempStore) CompareAndSwap(key string, oldValue string, newValue string) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if !exists || entry.isExpired(time.Now().UnixNano()) ||
+		entry.kind() != stringKind || entry.Value != oldValue {
+		return false
+	}
+	entry.Value = newValue
+	shard.entries[key] = entry
+	return true
+}
+
+func (MS *MemoryTempStore) CompareAndDelete(key string, value string) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if !exists || entry.isExpired(time.Now().UnixNano()) ||
+		entry.kind() != stringKind || entry.Value != value {
+		return false
+	}
+	delete(shard.entries, key)
+	return true
+}
+
+func (MS *MemoryTempStore) CompareAndExpire(key string, value string, ttl time.Duration) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if !exists || entry.isExpired(time.Now().UnixNano()) ||
+		entry.kind() != stringKind || entry.Value != value {
+		return false
+	}
+	entry.ExpiresAt = expiresAtIn(ttl)
+	shard.entries[key] = entry
+	MS.scheduleExpiry(key, entry.ExpiresAt)
+	return true
+}
+
+func (MS *MemoryTempStore) GetTTL(key string) float64 {
+	return ttlSeconds(MS.TTL(key))
+}
+
+func (MS *MemoryTempStore) TTL(key string) time.Duration {
+	shard := MS.shardOf(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	entry, exists := shard.entries[key]
+	if !exists || entry.isExpired(now) {
+		return TTL_NO_KEY
+	}
+	if entry.ExpiresAt == 0 {
+		return TTL_NO_EXPIRY
+	}
+	return time.Duration(entry.ExpiresAt - now)
+}
+
+func (MS *MemoryTempStore) Scan(prefix string) []string {
+	now := time.Now().UnixNano()
+	keys := []string{}
+	for i := range MS.shards {
+		shard := &MS.shards[i]
+		shard.mu.RLock()
+		for key, entry := range shard.entries {
+			if strings.HasPrefix(key, prefix) && !entry.isExpired(now) {
+				keys = append(keys, key)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return keys
+}
+
+func (MS *MemoryTempStore) DelPrefix(prefix string) int {
+	now := time.Now().UnixNano()
+	deleted := 0
+	for i := range MS.shards {
+		shard := &MS.shards[i]
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if !entry.isExpired(now) {
+				deleted++
+			}
+			delete(shard.entries, key)
+		}
+		shard.mu.Unlock()
+	}
+	return deleted
+}
+
+func (MS *MemoryTempStore) Count(prefix string) int {
+	return len(MS.Scan(prefix))
+}
+
+// update hands change the live entry of key, or a new entry of kind
+// if key does not exist, and stores it, unless key holds another
+// kind of value. It reports whether it did
+func (MS *MemoryTempStore) update(key string, kind valueKind, change func(entry *tempEntry)) bool {
+	shard := MS.shardOf(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if !exists || entry.isExpired(time.Now().UnixNano()) {
+		entry = newTempEntry(kind)
+	}
+	if entry.kind() != kind {
+		return false
+	}
+
+	change(&entry)
+	if entry.isEmpty() {
+		delete(shard.entries, key)
+	} else {
+		shard.entries[key] = entry
+	}
+	return true
+}
+
+// read hands read the live entry of key, if it holds kind
+func (MS *MemoryTempStore) read(key string, kind valueKind, read func(entry tempEntry)) {
+	shard := MS.shardOf(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, exists := shard.entries[key]
+	if exists && !entry.isExpired(time.Now().UnixNano()) && entry.kind() == kind {
+		read(entry)
+	}
+}
+
+func (MS *MemoryTempStore) HSet(key string, fields map[string]string) bool {
+	return len(fields) > 0 &&
+		MS.update(key, hashKind, func(entry *tempEntry) { entry.hSet(fields) })
+}
+
+func (MS *MemoryTempStore) HGet(key string, field string) string {
+	value := ""
+	MS.read(key, hashKind, func(entry tempEntry) { value = entry.Hash[field] })
+	return value
+}
+
+func (MS *MemoryTempStore) HGetAll(key string) map[string]string {
+	fields := map[string]string{}
+	MS.read(key, hashKind, func(entry tempEntry) { maps.Copy(fields, entry.Hash) })
+	return fields
+}
+
+func (MS *MemoryTempStore) HDel(key string, fields ...string) int {
+	deleted := 0
+	MS.update(key, hashKind, func(entry *tempEntry) { deleted = entry.hDel(fields) })
+	return deleted
+}
+
+func (MS *MemoryTempStore) SAdd(key string, members ...string) int {
+	added := 0
+	MS.update(key, setKind, func(entry *tempEntry) { added = entry.sAdd(members) })
+	return added
+}
+
+func (MS *MemoryTempStore) SMembers(key string) []string {
+	members := []string{}
+	MS.read(key, setKind, func(entry tempEntry) { members = entry.sMembers() })
+	return members
+}
+
+func (MS *MemoryTempStore) SRem(key string, members ...string) int {
+	removed := 0
+	MS.update(key, setKind, func(entry *tempEntry) { removed = entry.sRem(members) })
+	return removed
+}
+
+func (MS *MemoryTempStore) LPush(key string, values ...string) int {
+	length := 0
+	MS.update(key, listKind, func(entry *tempEntry) { length = entry.lPush(values) })
+	return length
+}
+
+func (MS *MemoryTempStore) RPush(key string, values ...string) int {
+	length := 0
+	MS.update(key, listKind, func(entry *tempEntry) { length = entry.rPush(values) })
+	return length
+}
+
+func (MS *MemoryTempStore) LPop(key string) string {
+	value := ""
+	MS.update(key, listKind, func(entry *tempEntry) { value = entry.lPop() })
+	return value
+}
+
+func (MS *MemoryTempStore) RPop(key string) string {
+	value := ""
+	MS.update(key, listKind, func(entry *tempEntry) { value = entry.rPop() })
+	return value
+}
+
+func (MS *MemoryTempStore) LRange(key string, start, stop int) []string {
+	values := []string{}
+	MS.read(key, listKind, func(entry tempEntry) { values = entry.lRange(start, stop) })
+	return values
+}
+
+// Len returns the number of keys stored, expired ones the
+// sweeper did not erase yet included
+func (MS *MemoryTempStore) Len() int {
+	count := 0
+	for i := range MS.shards {
+		shard := &MS.shards[i]
+		shard.mu.RLock()
+		count += len(shard.entries)
+		shard.mu.RUnlock()
+	}
+	return count
+}
+
+// expiresAtOf returns the unix nanoseconds a key set to expire in
+// expiry seconds expires at
+func expiresAtOf(expiry float64) int64 {
+	return expiresAtIn(secondsToDuration(expiry))
+}
+
+// expiresAtIn returns the unix nanoseconds a key set to expire in
+// ttl expires at
+func expiresAtIn(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).UnixNano()
+}
+
+func (MS *MemoryTempStore) scheduleExpiry(key string, at int64) {
+	MS.expiryMu.Lock()
+	MS.pushExpiry(key, at)
+	isNext := MS.expiries[0].at == at
+	MS.expiryMu.Unlock()
+
+	if isNext {
+		select {
+		case MS.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pushExpiry moves the item of key to at, or pushes one if key has
+// none, so expiries never holds more items than there are keys.
+// expiryMu must be held
+func (MS *MemoryTempStore) pushExpiry(key string, at int64) {
+	if item, scheduled := MS.expiryOf[key]; scheduled {
+		item.at = at
+		heap.Fix(&MS.expiries, item.index)
+		return
+	}
+
+	item := &expiryItem{key: key, at: at}
+	heap.Push(&MS.expiries, item)
+	MS.expiryOf[key] = item
+}
+
+// sweep erases keys as they expire, until the store is closed
+func (MS *MemoryTempStore) sweep() {
+	defer MS.running.Done()
+
+	timer := time.NewTimer(MEMORY_TEMP_STORE_MAX_SWEEP_WAIT)
+	defer timer.Stop()
+
+	for {
+		MS.eraseExpired()
+
+		wait := MEMORY_TEMP_STORE_MAX_SWEEP_WAIT
+		MS.expiryMu.Lock()
+		if len(MS.expiries) > 0 {
+			wait = min(wait, time.Until(time.Unix(0, MS.expiries[0].at)))
+		}
+		MS.expiryMu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-MS.done:
+			return
+		case <-MS.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (MS *MemoryTempStore) eraseExpired() {
+	now := time.Now().UnixNano()
+	for {
+		MS.expiryMu.Lock()
+		if len(MS.expiries) == 0 || MS.expiries[0].at > now {
+			MS.expiryMu.Unlock()
+			return
+		}
+		item := heap.Pop(&MS.expiries).(*expiryItem)
+		delete(MS.expiryOf, item.key)
+		MS.expiryMu.Unlock()
+
+		shard := MS.shardOf(item.key)
+		shard.mu.Lock()
+		if entry, exists := shard.entries[item.key]; exists && entry.ExpiresAt == item.at {
+			delete(shard.entries, item.key)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+func (MS *MemoryTempStore) snapshotEvery(interval time.Duration) {
+	defer MS.running.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-MS.done:
+			return
+		case <-ticker.C:
+			if err := MS.Snapshot(); err != nil {
+				fmt.Fprintln(os.Stderr, "MemoryTempStore: failed to snapshot:", err.Error())
+			}
+		}
+	}
+}
+
+// Snapshot writes the keys that have not expired to the snapshot
+// file, replacing it at once so that a crash never leaves half a
+// snapshot. It does nothing if snapshots are not enabled
+func (MS *MemoryTempStore) Snapshot() error {
+	if MS.options.SnapshotPath == "" {
+		return nil
+	}
+
+	MS.snapshotMu.Lock()
+	defer MS.snapshotMu.Unlock()
+
+	now := time.Now().UnixNano()
+	entries := map[string]tempEntry{}
+	for i := range MS.shards {
+		shard := &MS.shards[i]
+		shard.mu.RLock()
+		for key, entry := range shard.entries {
+			if !entry.isExpired(now) {
+				entries[key] = entry
+			}
+		}
+		shard.mu.RUnlock()
+	}
+
+	jsonRep, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := MS.options.SnapshotPath + ".tmp"
+	if err = os.WriteFile(tmpPath, jsonRep, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, MS.options.SnapshotPath)
+}
+
+func (MS *MemoryTempStore) loadSnapshot() error {
+	content, err := os.ReadFile(MS.options.SnapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries map[string]tempEntry
+	if err = json.Unmarshal(content, &entries); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	for key, entry := range entries {
+		if entry.isExpired(now) {
+			continue
+		}
+		MS.shardOf(key).entries[key] = entry
+		if entry.ExpiresAt != 0 {
+			MS.pushExpiry(key, entry.ExpiresAt)
+		}
+	}
+	return nil
+}
+
+// Close stops the sweeper and the snapshots, then takes a last
+// snapshot. The store must not be used afterwards
+func (MS *MemoryTempStore) Close() error {
+	var err error
+	MS.closeOnce.Do(func() {
+		close(MS.done)
+		MS.running.Wait()
+		err = MS.Snapshot()
+	})
+	return err
+}
+
+var MEMORY_TEMP_STORE_MAP = map[string]*MemoryTempStore{}
+var memoryTempStoresMu sync.Mutex
+
+// MakeMemoryTempStore returns the MemoryTempStore of recordsName
+// of database, made on first use. If
+// config.MemoryTempStoreSnapshotInterval is set, it is snapshot to
+// a file named after database and recordsName
+func MakeMemoryTempStore(database, recordsName string) TempStore {
+	memoryTempStoresMu.Lock()
+	defer memoryTempStoresMu.Unlock()
+
+	key := database + recordsName
+	// implements singleton pattern
+	if MEMORY_TEMP_STORE_MAP[key] != nil {
+		return MEMORY_TEMP_STORE_MAP[key]
+	}
+
+	options := MemoryTempStoreOptions{}
+	if config.MemoryTempStoreSnapshotInterval > 0 {
+		options.SnapshotPath = memoryTempStoreSnapshotPath(database, recordsName)
+		options.SnapshotInterval = config.MemoryTempStoreSnapshotInterval
+	}
+
+	store := new(MemoryTempStore).New(options)
+	MEMORY_TEMP_STORE_MAP[key] = store
+	return store
+}
+
+func memoryTempStoreSnapshotPath(database, recordsName string) string {
+	return fmt.Sprintf("%s_%s.snapshot.json", database, recordsName)
+}
+
+// RemoveMemoryTempStoreSingleton closes the MemoryTempStore of
+// recordsName of database, and forgets it
+func RemoveMemoryTempStoreSingleton(database, recordsName string) {
+	memoryTempStoresMu.Lock()
+	defer memoryTempStoresMu.Unlock()
+
+	key := database + recordsName
+	if store, exists := MEMORY_TEMP_STORE_MAP[key]; exists {
+		store.Close()
+		delete(MEMORY_TEMP_STORE_MAP, key)
+	}
+}
+
+// CloseMemoryTempStores closes every MemoryTempStore made by
+// MakeMemoryTempStore, for a clean shutdown
+func CloseMemoryTempStores() {
+	memoryTempStoresMu.Lock()
+	defer memoryTempStoresMu.Unlock()
+
+	for key, store := range MEMORY_TEMP_STORE_MAP {
+		if err := store.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, "CloseMemoryTempStores:", err.Error())
+		}
+		delete(MEMORY_TEMP_STORE_MAP, key)
+	}
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// NamespacedTempStore is a view of a TempStore holding the keys of
+// its namespace only. Its keys are stored as namespace:key, so that
+// e.g. sessions and signup tokens sharing a store never collide, and
+// can be scanned or deleted apart. Namespaces nest
+type NamespacedTempStore struct {
+	store  TempStore
+	prefix string
+}
+
+func (NS *NamespacedTempStore) New(store TempStore, namespace string) TempStore {
+	NS.store = store
+	NS.prefix = namespace + ":"
+	return NS
+}
+
+// Store returns the TempStore NS is a view of
+func (NS *NamespacedTempStore) Store() TempStore {
+	return NS.store
+}
+
+func (NS *NamespacedTempStore) key(key string) string {
+	return NS.prefix + key
+}
+
+func (NS *NamespacedTempStore) GetVal(key string) string {
+	return NS.store.GetVal(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) SetKeyToVal(key string, value string) bool {
+	return NS.store.SetKeyToVal(NS.key(key), value)
+}
+
+func (NS *NamespacedTempStore) SetKeyToValWIthExpiry(key string, value string, expiry float64) bool {
+	return NS.store.SetKeyToValWIthExpiry(NS.key(key), value, expiry)
+}
+
+func (NS *NamespacedTempStore) ChangeKeyEpiry(key string, newExpiry float64) bool {
+	return NS.store.ChangeKeyEpiry(NS.key(key), newExpiry)
+}
+
+func (NS *NamespacedTempStore) DelKey(key string) bool {
+	return NS.store.DelKey(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) Incr(key string, expiry float64) (int64, error) {
+	return NS.store.Incr(NS.key(key), expiry)
+}
+
+func (NS *NamespacedTempStore) IncrBy(key string, by int64, expiry float64) (int64, error) {
+	return NS.store.IncrBy(NS.key(key), by, expiry)
+}
+
+func (NS *NamespacedTempStore) Decr(key string, expiry float64) (int64, error) {
+	return NS.store.Decr(NS.key(key), expiry)
+}
+
+func (NS *NamespacedTempStore) SetNX(key string, value string, expiry float64) bool {
+	return NS.store.SetNX(NS.key(key), value, expiry)
+}
+
+func (NS *NamespacedTempStore) GetAndDelete(key string) string {
+	return NS.store.GetAndDelete(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) CompareAndSwap(key string, oldValue string, newValue string) bool {
+	return NS.store.CompareAndSwap(NS.key(key), oldValue, newValue)
+}
+
+func (NS *NamespacedTempStore) CompareAndDelete(key string, value string) bool {
+	return NS.store.CompareAndDelete(NS.key(key), value)
+}
+
+func (NS *NamespacedTempStore) CompareAndExpire(key string, value string, ttl time.Duration) bool {
+	return NS.store.CompareAndExpire(NS.key(key), value, ttl)
+}
+
+func (NS *NamespacedTempStore) GetTTL(key string) float64 {
+	return NS.store.GetTTL(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) SetWithTTL(key string, value string, ttl time.Duration) bool {
+	return NS.store.SetWithTTL(NS.key(key), value, ttl)
+}
+
+func (NS *NamespacedTempStore) Expire(key string, ttl time.Duration) bool {
+	return NS.store.Expire(NS.key(key), ttl)
+}
+
+func (NS *NamespacedTempStore) Persist(key string) bool {
+	return NS.store.Persist(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) TTL(key string) time.Duration {
+	return NS.store.TTL(NS.key(key))
+}
+
+// Scan returns the keys of the namespace starting with prefix,
+// without the namespace
+func (NS *NamespacedTempStore) Scan(prefix string) []string {
+	keys := NS.store.Scan(NS.key(prefix))
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, NS.prefix)
+	}
+	return keys
+}
+
+func (NS *NamespacedTempStore) DelPrefix(prefix string) int {
+	return NS.store.DelPrefix(NS.key(prefix))
+}
+
+func (NS *NamespacedTempStore) Count(prefix string) int {
+	return NS.store.Count(NS.key(prefix))
+}
+
+func (NS *NamespacedTempStore) HSet(key string, fields map[string]string) bool {
+	return NS.store.HSet(NS.key(key), fields)
+}
+
+func (NS *NamespacedTempStore) HGet(key string, field string) string {
+	return NS.store.HGet(NS.key(key), field)
+}
+
+func (NS *NamespacedTempStore) HGetAll(key string) map[string]string {
+	return NS.store.HGetAll(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) HDel(key string, fields ...string) int {
+	return NS.store.HDel(NS.key(key), fields...)
+}
+
+func (NS *NamespacedTempStore) SAdd(key string, members ...string) int {
+	return NS.store.SAdd(NS.key(key), members...)
+}
+
+func (NS *NamespacedTempStore) SMembers(key string) []string {
+	return NS.store.SMembers(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) SRem(key string, members ...string) int {
+	return NS.store.SRem(NS.key(key), members...)
+}
+
+func (NS *NamespacedTempStore) LPush(key string, values ...string) int {
+	return NS.store.LPush(NS.key(key), values...)
+}
+
+func (NS *NamespacedTempStore) RPush(key string, values ...string) int {
+	return NS.store.RPush(NS.key(key), values...)
+}
+
+func (NS *NamespacedTempStore) LPop(key string) string {
+	return NS.store.LPop(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) RPop(key string) string {
+	return NS.store.RPop(NS.key(key))
+}
+
+func (NS *NamespacedTempStore) LRange(key string, start, stop int) []string {
+	return NS.store.LRange(NS.key(key), start, stop)
+}
+
+// MakeNamespacedTempStore returns the view of store holding the
+// keys of namespace
+func MakeNamespacedTempStore(store TempStore, namespace string) TempStore {
+	return new(NamespacedTempStore).New(store, namespace)
+}
+
+// AdoptLegacyKey moves key into namespaced if it is still kept as it
+// was before namespaced was used: un-prefixed in legacy, the store
+// namespaced is a view of. The key keeps the TTL it has left. It
+// returns the value moved, and whether key was moved. Keys holding a
+// ':' are never moved, as they could name a key of another namespace.
+// It lets the keys written by the previous release be read for one
+// more release
+func AdoptLegacyKey(ctx context.Context, namespaced, legacy TempStoreV2, key string) (string, bool, error) {
+	if key == "" || strings.Contains(key, ":") {
+		return "", false, nil
+	}
+
+	if _, found, err := namespaced.Get(ctx, key); err != nil || found {
+		return "", false, err
+	}
+
+	ttl, found, err := legacy.TTL(ctx, key)
+	if err != nil || !found {
+		return "", false, err
+	}
+	if ttl == TTL_NO_EXPIRY {
+		ttl = 0
+	}
+
+	value, claimed, err := legacy.GetAndDelete(ctx, key)
+	if err != nil || !claimed {
+		return "", false, err
+	}
+
+	// a key set in the namespace meanwhile is newer
+	set, err := namespaced.SetNX(ctx, key, value, ttl)
+	if err != nil || !set {
+		return "", false, err
+	}
+	return value, true, nil
+}
@@ -2,61 +2,522 @@ package storage
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/redis/go-redis/v9"
 )
 
-type RedisWrapper struct {
-	client *redis.Client
+// RedisStore is the TempStoreV2 of a redis
+type RedisStore struct {
+	client redis.UniversalClient
 }
 
-func (RW *RedisWrapper) New(db int) TempStore {
+// New connects to db of the redis set in config, see config.RedisUrl.
+// db, if not 0, overrides config.RedisDb and the db of the url. It
+// panics if the settings are invalid, e.g. a TLS file is unreadable
+func (RS *RedisStore) New(db int) *RedisStore {
+	options, err := redisOptions(db)
+	if err != nil {
+		panic("RedisStore: " + err.Error())
+	}
+
+	switch {
+	case len(config.RedisClusterAddrs) > 0:
+		RS.client = redis.NewClusterClient(options.Cluster())
+	case options.MasterName != "":
+		RS.client = redis.NewFailoverClient(options.Failover())
+	default:
+		RS.client = redis.NewClient(options.Simple())
+	}
+	return RS
+}
+
+// redisOptions returns the options of a client of db, read from
+// the redis settings of config
+func redisOptions(db int) (*redis.UniversalOptions, error) {
+	options := &redis.UniversalOptions{
+		Addrs:        []string{config.RedisUrl},
+		Username:     config.RedisUsername,
+		Password:     config.RedisPassword,
+		DB:           config.RedisDb,
+		PoolSize:     config.RedisPoolSize,
+		DialTimeout:  config.RedisDialTimeout,
+		ReadTimeout:  config.RedisReadTimeout,
+		WriteTimeout: config.RedisWriteTimeout,
+	}
+
+	if strings.HasPrefix(config.RedisUrl, "redis://") || strings.HasPrefix(config.RedisUrl, "rediss://") {
+		parsed, err := redis.ParseURL(config.RedisUrl)
+		if err != nil {
+			return nil, err
+		}
+		options.Addrs = []string{parsed.Addr}
+		options.TLSConfig = parsed.TLSConfig
+		if options.Username == "" {
+			options.Username = parsed.Username
+		}
+		if options.Password == "" {
+			options.Password = parsed.Password
+		}
+		if options.DB == 0 {
+			options.DB = parsed.DB
+		}
+	}
+
+	if db != 0 {
+		options.DB = db
+	}
+
+	switch {
+	case len(config.RedisClusterAddrs) > 0:
+		options.Addrs = config.RedisClusterAddrs
+	case config.RedisSentinelMaster != "":
+		options.Addrs = config.RedisSentinelAddrs
+		options.MasterName = config.RedisSentinelMaster
+		options.SentinelPassword = config.RedisSentinelPassword
+	}
+
+	tlsConfig, err := redisTLSConfig(options.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	options.TLSConfig = tlsConfig
+	return options, nil
+}
+
+// redisTLSConfig adds the TLS settings of config to tlsConfig, the
+// one of a rediss:// url, if any
+func redisTLSConfig(tlsConfig *tls.Config) (*tls.Config, error) {
+	if !config.RedisTLS && config.RedisTLSCertFile == "" && config.RedisTLSCAFile == "" {
+		return tlsConfig, nil
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if config.RedisTLSCertFile != "" || config.RedisTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.RedisTLSCertFile, config.RedisTLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.RedisTLSCAFile != "" {
+		caCerts, err := os.ReadFile(config.RedisTLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("no certificate found in %s", config.RedisTLSCAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	return tlsConfig, nil
+}
+
+// redisError wraps err, the failure of a command on key, in
+// ErrValidation if key holds a value the command does not apply to,
+// or else in ErrBackend
+func redisError(key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// errors raised in scripts are prefixed with the script's
+	// position before Redis 7
+	switch message := err.Error(); {
+	case strings.Contains(message, "WRONGTYPE"):
+		return wrongKindError(key)
+	case strings.Contains(message, "not an integer"):
+		return notACounterError(key)
+	}
+	return backendError(err)
+}
+
+func (RS *RedisStore) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := RS.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return value, err == nil, redisError(key, err)
+}
+
+// Set expires key at once if ttl is negative
+func (RS *RedisStore) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if ttl < 0 {
+		return redisError(key, RS.client.Del(ctx, key).Err())
+	}
+	return redisError(key, RS.client.Set(ctx, key, value, ttl).Err())
+}
+
+func (RS *RedisStore) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	set, err := RS.client.SetNX(ctx, key, value, ttl).Result()
+	return set, redisError(key, err)
+}
+
+func (RS *RedisStore) GetAndDelete(ctx context.Context, key string) (string, bool, error) {
+	value, err := RS.client.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return value, err == nil, redisError(key, err)
+}
+
+// sets KEYS[1] to ARGV[2] if it holds ARGV[1]
+var compareAndSwapScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+	return 1
+end
+return 0
+`)
+
+func (RS *RedisStore) CompareAndSwap(ctx context.Context, key string, oldValue string, newValue string) (bool, error) {
+	swapped, err := compareAndSwapScript.Run(ctx, RS.client, []string{key}, oldValue, newValue).Int()
+	return swapped == 1, redisError(key, err)
+}
+
+// deletes KEYS[1] if it holds ARGV[1]
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// expires KEYS[1] in ARGV[2] milliseconds if it holds ARGV[1]
+var compareAndExpireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+func (RS *RedisStore) CompareAndDelete(ctx context.Context, key string, value string) (bool, error) {
+	deleted, err := compareAndDeleteScript.Run(ctx, RS.client, []string{key}, value).Int()
+	return deleted == 1, redisError(key, err)
+}
+
+func (RS *RedisStore) CompareAndExpire(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	expired, err := compareAndExpireScript.Run(ctx, RS.client, []string{key}, value, ttl.Milliseconds()).Int()
+	return expired == 1, redisError(key, err)
+}
+
+func (RS *RedisStore) Delete(ctx context.Context, key string) (bool, error) {
+	deleted, err := RS.client.Del(ctx, key).Result()
+	return deleted > 0, redisError(key, err)
+}
+
+func (RS *RedisStore) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	existed, err := RS.client.PExpire(ctx, key, ttl).Result()
+	return existed, redisError(key, err)
+}
+
+func (RS *RedisStore) Persist(ctx context.Context, key string) (bool, error) {
+	persisted, err := RS.client.Persist(ctx, key).Result()
+	return persisted, redisError(key, err)
+}
+
+func (RS *RedisStore) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	ttl, err := RS.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, false, redisError(key, err)
+	}
+	// go-redis returns the -1 and -2 of PTTL as they are
+	switch ttl {
+	case -1:
+		return TTL_NO_EXPIRY, true, nil
+	case -2:
+		return 0, false, nil
+	}
+	return ttl, true, nil
+}
+
+// increments KEYS[1] by ARGV[1] and, if it has no expiry, expires
+// it in ARGV[2] milliseconds, as PEXPIRE NX does on Redis 7
+var incrByWithExpiryScript = redis.NewScript(`
+local count = redis.call("INCRBY", KEYS[1], ARGV[1])
+if redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return count
+`)
+
+// IncrBy increments and expires the counter in one script, so
+// that it never lives without its expiry
+func (RS *RedisStore) IncrBy(ctx context.Context, key string, by int64, ttl time.Duration) (int64, error) {
+	if ttl <= 0 {
+		count, err := RS.client.IncrBy(ctx, key, by).Result()
+		return count, redisError(key, err)
+	}
+
+	count, err := incrByWithExpiryScript.Run(ctx, RS.client, []string{key}, by, ttl.Milliseconds()).Int64()
+	return count, redisError(key, err)
+}
+
+// SCAN_BATCH is the number of keys Scan and DelPrefix ask
+// SCAN, and DelPrefix deletes, at a time
+const SCAN_BATCH = 500
+
+// Scan scans every master of a cluster, as each holds some keys
+func (RS *RedisStore) Scan(ctx context.Context, prefix string) ([]string, error) {
+	match := escapeGlob(prefix) + "*"
+	cluster, isCluster := RS.client.(*redis.ClusterClient)
+	if !isCluster {
+		return scanKeys(ctx, RS.client, match)
+	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr:     config.RedisUrl,
-		Password: config.RedisPassword,
-		DB:       db,
+	var mu sync.Mutex
+	keys := []string{}
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		masterKeys, err := scanKeys(ctx, master, match)
+		mu.Lock()
+		keys = append(keys, masterKeys...)
+		mu.Unlock()
+		return err
 	})
+	return keys, err
+}
 
-	RW.client = client
-	return RW
+func scanKeys(ctx context.Context, client redis.Cmdable, match string) ([]string, error) {
+	keys := []string{}
+	iter := client.Scan(ctx, 0, match, SCAN_BATCH).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, backendError(iter.Err())
+}
+
+// DelPrefix unlinks the keys one by one, in pipelines, as a
+// cluster cannot unlink keys of different slots at once
+func (RS *RedisStore) DelPrefix(ctx context.Context, prefix string) (int, error) {
+	keys, err := RS.Scan(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for start := 0; start < len(keys); start += SCAN_BATCH {
+		end := min(start+SCAN_BATCH, len(keys))
+		cmds, err := RS.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys[start:end] {
+				pipe.Unlink(ctx, key)
+			}
+			return nil
+		})
+		for _, cmd := range cmds {
+			deleted += int(cmd.(*redis.IntCmd).Val())
+		}
+		if err != nil {
+			return deleted, backendError(err)
+		}
+	}
+	return deleted, nil
 }
 
-func (RW *RedisWrapper) GetVal(key string) string {
-	return RW.client.Get(context.Background(), key).Val()
+// escapeGlob escapes the characters SCAN's MATCH reads as patterns
+func escapeGlob(prefix string) string {
+	return globEscaper.Replace(prefix)
 }
 
-func (RW *RedisWrapper) SetKeyToVal(key string, value string) bool {
-	return RW.client.Set(context.Background(), key, value, 0).Err() == nil
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (RS *RedisStore) HSet(ctx context.Context, key string, fields map[string]string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return redisError(key, RS.client.HSet(ctx, key, fields).Err())
 }
 
-// expiry is in seconds
-func (RW *RedisWrapper) setKeyToExpiry(key string, expiry float64) bool {
-	return RW.client.Expire(context.Background(), key, time.Duration(expiry)*time.Second).Err() == nil
+func (RS *RedisStore) HGet(ctx context.Context, key string, field string) (string, bool, error) {
+	value, err := RS.client.HGet(ctx, key, field).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return value, err == nil, redisError(key, err)
 }
 
-func (RW *RedisWrapper) SetKeyToValWIthExpiry(key string, value string, expiry float64) bool {
-	return RW.client.SetEx(context.Background(), key, value, time.Duration(expiry)*time.Second).Err() == nil
+func (RS *RedisStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	fields, err := RS.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return map[string]string{}, redisError(key, err)
+	}
+	return fields, nil
 }
 
-func (RW *RedisWrapper) ChangeKeyEpiry(key string, newExpiry float64) bool {
-	RW.setKeyToExpiry(key, newExpiry)
-	return true
+func (RS *RedisStore) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	deleted, err := RS.client.HDel(ctx, key, fields...).Result()
+	return int(deleted), redisError(key, err)
 }
 
-func (RW *RedisWrapper) DelKey(key string) bool {
-	return RW.client.Del(context.Background(), key).Err() == nil
+func (RS *RedisStore) SAdd(ctx context.Context, key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	added, err := RS.client.SAdd(ctx, key, members).Result()
+	return int(added), redisError(key, err)
 }
 
-func (RW *RedisWrapper) FlushDB() {
-	err := RW.client.FlushDB(context.Background()).Err()
+func (RS *RedisStore) SMembers(ctx context.Context, key string) ([]string, error) {
+	members, err := RS.client.SMembers(ctx, key).Result()
+	if err != nil {
+		return []string{}, redisError(key, err)
+	}
+	return members, nil
+}
+
+func (RS *RedisStore) SRem(ctx context.Context, key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	removed, err := RS.client.SRem(ctx, key, members).Result()
+	return int(removed), redisError(key, err)
+}
+
+func (RS *RedisStore) LPush(ctx context.Context, key string, values ...string) (int, error) {
+	if len(values) == 0 {
+		length, err := RS.client.LLen(ctx, key).Result()
+		return int(length), redisError(key, err)
+	}
+	length, err := RS.client.LPush(ctx, key, values).Result()
+	return int(length), redisError(key, err)
+}
+
+func (RS *RedisStore) RPush(ctx context.Context, key string, values ...string) (int, error) {
+	if len(values) == 0 {
+		length, err := RS.client.LLen(ctx, key).Result()
+		return int(length), redisError(key, err)
+	}
+	length, err := RS.client.RPush(ctx, key, values).Result()
+	return int(length), redisError(key, err)
+}
+
+func (RS *RedisStore) LPop(ctx context.Context, key string) (string, bool, error) {
+	value, err := RS.client.LPop(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return value, err == nil, redisError(key, err)
+}
+
+func (RS *RedisStore) RPop(ctx context.Context, key string) (string, bool, error) {
+	value, err := RS.client.RPop(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return value, err == nil, redisError(key, err)
+}
+
+func (RS *RedisStore) LRange(ctx context.Context, key string, start, stop int) ([]string, error) {
+	values, err := RS.client.LRange(ctx, key, int64(start), int64(stop)).Result()
+	if err != nil {
+		return []string{}, redisError(key, err)
+	}
+	return values, nil
+}
+
+// secondsToDuration converts an expiry in seconds, 0 for none
+func secondsToDuration(expiry float64) time.Duration {
+	return time.Duration(expiry * float64(time.Second))
+}
+
+func (RS *RedisStore) FlushDB() {
+	var err error
+	if cluster, isCluster := RS.client.(*redis.ClusterClient); isCluster {
+		err = cluster.ForEachMaster(context.Background(), func(ctx context.Context, master *redis.Client) error {
+			return master.FlushDB(ctx).Err()
+		})
+	} else {
+		err = RS.client.FlushDB(context.Background()).Err()
+	}
 	if err != nil {
 		panic(err)
 	}
 }
 
+// Client returns the go-redis client, for the commands the
+// TempStoreV2 does not offer
+func (RS *RedisStore) Client() redis.UniversalClient {
+	return RS.client
+}
+
+// Close closes the connections of the client
+func (RS *RedisStore) Close() error {
+	return RS.client.Close()
+}
+
+// RedisWrapper is the TempStore of a RedisStore, see LegacyTempStore.
+// Use TempStoreV2Of for the failures of redis
+type RedisWrapper struct {
+	LegacyTempStore
+	redis *RedisStore
+}
+
+// New connects to db, see RedisStore.New
+func (RW *RedisWrapper) New(db int) TempStore {
+	RW.redis = new(RedisStore).New(db)
+	RW.LegacyTempStore.New(RW.redis)
+	return RW
+}
+
+func (RW *RedisWrapper) FlushDB() {
+	RW.redis.FlushDB()
+}
+
+func (RW *RedisWrapper) Client() redis.UniversalClient {
+	return RW.redis.Client()
+}
+
+func (RW *RedisWrapper) Close() error {
+	return RW.redis.Close()
+}
+
+var REDIS_WRAPPER_MAP = map[int]*RedisWrapper{}
+var redisWrappersMu sync.Mutex
+
+// MakeRedisWrapper returns the RedisWrapper of db, see New, made on
+// first use, so that the stores of a db share its connections
 func MakeRedisWrapper(db int) TempStore {
-	return new(RedisWrapper).New(db)
+	redisWrappersMu.Lock()
+	defer redisWrappersMu.Unlock()
+
+	// implements singleton pattern
+	if REDIS_WRAPPER_MAP[db] != nil {
+		return REDIS_WRAPPER_MAP[db]
+	}
+
+	wrapper := new(RedisWrapper)
+	wrapper.New(db)
+	REDIS_WRAPPER_MAP[db] = wrapper
+	return wrapper
+}
+
+// RemoveRedisWrapperSingleton closes the RedisWrapper of db, and
+// forgets it
+func RemoveRedisWrapperSingleton(db int) {
+	redisWrappersMu.Lock()
+	defer redisWrappersMu.Unlock()
+
+	if wrapper, exists := REDIS_WRAPPER_MAP[db]; exists {
+		wrapper.Close()
+		delete(REDIS_WRAPPER_MAP, db)
+	}
+}
+
+// MakeRedisStore returns the RedisStore of db, the one of the
+// RedisWrapper of db, see MakeRedisWrapper
+func MakeRedisStore(db int) TempStoreV2 {
+	return MakeRedisWrapper(db).(*RedisWrapper).redis
 }
@@ -0,0 +1,580 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// TempStoreV2 is a TempStore whose methods take a context and report
+// failures, so that a missing key is told from a store that failed,
+// e.g. an unreachable redis. Errors wrap ErrBackend if the store
+// failed or ctx ended, and ErrValidation if the key holds a value of
+// another kind, or, for IncrBy, something other than an integer.
+// Reads report whether the key, field or value was found. A ttl of 0
+// sets no expiry. See TempStore for what the methods do
+type TempStoreV2 interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	GetAndDelete(ctx context.Context, key string) (string, bool, error)
+	CompareAndSwap(ctx context.Context, key string, oldValue string, newValue string) (bool, error)
+	CompareAndDelete(ctx context.Context, key string, value string) (bool, error)
+	CompareAndExpire(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// Delete and Expire report whether key existed, Persist
+	// whether it removed an expiry of key
+	Delete(ctx context.Context, key string) (bool, error)
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Persist(ctx context.Context, key string) (bool, error)
+	// TTL returns TTL_NO_EXPIRY if key does not expire
+	TTL(ctx context.Context, key string) (time.Duration, bool, error)
+	IncrBy(ctx context.Context, key string, by int64, ttl time.Duration) (int64, error)
+
+	Scan(ctx context.Context, prefix string) ([]string, error)
+	DelPrefix(ctx context.Context, prefix string) (int, error)
+
+	HSet(ctx context.Context, key string, fields map[string]string) error
+	HGet(ctx context.Context, key string, field string) (string, bool, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	HDel(ctx context.Context, key string, fields ...string) (int, error)
+
+	SAdd(ctx context.Context, key string, members ...string) (int, error)
+	SMembers(ctx context.Context, key string) ([]string, error)
+	SRem(ctx context.Context, key string, members ...string) (int, error)
+
+	LPush(ctx context.Context, key string, values ...string) (int, error)
+	RPush(ctx context.Context, key string, values ...string) (int, error)
+	LPop(ctx context.Context, key string) (string, bool, error)
+	RPop(ctx context.Context, key string) (string, bool, error)
+	LRange(ctx context.Context, key string, start, stop int) ([]string, error)
+}
+
+var errTempStoreWrite = errors.New("temp store failed to write")
+
+// TempStoreV2Of returns store as a TempStoreV2. Redis stores, and
+// namespaces of them, report the failures of redis. Others, which
+// keep their keys in the process, only fail if ctx ends
+func TempStoreV2Of(store TempStore) TempStoreV2 {
+	switch store := store.(type) {
+	case *RedisWrapper:
+		return store.redis
+	case *LegacyTempStore:
+		return store.store
+	case *NamespacedTempStore:
+		return &namespacedTempStoreV2{store: TempStoreV2Of(store.store), prefix: store.prefix}
+	}
+	return &tempStoreV2Adapter{store: store}
+}
+
+// tempStoreV2Adapter is a TempStoreV2 of a TempStore that cannot
+// fail. A key of another kind is read and written as TempStore does,
+// nothing being read or written. A list value of "" is not told from
+// an empty list by the pops
+type tempStoreV2Adapter struct {
+	store TempStore
+}
+
+func (TA *tempStoreV2Adapter) Get(ctx context.Context, key string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, backendError(err)
+	}
+	value := TA.store.GetVal(key)
+	return value, value != "" || TA.store.GetTTL(key) != TTL_NO_KEY, nil
+}
+
+func (TA *tempStoreV2Adapter) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return backendError(err)
+	}
+	if !TA.store.SetWithTTL(key, value, ttl) {
+		return backendError(errTempStoreWrite)
+	}
+	return nil
+}
+
+func (TA *tempStoreV2Adapter) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, backendError(err)
+	}
+	return TA.store.SetNX(key, value, ttl.Seconds()), nil
+}
+
+func (TA *tempStoreV2Adapter) GetAndDelete(ctx context.Context, key string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, backendError(err)
+	}
+	value := TA.store.GetAndDelete(key)
+	return value, value != "", nil
+}
+
+func (TA *tempStoreV2Adapter) CompareAndSwap(ctx context.Context, key string, oldValue string, newValue string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, backendError(err)
+	}
+	return TA.store.CompareAndSwap(key, oldValue, newValue), nil
+}
+
+func (TA *tempStoreV2Adapter) CompareAndDelete(ctx context.Context, key string, value string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, backendError(err)
+	}
+	return TA.store.CompareAndDelete(key, value), nil
+}
+
+func (TA *tempStoreV2Adapter) CompareAndExpire(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, backendError(err)
+	}
+	return TA.store.CompareAndExpire(key, value, ttl), nil
+}
+
+func (TA *tempStoreV2Adapter) Delete(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, backendError(err)
+	}
+	existed := TA.store.GetTTL(key) != TTL_NO_KEY
+	TA.store.DelKey(key)
+	return existed, nil
+}
+
+func (TA *tempStoreV2Adapter) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, backendError(err)
+	}
+	return TA.store.Expire(key, ttl), nil
+}
+
+func (TA *tempStoreV2Adapter) Persist(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, backendError(err)
+	}
+	return TA.store.Persist(key), nil
+}
+
+func (TA *tempStoreV2Adapter) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, backendError(err)
+	}
+
+	ttl := TA.store.TTL(key)
+	if ttl == TTL_NO_KEY {
+		return 0, false, nil
+	}
+	return ttl, true, nil
+}
+
+func (TA *tempStoreV2Adapter) IncrBy(ctx context.Context, key string, by int64, ttl time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, backendError(err)
+	}
+	return TA.store.IncrBy(key, by, ttl.Seconds())
+}
+
+func (TA *tempStoreV2Adapter) Scan(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, backendError(err)
+	}
+	return TA.store.Scan(prefix), nil
+}
+
+func (TA *tempStoreV2Adapter) DelPrefix(ctx context.Context, prefix string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, backendError(err)
+	}
+	return TA.store.DelPrefix(prefix), nil
+}
+
+func (TA *tempStoreV2Adapter) HSet(ctx context.Context, key string, fields map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return backendError(err)
+	}
+	TA.store.HSet(key, fields)
+	return nil
+}
+
+func (TA *tempStoreV2Adapter) HGet(ctx context.Context, key string, field string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, backendError(err)
+	}
+	value, found := TA.store.HGetAll(key)[field]
+	return value, found, nil
+}
+
+func (TA *tempStoreV2Adapter) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, backendError(err)
+	}
+	return TA.store.HGetAll(key), nil
+}
+
+func (TA *tempStoreV2Adapter) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, backendError(err)
+	}
+	return TA.store.HDel(key, fields...), nil
+}
+
+func (TA *tempStoreV2Adapter) SAdd(ctx context.Context, key string, members ...string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, backendError(err)
+	}
+	return TA.store.SAdd(key, members...), nil
+}
+
+func (TA *tempStoreV2Adapter) SMembers(ctx context.Context, key string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, backendError(err)
+	}
+	return TA.store.SMembers(key), nil
+}
+
+func (TA *tempStoreV2Adapter) SRem(ctx context.Context, key string, members ...string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, backendError(err)
+	}
+	return TA.store.SRem(key, members...), nil
+}
+
+func (TA *tempStoreV2Adapter) LPush(ctx context.Context, key string, values ...string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, backendError(err)
+	}
+	return TA.store.LPush(key, values...), nil
+}
+
+func (TA *tempStoreV2Adapter) RPush(ctx context.Context, key string, values ...string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, backendError(err)
+	}
+	return TA.store.RPush(key, values...), nil
+}
+
+func (TA *tempStoreV2Adapter) LPop(ctx context.Context, key string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, backendError(err)
+	}
+	value := TA.store.LPop(key)
+	return value, value != "", nil
+}
+
+func (TA *tempStoreV2Adapter) RPop(ctx context.Context, key string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, backendError(err)
+	}
+	value := TA.store.RPop(key)
+	return value, value != "", nil
+}
+
+func (TA *tempStoreV2Adapter) LRange(ctx context.Context, key string, start, stop int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, backendError(err)
+	}
+	return TA.store.LRange(key, start, stop), nil
+}
+
+// LegacyTempStore is the TempStore of a TempStoreV2, for the code
+// written against TempStore. Its methods run without a deadline and
+// read a failure of the store as a missing key, or a failed write
+type LegacyTempStore struct {
+	store TempStoreV2
+}
+
+func (LS *LegacyTempStore) New(store TempStoreV2) TempStore {
+	LS.store = store
+	return LS
+}
+
+func (LS *LegacyTempStore) GetVal(key string) string {
+	value, _, _ := LS.store.Get(context.Background(), key)
+	return value
+}
+
+func (LS *LegacyTempStore) SetKeyToVal(key string, value string) bool {
+	return LS.store.Set(context.Background(), key, value, 0) == nil
+}
+
+func (LS *LegacyTempStore) SetKeyToValWIthExpiry(key string, value string, expiry float64) bool {
+	return LS.store.Set(context.Background(), key, value, secondsToDuration(expiry)) == nil
+}
+
+func (LS *LegacyTempStore) ChangeKeyEpiry(key string, newExpiry float64) bool {
+	existed, err := LS.store.Expire(context.Background(), key, secondsToDuration(newExpiry))
+	return existed && err == nil
+}
+
+func (LS *LegacyTempStore) SetWithTTL(key string, value string, ttl time.Duration) bool {
+	return LS.store.Set(context.Background(), key, value, ttl) == nil
+}
+
+func (LS *LegacyTempStore) Expire(key string, ttl time.Duration) bool {
+	existed, err := LS.store.Expire(context.Background(), key, ttl)
+	return existed && err == nil
+}
+
+func (LS *LegacyTempStore) Persist(key string) bool {
+	persisted, err := LS.store.Persist(context.Background(), key)
+	return persisted && err == nil
+}
+
+func (LS *LegacyTempStore) DelKey(key string) bool {
+	_, err := LS.store.Delete(context.Background(), key)
+	return err == nil
+}
+
+func (LS *LegacyTempStore) Incr(key string, expiry float64) (int64, error) {
+	return LS.IncrBy(key, 1, expiry)
+}
+
+func (LS *LegacyTempStore) IncrBy(key string, by int64, expiry float64) (int64, error) {
+	return LS.store.IncrBy(context.Background(), key, by, secondsToDuration(expiry))
+}
+
+func (LS *LegacyTempStore) Decr(key string, expiry float64) (int64, error) {
+	return LS.IncrBy(key, -1, expiry)
+}
+
+func (LS *LegacyTempStore) SetNX(key string, value string, expiry float64) bool {
+	set, err := LS.store.SetNX(context.Background(), key, value, secondsToDuration(expiry))
+	return set && err == nil
+}
+
+func (LS *LegacyTempStore) GetAndDelete(key string) string {
+	value, _, _ := LS.store.GetAndDelete(context.Background(), key)
+	return value
+}
+
+func (LS *LegacyTempStore) CompareAndSwap(key string, oldValue string, newValue string) bool {
+	swapped, err := LS.store.CompareAndSwap(context.Background(), key, oldValue, newValue)
+	return swapped && err == nil
+}
+
+func (LS *LegacyTempStore) CompareAndDelete(key string, value string) bool {
+	deleted, err := LS.store.CompareAndDelete(context.Background(), key, value)
+	return deleted && err == nil
+}
+
+func (LS *LegacyTempStore) CompareAndExpire(key string, value string, ttl time.Duration) bool {
+	expired, err := LS.store.CompareAndExpire(context.Background(), key, value, ttl)
+	return expired && err == nil
+}
+
+func (LS *LegacyTempStore) GetTTL(key string) float64 {
+	return ttlSeconds(LS.TTL(key))
+}
+
+func (LS *LegacyTempStore) TTL(key string) time.Duration {
+	ttl, found, err := LS.store.TTL(context.Background(), key)
+	if err != nil || !found {
+		return TTL_NO_KEY
+	}
+	return ttl
+}
+
+func (LS *LegacyTempStore) Scan(prefix string) []string {
+	keys, err := LS.store.Scan(context.Background(), prefix)
+	if err != nil {
+		return []string{}
+	}
+	return keys
+}
+
+func (LS *LegacyTempStore) DelPrefix(prefix string) int {
+	deleted, _ := LS.store.DelPrefix(context.Background(), prefix)
+	return deleted
+}
+
+func (LS *LegacyTempStore) Count(prefix string) int {
+	return len(LS.Scan(prefix))
+}
+
+func (LS *LegacyTempStore) HSet(key string, fields map[string]string) bool {
+	return len(fields) > 0 && LS.store.HSet(context.Background(), key, fields) == nil
+}
+
+func (LS *LegacyTempStore) HGet(key string, field string) string {
+	value, _, _ := LS.store.HGet(context.Background(), key, field)
+	return value
+}
+
+func (LS *LegacyTempStore) HGetAll(key string) map[string]string {
+	fields, err := LS.store.HGetAll(context.Background(), key)
+	if err != nil {
+		return map[string]string{}
+	}
+	return fields
+}
+
+func (LS *LegacyTempStore) HDel(key string, fields ...string) int {
+	deleted, _ := LS.store.HDel(context.Background(), key, fields...)
+	return deleted
+}
+
+func (LS *LegacyTempStore) SAdd(key string, members ...string) int {
+	added, _ := LS.store.SAdd(context.Background(), key, members...)
+	return added
+}
+
+func (LS *LegacyTempStore) SMembers(key string) []string {
+	members, err := LS.store.SMembers(context.Background(), key)
+	if err != nil {
+		return []string{}
+	}
+	return members
+}
+
+func (LS *LegacyTempStore) SRem(key string, members ...string) int {
+	removed, _ := LS.store.SRem(context.Background(), key, members...)
+	return removed
+}
+
+func (LS *LegacyTempStore) LPush(key string, values ...string) int {
+	length, _ := LS.store.LPush(context.Background(), key, values...)
+	return length
+}
+
+func (LS *LegacyTempStore) RPush(key string, values ...string) int {
+	length, _ := LS.store.RPush(context.Background(), key, values...)
+	return length
+}
+
+func (LS *LegacyTempStore) LPop(key string) string {
+	value, _, _ := LS.store.LPop(context.Background(), key)
+	return value
+}
+
+func (LS *LegacyTempStore) RPop(key string) string {
+	value, _, _ := LS.store.RPop(context.Background(), key)
+	return value
+}
+
+func (LS *LegacyTempStore) LRange(key string, start, stop int) []string {
+	values, err := LS.store.LRange(context.Background(), key, start, stop)
+	if err != nil {
+		return []string{}
+	}
+	return values
+}
+
+// MakeLegacyTempStore returns the TempStore of store
+func MakeLegacyTempStore(store TempStoreV2) TempStore {
+	return new(LegacyTempStore).New(store)
+}
+
+// namespacedTempStoreV2 is the TempStoreV2 of a NamespacedTempStore
+type namespacedTempStoreV2 struct {
+	store  TempStoreV2
+	prefix string
+}
+
+func (NS *namespacedTempStoreV2) key(key string) string {
+	return NS.prefix + key
+}
+
+func (NS *namespacedTempStoreV2) Get(ctx context.Context, key string) (string, bool, error) {
+	return NS.store.Get(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return NS.store.Set(ctx, NS.key(key), value, ttl)
+}
+
+func (NS *namespacedTempStoreV2) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	return NS.store.SetNX(ctx, NS.key(key), value, ttl)
+}
+
+func (NS *namespacedTempStoreV2) GetAndDelete(ctx context.Context, key string) (string, bool, error) {
+	return NS.store.GetAndDelete(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) CompareAndSwap(ctx context.Context, key string, oldValue string, newValue string) (bool, error) {
+	return NS.store.CompareAndSwap(ctx, NS.key(key), oldValue, newValue)
+}
+
+func (NS *namespacedTempStoreV2) CompareAndDelete(ctx context.Context, key string, value string) (bool, error) {
+	return NS.store.CompareAndDelete(ctx, NS.key(key), value)
+}
+
+func (NS *namespacedTempStoreV2) CompareAndExpire(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	return NS.store.CompareAndExpire(ctx, NS.key(key), value, ttl)
+}
+
+func (NS *namespacedTempStoreV2) Delete(ctx context.Context, key string) (bool, error) {
+	return NS.store.Delete(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return NS.store.Expire(ctx, NS.key(key), ttl)
+}
+
+func (NS *namespacedTempStoreV2) Persist(ctx context.Context, key string) (bool, error) {
+	return NS.store.Persist(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	return NS.store.TTL(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) IncrBy(ctx context.Context, key string, by int64, ttl time.Duration) (int64, error) {
+	return NS.store.IncrBy(ctx, NS.key(key), by, ttl)
+}
+
+func (NS *namespacedTempStoreV2) Scan(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := NS.store.Scan(ctx, NS.key(prefix))
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, NS.prefix)
+	}
+	return keys, err
+}
+
+func (NS *namespacedTempStoreV2) DelPrefix(ctx context.Context, prefix string) (int, error) {
+	return NS.store.DelPrefix(ctx, NS.key(prefix))
+}
+
+func (NS *namespacedTempStoreV2) HSet(ctx context.Context, key string, fields map[string]string) error {
+	return NS.store.HSet(ctx, NS.key(key), fields)
+}
+
+func (NS *namespacedTempStoreV2) HGet(ctx context.Context, key string, field string) (string, bool, error) {
+	return NS.store.HGet(ctx, NS.key(key), field)
+}
+
+func (NS *namespacedTempStoreV2) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return NS.store.HGetAll(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	return NS.store.HDel(ctx, NS.key(key), fields...)
+}
+
+func (NS *namespacedTempStoreV2) SAdd(ctx context.Context, key string, members ...string) (int, error) {
+	return NS.store.SAdd(ctx, NS.key(key), members...)
+}
+
+func (NS *namespacedTempStoreV2) SMembers(ctx context.Context, key string) ([]string, error) {
+	return NS.store.SMembers(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) SRem(ctx context.Context, key string, members ...string) (int, error) {
+	return NS.store.SRem(ctx, NS.key(key), members...)
+}
+
+func (NS *namespacedTempStoreV2) LPush(ctx context.Context, key string, values ...string) (int, error) {
+	return NS.store.LPush(ctx, NS.key(key), values...)
+}
+
+func (NS *namespacedTempStoreV2) RPush(ctx context.Context, key string, values ...string) (int, error) {
+	return NS.store.RPush(ctx, NS.key(key), values...)
+}
+
+func (NS *namespacedTempStoreV2) LPop(ctx context.Context, key string) (string, bool, error) {
+	return NS.store.LPop(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) RPop(ctx context.Context, key string) (string, bool, error) {
+	return NS.store.RPop(ctx, NS.key(key))
+}
+
+func (NS *namespacedTempStoreV2) LRange(ctx context.Context, key string, start, stop int) ([]string, error) {
+	return NS.store.LRange(ctx, NS.key(key), start, stop)
+}
@@ -0,0 +1,164 @@
+package storage
+
+import "slices"
+
+// the kinds of value a TempStore key holds
+type valueKind int
+
+const (
+	stringKind valueKind = iota
+	hashKind
+	setKind
+	listKind
+)
+
+// tempEntry is the value of a key of the memory and file temp
+// stores, one of a string, hash, set or list, and, if not 0, the
+// unix nanoseconds it expires at
+type tempEntry struct {
+	Value     string              `json:"value,omitempty"`
+	Hash      map[string]string   `json:"hash,omitempty"`
+	Set       map[string]struct{} `json:"set,omitempty"`
+	List      []string            `json:"list,omitempty"`
+	ExpiresAt int64               `json:"expiresAt,omitempty"`
+}
+
+// newTempEntry returns an empty entry of kind
+func newTempEntry(kind valueKind) tempEntry {
+	switch kind {
+	case hashKind:
+		return tempEntry{Hash: map[string]string{}}
+	case setKind:
+		return tempEntry{Set: map[string]struct{}{}}
+	case listKind:
+		return tempEntry{List: []string{}}
+	}
+	return tempEntry{}
+}
+
+func (entry tempEntry) isExpired(now int64) bool {
+	return entry.ExpiresAt != 0 && entry.ExpiresAt <= now
+}
+
+func (entry tempEntry) kind() valueKind {
+	switch {
+	case entry.Hash != nil:
+		return hashKind
+	case entry.Set != nil:
+		return setKind
+	case entry.List != nil:
+		return listKind
+	}
+	return stringKind
+}
+
+// isEmpty reports whether entry is a hash, set or list left empty,
+// which is deleted, as in redis
+func (entry tempEntry) isEmpty() bool {
+	switch entry.kind() {
+	case hashKind:
+		return len(entry.Hash) == 0
+	case setKind:
+		return len(entry.Set) == 0
+	case listKind:
+		return len(entry.List) == 0
+	}
+	return false
+}
+
+func (entry *tempEntry) hSet(fields map[string]string) {
+	for field, value := range fields {
+		entry.Hash[field] = value
+	}
+}
+
+func (entry *tempEntry) hDel(fields []string) int {
+	deleted := 0
+	for _, field := range fields {
+		if _, exists := entry.Hash[field]; exists {
+			delete(entry.Hash, field)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (entry *tempEntry) sAdd(members []string) int {
+	added := 0
+	for _, member := range members {
+		if _, exists := entry.Set[member]; !exists {
+			entry.Set[member] = struct{}{}
+			added++
+		}
+	}
+	return added
+}
+
+func (entry *tempEntry) sRem(members []string) int {
+	removed := 0
+	for _, member := range members {
+		if _, exists := entry.Set[member]; exists {
+			delete(entry.Set, member)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (entry *tempEntry) sMembers() []string {
+	members := make([]string, 0, len(entry.Set))
+	for member := range entry.Set {
+		members = append(members, member)
+	}
+	return members
+}
+
+// lPush pushes values to the head of the list one after the
+// other, so that the last ends up first
+func (entry *tempEntry) lPush(values []string) int {
+	pushed := slices.Clone(values)
+	slices.Reverse(pushed)
+	entry.List = append(pushed, entry.List...)
+	return len(entry.List)
+}
+
+func (entry *tempEntry) rPush(values []string) int {
+	entry.List = append(entry.List, values...)
+	return len(entry.List)
+}
+
+func (entry *tempEntry) lPop() string {
+	if len(entry.List) == 0 {
+		return ""
+	}
+	value := entry.List[0]
+	entry.List = entry.List[1:]
+	return value
+}
+
+func (entry *tempEntry) rPop() string {
+	if len(entry.List) == 0 {
+		return ""
+	}
+	value := entry.List[len(entry.List)-1]
+	entry.List = entry.List[:len(entry.List)-1]
+	return value
+}
+
+// lRange returns the values from start to stop, included, as
+// LRANGE does
+func (entry *tempEntry) lRange(start, stop int) []string {
+	length := len(entry.List)
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	stop = min(stop, length-1)
+
+	if start > stop {
+		return []string{}
+	}
+	return slices.Clone(entry.List[start : stop+1])
+}
@@ -3,7 +3,12 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/Iyusuf40/goBackendUtils/models"
@@ -11,9 +16,18 @@ import (
 )
 
 type UserStorage struct {
-	DB DB_Engine
+	DB      DB_Engine
+	hooks   *Hooks[models.User]
+	tenants *tenantStorages[models.User]
 }
 
+// the fields of users searched by default, and the
+// only ones that can be searched
+var userSearchFields = []string{"email", "firstName", "lastName"}
+
+// the fields of users left out of exports by default
+var userSensitiveFields = []string{"password"}
+
 var userSchema = []SQL_TABLE_COLUMN_FIELD_AND_DESC{
 	{`email`, "VARCHAR(128)"},
 	{`firstName`, "VARCHAR(128)"},
@@ -21,69 +35,218 @@ var userSchema = []SQL_TABLE_COLUMN_FIELD_AND_DESC{
 	{`phone`, "integer"},
 	{`password`, "VARCHAR(128)"}}
 
+func (us *UserStorage) Hooks() *Hooks[models.User] {
+	return us.hooks
+}
+
+func (us *UserStorage) ForTenant(tenant string) (Storage[models.User], error) {
+	return us.tenants.get(tenant)
+}
+
+func (us *UserStorage) Tenants() []Storage[models.User] {
+	return us.tenants.all()
+}
+
+func (us *UserStorage) ForSession(session string) Storage[models.User] {
+	return &UserStorage{DB: SessionDB_Engine(us.DB, session), hooks: us.hooks, tenants: us.tenants}
+}
+
 func (us *UserStorage) Get(id string) (models.User, error) {
+	if err := us.hooks.runBeforeGet(id); err != nil {
+		return models.User{}, err
+	}
+
 	val, err := us.DB.Get(id)
 	if err != nil {
-		fmt.Println(err)
 		return models.User{}, err
 	}
-	obj := us.BuildClient(val)
+	obj, err := us.BuildClient(val)
+	if err != nil {
+		return models.User{}, err
+	}
+	us.hooks.runAfterGet(&obj)
 	return obj, nil
 }
 
-func (us *UserStorage) Save(user models.User) (msg string, success bool) {
-	if !us.isValidUser(user) {
-		success = false
-		msg = "invalid user"
-		return msg, success
+func (us *UserStorage) Save(user models.User) (string, error) {
+	return us.SaveWithOptions(user, SaveOptions{})
+}
+
+func (us *UserStorage) SaveWithOptions(user models.User, options SaveOptions) (string, error) {
+	if err := us.prepareSave(&user); err != nil {
+		return "", err
+	}
+	return us.save(user, options)
+}
+
+// prepareSave runs the before save hooks on user, checks it can
+// be saved and hashes its password
+func (us *UserStorage) prepareSave(user *models.User) error {
+	if err := us.hooks.runBeforeSave(user); err != nil {
+		return err
+	}
+
+	if err := us.validateUser(*user); err != nil {
+		return err
 	}
 
 	if us.userWithEmailExist(user.Email) {
-		success = false
-		msg = fmt.Sprintf("user with email %s exists", user.Email)
-		return msg, success
+		return duplicateError("user with email %s exists", user.Email)
 	}
 
 	user.HashPassword()
-	id, err := us.DB.Save(user)
+	return nil
+}
+
+func (us *UserStorage) save(user models.User, options SaveOptions) (string, error) {
+	id, err := us.DB.SaveWithOptions(user, options)
 
 	if err != nil {
-		success = false
-		msg = err.Error()
-		return msg, success
+		return "", err
 	}
 
 	us.DB.Commit()
-	success = true
-	msg = id
+	us.hooks.runAfterSave(id, user)
 
-	return msg, success
+	return id, nil
 }
 
-func (us *UserStorage) Update(id string, data UpdateDesc) bool {
+func (us *UserStorage) Update(id string, data UpdateDesc) error {
+	data, err := us.prepareUpdate(id, data)
+	if err != nil {
+		return err
+	}
+
+	err = us.DB.Update(id, data)
+	if err != nil {
+		return err
+	}
+
+	us.DB.Commit()
+	us.hooks.runAfterUpdate(id, data)
+	return nil
+}
+
+func (us *UserStorage) UpdateIfVersion(id string, data UpdateDesc, expectedVersion int) error {
+	data, err := us.prepareUpdate(id, data)
+	if err != nil {
+		return err
+	}
+
+	err = us.DB.UpdateIfVersion(id, data, expectedVersion)
+	if err != nil {
+		return err
+	}
+
+	us.DB.Commit()
+	us.hooks.runAfterUpdate(id, data)
+	return nil
+}
+
+// prepareUpdate runs the before update hooks on data, validates it
+// and hashes the password it sets
+func (us *UserStorage) prepareUpdate(id string, data UpdateDesc) (UpdateDesc, error) {
+	if err := us.hooks.runBeforeUpdate(id, &data); err != nil {
+		return data, err
+	}
+
 	field := data.Field
 
 	// check if field exists on User struct
-	exists := fieldExistsOnUser(field)
-	canRebuild := us.userIsRebuildableWithUpdatedData(id, field, data.Value)
+	if !fieldExistsOnUser(field) {
+		return data, newValidationError(field, "unknown", "does not exist on user")
+	}
+
+	if err := us.validateUpdatedUser(id, field, data.Value); err != nil {
+		return data, err
+	}
+
+	return data, hashUpdatedPassword(&data)
+}
+
+func (us *UserStorage) Upsert(field string, value any, user models.User) (string, bool, error) {
+	if err := us.hooks.runBeforeSave(&user); err != nil {
+		return "", false, err
+	}
+
+	if err := us.validateUser(user); err != nil {
+		return "", false, err
+	}
+
+	// upserting on the email itself cannot take another user's
+	if field != "email" || value != user.Email {
+		emailOwnerId := us.DB.GetIdByFieldAndValue("email", user.Email)
+		targetId, _ := value.(string)
+		if field != "id" {
+			targetId = us.DB.GetIdByFieldAndValue(field, value)
+		}
+		if emailOwnerId != "" && emailOwnerId != targetId {
+			return "", false, duplicateError("user with email %s exists", user.Email)
+		}
+	}
+
+	user.HashPassword()
+	id, created, err := us.DB.Upsert(field, value, user)
+	if err != nil {
+		return "", false, err
+	}
+
+	us.DB.Commit()
+	us.hooks.runAfterSave(id, user)
+	return id, created, nil
+}
 
-	if !exists || !canRebuild {
-		return false
+func (us *UserStorage) Delete(id string) error {
+	if err := us.hooks.runBeforeDelete(id); err != nil {
+		return err
 	}
 
-	if data.Field == "password" {
-		hash, _ := bcrypt.GenerateFromPassword([]byte(data.Value.(string)), config.UserPassowrdHashCost)
-		data.Value = string(hash)
+	err := us.DB.Delete(id)
+	if err != nil {
+		return err
 	}
 
-	res := us.DB.Update(id, data)
 	us.DB.Commit()
-	return res
+	us.hooks.runAfterDelete(id)
+	return nil
 }
 
-func (us *UserStorage) Delete(id string) {
-	us.DB.Delete(id)
+// Restore undoes the soft delete of the user with id, unless
+// another user registered with the same email in the meantime
+func (us *UserStorage) Restore(id string) error {
+	deleted, err := us.DB.GetDeleted(id)
+	if err != nil {
+		return err
+	}
+
+	user, err := us.BuildClient(deleted)
+	if err != nil {
+		return err
+	}
+
+	sameEmail, err := us.DB.GetRecordsByField("email", user.Email)
+	if err != nil {
+		return err
+	}
+	if len(sameEmail) > 0 {
+		return duplicateError("user with email %s exists", user.Email)
+	}
+
+	err = us.DB.Restore(id)
+	if err != nil {
+		return err
+	}
+
 	us.DB.Commit()
+	return nil
+}
+
+func (us *UserStorage) PurgeDeleted(olderThan time.Duration) (int, error) {
+	purged, err := us.DB.PurgeDeleted(olderThan)
+	if err != nil {
+		return purged, err
+	}
+	return purged, us.DB.Commit()
 }
 
 func (us *UserStorage) GetByField(field string, value any) []models.User {
@@ -105,25 +268,98 @@ func (us *UserStorage) GetAll() []models.User {
 	return users
 }
 
+func (us *UserStorage) GetAllIncludingDeleted() []models.User {
+	retrievedUsers := us.DB.GetAllOfRecordsIncludingDeleted()
+	return us.buildManyUsers(retrievedUsers)
+}
+
+func (us *UserStorage) Search(query string, fields []string, limit int) ([]SearchHit[models.User], error) {
+	if len(fields) == 0 {
+		fields = userSearchFields
+	}
+
+	for _, field := range fields {
+		if !slices.Contains(userSearchFields, field) {
+			return nil, newValidationError("fields", "oneof",
+				field+" cannot be searched, search "+strings.Join(userSearchFields, ", "))
+		}
+	}
+
+	results, err := us.DB.Search(query, fields, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit[models.User], 0, len(results))
+	for _, result := range results {
+		user, err := us.BuildClient(result.Record)
+		if err != nil {
+			// still list the user, with the fields that could be read
+			fmt.Fprintln(os.Stderr, "UserStorage: failed to build user:", err.Error())
+		}
+		us.hooks.runAfterGet(&user)
+		hits = append(hits, SearchHit[models.User]{ID: result.ID, Score: result.Score, Data: user})
+	}
+	return hits, nil
+}
+
+// Import saves the users read from r. Plaintext passwords are hashed,
+// passwords that already are bcrypt hashes, e.g. exported with
+// IncludeSensitive, are kept. Rows with the email of an existing
+// user, or of an earlier row, are rejected
+func (us *UserStorage) Import(r io.Reader, options ImportOptions) (ImportReport, error) {
+	emails := map[string]bool{}
+	return ImportRecords(r, options.Format, func(user models.User) error {
+		if emails[user.Email] {
+			return duplicateError("user with email %s is imported by an earlier row", user.Email)
+		}
+
+		password := user.Password
+		if err := us.prepareSave(&user); err != nil {
+			return err
+		}
+		if isPasswordHash(password) {
+			user.Password = password
+		}
+
+		emails[user.Email] = true
+		if options.DryRun {
+			return nil
+		}
+		_, err := us.save(user, SaveOptions{})
+		return err
+	})
+}
+
+// Export writes every user to w. Password hashes are only exported
+// if options.IncludeSensitive is set
+func (us *UserStorage) Export(w io.Writer, options ExportOptions) error {
+	return ExportRecords(w, us.GetAll(), options, userSensitiveFields)
+}
+
+func isPasswordHash(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
 func (us *UserStorage) buildManyUsers(retrievedUsers []map[string]any) []models.User {
 	var users []models.User
 
 	for _, userDesc := range retrievedUsers {
-		user := us.BuildClient(userDesc)
+		user, err := us.BuildClient(userDesc)
+		if err != nil {
+			// still list the user, with the fields that could be read
+			fmt.Fprintln(os.Stderr, "UserStorage: failed to build user:", err.Error())
+		}
+		us.hooks.runAfterGet(&user)
 		users = append(users, user)
 	}
 
 	return users
 }
 
-func (us *UserStorage) BuildClient(objDesc any) models.User {
-
-	// after recovery, zero value of enclosing function
-	// will be returned
-	defer RecoverFromPanic()
-
-	user := GenericBuildClient[models.User](objDesc)
-	return user
+func (us *UserStorage) BuildClient(objDesc any) (models.User, error) {
+	return GenericBuildClient[models.User](objDesc)
 }
 
 func (us *UserStorage) userWithEmailExist(email string) bool {
@@ -131,19 +367,17 @@ func (us *UserStorage) userWithEmailExist(email string) bool {
 	return len(queryRes) > 0
 }
 
-func (us *UserStorage) isValidUser(user models.User) bool {
-	if user.Email == "" || user.Password == "" {
-		return false
-	}
-	return true
+// validateUser returns a *ValidationError listing the rules in
+// the validate tags of models.User that user fails, if any
+func (us *UserStorage) validateUser(user models.User) error {
+	return Validate(user)
 }
 
-// try to rebuild user with updated data and return
-// true if possible else return false
-func (us *UserStorage) userIsRebuildableWithUpdatedData(id, field string, value any) bool {
+// try to rebuild user with updated data and validate it
+func (us *UserStorage) validateUpdatedUser(id, field string, value any) error {
 	prevDesc, err := us.DB.Get(id)
 	if err != nil {
-		return false
+		return err
 	}
 	if concDesc, ok := prevDesc.(map[string]any); ok {
 		var copyUserDesc = map[string]any{}
@@ -152,10 +386,13 @@ func (us *UserStorage) userIsRebuildableWithUpdatedData(id, field string, value
 			copyUserDesc[key] = value
 		}
 		copyUserDesc[field] = value
-		user := us.BuildClient(copyUserDesc)
-		return us.isValidUser(user)
+		user, err := us.BuildClient(copyUserDesc)
+		if err != nil {
+			return err
+		}
+		return us.validateUser(user)
 	}
-	return false
+	return notFoundError(id)
 }
 
 func fieldExistsOnUser(field string) bool {
@@ -186,14 +423,47 @@ func MakeUserStorage(database, recordsName string) Storage[models.User] {
 
 	dbms := config.DBMS
 
-	STORAGE, err := GetDB_Engine(dbms, database, recordsName, userSchema...)
+	hooks := new(Hooks[models.User])
+
+	tenants := &tenantStorages[models.User]{storages: map[string]Storage[models.User]{}}
+	tenants.makeStorage = func(tenant string) (Storage[models.User], error) {
+		STORAGE, err := GetTenantDB_Engine(dbms, database, recordsName, tenant, userSchema...)
+		if err != nil {
+			return nil, err
+		}
+		// users are upserted by email
+		if err := CreateUniqueIndex(STORAGE, "email"); err != nil {
+			fmt.Fprintln(os.Stderr, "MakeUserStorage: failed to create the email index:", err.Error())
+		}
+		if err := CreateSearchIndex(STORAGE, userSearchFields...); err != nil {
+			fmt.Fprintln(os.Stderr, "MakeUserStorage: failed to create the search index:", err.Error())
+		}
+		return &UserStorage{DB: STORAGE, hooks: hooks, tenants: tenants}, nil
+	}
+
+	US, err := tenants.get("")
 
 	if err != nil {
 		panic(err)
 	}
 
-	var DB DB_Engine = STORAGE
-	US := new(UserStorage)
-	US.DB = DB
 	return US
 }
+
+// hashUpdatedPassword hashes the value of data if it sets the
+// password. Passwords are hashed once validated, so that validate
+// rules apply to the password rather than its hash
+func hashUpdatedPassword(data *UpdateDesc) error {
+	if data.Field != "password" {
+		return nil
+	}
+
+	password, ok := data.Value.(string)
+	if !ok || password == "" {
+		return newValidationError("password", "required", "must be a non empty string")
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), config.UserPassowrdHashCost)
+	data.Value = string(hash)
+	return nil
+}
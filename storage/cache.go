@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// CacheOptions configures a CachedEngine
+type CacheOptions struct {
+	// how long a record or a field query result is cached
+	TTL time.Duration
+	// how long the absence of a record is cached,
+	// 0 disables negative caching
+	NegativeTTL time.Duration
+}
+
+// CacheStats counts the reads a CachedEngine served from its
+// TempStore, Hits, and from the engine it wraps, Misses
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// cached in place of a record that does not exist
+const CACHE_NOT_FOUND = "null"
+
+// CachedEngine is a DB_Engine that caches the results of Get,
+// GetRecordsByField and GetIdByFieldAndValue of the engine it
+// wraps in a TempStore.
+//
+// Writes made through it invalidate the cached record they change
+// and every cached field query, by moving them to a new generation
+// of keys. A read that misses caches its result under the
+// generation it started in, so a write racing with it is not
+// undone by a stale entry. For the read-your-writes window of an
+// engine with replicas after a generation is made, misses are read
+// from its primary, so a lagging replica cannot fill the new
+// generation with the record as it was before the write, see
+// config.ReadYourWritesWindow. Writes made to the records by other means,
+// e.g. another process not using the cache, are only seen once
+// the cached entries expire.
+//
+// Records are cached as json, so their numbers are read back as
+// float64 whatever the engine's column types, as with FileDb.
+// Cached records are checked for expiry, see SaveOptions, so a
+// record is never read past its expiresAt
+type CachedEngine struct {
+	engine  DB_Engine
+	store   TempStore
+	prefix  string
+	options CacheOptions
+	stats   *cacheCounters
+	// primary reads the primary of engine, nil if it has no replicas
+	primary        DB_Engine
+	readYourWrites time.Duration
+}
+
+// cacheCounters are shared by the sessions of a CachedEngine
+type cacheCounters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (cache *CachedEngine) New(engine DB_Engine, store TempStore, prefix string, options CacheOptions) *CachedEngine {
+	cache.engine = engine
+	cache.store = store
+	cache.prefix = prefix
+	cache.options = options
+	cache.stats = new(cacheCounters)
+	cache.primary, cache.readYourWrites = primaryReadsDB_Engine(engine)
+	return cache
+}
+
+// Engine returns the DB_Engine whose reads are cached
+func (cache *CachedEngine) Engine() DB_Engine {
+	return cache.engine
+}
+
+// Session returns cache caching the reads of its engine for
+// session, see SessionDB_Engine. It shares cache's entries and stats
+func (cache *CachedEngine) Session(session string) *CachedEngine {
+	sessionCache := *cache
+	sessionCache.engine = SessionDB_Engine(cache.engine, session)
+	return &sessionCache
+}
+
+func (cache *CachedEngine) Stats() CacheStats {
+	return CacheStats{Hits: cache.stats.hits.Load(), Misses: cache.stats.misses.Load()}
+}
+
+func (cache *CachedEngine) Get(id string) (any, error) {
+	generation := cache.recordGeneration(id)
+	key := cache.recordKey(id, generation)
+	if cached := cache.store.GetVal(key); cached != "" {
+		if cached == CACHE_NOT_FOUND {
+			cache.stats.hits.Add(1)
+			return nil, notFoundError(id)
+		}
+
+		var record map[string]any
+		if json.Unmarshal([]byte(cached), &record) == nil {
+			cache.stats.hits.Add(1)
+			if isExpired(record, metadataNow()) {
+				return nil, notFoundError(id)
+			}
+			return record, nil
+		}
+	}
+
+	cache.stats.misses.Add(1)
+	record, err := cache.reader(generation).Get(id)
+	if errors.Is(err, ErrNotFound) {
+		cache.setWithTTL(key, CACHE_NOT_FOUND, cache.options.NegativeTTL)
+	} else if err == nil {
+		cache.set(key, record)
+	}
+	return record, err
+}
+
+func (cache *CachedEngine) GetRecordsByField(field string, value any) ([]map[string]any, error) {
+	generation := cache.generation()
+	key, err := cache.fieldKey("field", generation, field, value)
+	if err != nil {
+		return cache.engine.GetRecordsByField(field, value)
+	}
+
+	if cached := cache.store.GetVal(key); cached != "" {
+		var records []map[string]any
+		if json.Unmarshal([]byte(cached), &records) == nil {
+			cache.stats.hits.Add(1)
+			return unexpiredRecords(records), nil
+		}
+	}
+
+	cache.stats.misses.Add(1)
+	records, err := cache.reader(generation).GetRecordsByField(field, value)
+	if err == nil {
+		cache.set(key, records)
+	}
+	return records, err
+}
+
+func (cache *CachedEngine) Save(data any) (string, error) {
+	return cache.SaveWithOptions(data, SaveOptions{})
+}
+
+func (cache *CachedEngine) SaveWithOptions(data any, options SaveOptions) (string, error) {
+	id, err := cache.engine.SaveWithOptions(data, options)
+	if err == nil {
+		cache.invalidate(id)
+	}
+	return id, err
+}
+
+// Update invalidates even when it fails, a failed update
+// can be the sign of a stale cached record
+func (cache *CachedEngine) Update(id string, data UpdateDesc) error {
+	defer cache.invalidate(id)
+	return cache.engine.Update(id, data)
+}
+
+func (cache *CachedEngine) UpdateIfVersion(id string, data UpdateDesc, expectedVersion int) error {
+	defer cache.invalidate(id)
+	return cache.engine.UpdateIfVersion(id, data, expectedVersion)
+}
+
+func (cache *CachedEngine) Upsert(field string, value any, data any) (string, bool, error) {
+	id, created, err := cache.engine.Upsert(field, value, data)
+	if err == nil {
+		cache.invalidate(id)
+	}
+	return id, created, err
+}
+
+func (cache *CachedEngine) Delete(id string) error {
+	defer cache.invalidate(id)
+	return cache.engine.Delete(id)
+}
+
+func (cache *CachedEngine) Restore(id string) error {
+	defer cache.invalidate(id)
+	return cache.engine.Restore(id)
+}
+
+func (cache *CachedEngine) GetDeleted(id string) (any, error) {
+	return cache.engine.GetDeleted(id)
+}
+
+func (cache *CachedEngine) PurgeDeleted(olderThan time.Duration) (int, error) {
+	purged, err := cache.engine.PurgeDeleted(olderThan)
+	if purged > 0 {
+		cache.newGeneration()
+	}
+	return purged, err
+}
+
+// GetIdByFieldAndValue can return the id of a record that expired
+// since it was cached, Get of the id then returns ErrNotFound
+func (cache *CachedEngine) GetIdByFieldAndValue(field string, value any) string {
+	generation := cache.generation()
+	key, err := cache.fieldKey("id", generation, field, value)
+	if err != nil {
+		return cache.engine.GetIdByFieldAndValue(field, value)
+	}
+
+	if cached := cache.store.GetVal(key); cached != "" {
+		cache.stats.hits.Add(1)
+		if cached == CACHE_NOT_FOUND {
+			return ""
+		}
+		return cached
+	}
+
+	cache.stats.misses.Add(1)
+	id := cache.reader(generation).GetIdByFieldAndValue(field, value)
+	if id == "" {
+		cache.setWithTTL(key, CACHE_NOT_FOUND, cache.options.NegativeTTL)
+	} else {
+		cache.setWithTTL(key, id, cache.options.TTL)
+	}
+	return id
+}
+
+func (cache *CachedEngine) GetAllOfRecords() []map[string]any {
+	return cache.engine.GetAllOfRecords()
+}
+
+func (cache *CachedEngine) GetAllOfRecordsIncludingDeleted() []map[string]any {
+	return cache.engine.GetAllOfRecordsIncludingDeleted()
+}
+
+func (cache *CachedEngine) Search(query string, fields []string, limit int) ([]SearchResult, error) {
+	return cache.engine.Search(query, fields, limit)
+}
+
+func (cache *CachedEngine) Watch(filter WatchFilter) (<-chan ChangeEvent, func(), error) {
+	return cache.engine.Watch(filter)
+}
+
+func (cache *CachedEngine) Commit() error {
+	return cache.engine.Commit()
+}
+
+func (cache *CachedEngine) recordKey(id, generation string) string {
+	return cache.prefix + "record:" + generation + ":" + id
+}
+
+// fieldKey returns the key of the result of the query kind on
+// field and value, in generation of field queries
+func (cache *CachedEngine) fieldKey(kind, generation, field string, value any) (string, error) {
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return cache.prefix + kind + ":" + generation + ":" + field + "=" + string(encodedValue), nil
+}
+
+// reader returns the engine a miss of the keys of generation is
+// read from: the primary if generation was made less than the
+// read-your-writes window ago, as a write may not have reached the
+// replicas yet, else the engine
+func (cache *CachedEngine) reader(generation string) DB_Engine {
+	if cache.primary == nil {
+		return cache.engine
+	}
+
+	made, err := strconv.ParseInt(generation, 36, 64)
+	if err != nil || time.Since(time.Unix(0, made)) < cache.readYourWrites {
+		return cache.primary
+	}
+	return cache.engine
+}
+
+// recordGeneration returns the current generation of the keys of
+// the record with id
+func (cache *CachedEngine) recordGeneration(id string) string {
+	generation := cache.store.GetVal(cache.prefix + "generation:" + id)
+	if generation == "" {
+		generation = cache.newRecordGeneration(id)
+	}
+	return generation
+}
+
+// newRecordGeneration orphans the cached record with id. Once the
+// generation expires, the entries it keys are orphaned too
+func (cache *CachedEngine) newRecordGeneration(id string) string {
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+	cache.setWithTTL(cache.prefix+"generation:"+id, generation,
+		max(cache.options.TTL, cache.options.NegativeTTL))
+	return generation
+}
+
+// generation returns the current generation of field query keys
+func (cache *CachedEngine) generation() string {
+	generation := cache.store.GetVal(cache.prefix + "generation")
+	if generation == "" {
+		generation = cache.newGeneration()
+	}
+	return generation
+}
+
+// newGeneration orphans the cached field query results, they
+// are left to expire. Generations are timestamps so that
+// processes sharing the TempStore need no read-modify-write
+func (cache *CachedEngine) newGeneration() string {
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+	cache.store.SetKeyToVal(cache.prefix+"generation", generation)
+	return generation
+}
+
+func (cache *CachedEngine) invalidate(id string) {
+	if id != "" {
+		cache.newRecordGeneration(id)
+	}
+	cache.newGeneration()
+}
+
+func (cache *CachedEngine) set(key string, value any) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	cache.setWithTTL(key, string(encoded), cache.options.TTL)
+}
+
+func (cache *CachedEngine) setWithTTL(key, value string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	cache.store.SetWithTTL(key, value, ttl)
+}
+
+// unexpiredRecords filters the expired records out of records
+func unexpiredRecords(records []map[string]any) []map[string]any {
+	now := metadataNow()
+	unexpired := records[:0:0]
+	for _, record := range records {
+		if !isExpired(record, now) {
+			unexpired = append(unexpired, record)
+		}
+	}
+	return unexpired
+}
+
+// MakeCachedEngine caches the reads of engine, which holds the
+// records recordsName of database, in store
+func MakeCachedEngine(engine DB_Engine, store TempStore, database, recordsName string, options CacheOptions) *CachedEngine {
+	prefix := fmt.Sprintf("cache:%s:%s:", database, recordsName)
+	return new(CachedEngine).New(engine, store, prefix, options)
+}
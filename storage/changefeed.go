@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+type ChangeOp string
+
+const (
+	INSERT_OP ChangeOp = "insert"
+	UPDATE_OP ChangeOp = "update"
+	DELETE_OP ChangeOp = "delete"
+)
+
+// ChangeEvent describes a change to a record. Data holds the
+// record's values after the change and is nil on delete
+type ChangeEvent struct {
+	Op   ChangeOp
+	ID   string
+	Data map[string]any
+}
+
+// WatchFilter selects the events a watcher receives.
+// Empty IDs or Ops match any id or op
+type WatchFilter struct {
+	IDs []string
+	Ops []ChangeOp
+}
+
+func (filter WatchFilter) matches(event ChangeEvent) bool {
+	if len(filter.IDs) > 0 && !slices.Contains(filter.IDs, event.ID) {
+		return false
+	}
+	if len(filter.Ops) > 0 && !slices.Contains(filter.Ops, event.Op) {
+		return false
+	}
+	return true
+}
+
+// number of events a watcher can fall behind by before
+// in-process notifications to it are dropped
+const WATCH_BUFFER_SIZE = 256
+
+// changeNotifier fans out in-process change events to watchers
+type changeNotifier struct {
+	mu       sync.Mutex
+	watchers map[chan ChangeEvent]WatchFilter
+}
+
+func (notifier *changeNotifier) watch(filter WatchFilter) (<-chan ChangeEvent, func()) {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	if notifier.watchers == nil {
+		notifier.watchers = map[chan ChangeEvent]WatchFilter{}
+	}
+
+	events := make(chan ChangeEvent, WATCH_BUFFER_SIZE)
+	notifier.watchers[events] = filter
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			notifier.mu.Lock()
+			defer notifier.mu.Unlock()
+			delete(notifier.watchers, events)
+			close(events)
+		})
+	}
+
+	return events, stop
+}
+
+// notify never blocks, so it is safe to call while holding
+// the lock of the store being changed
+func (notifier *changeNotifier) notify(event ChangeEvent) {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	for events, filter := range notifier.watchers {
+		if !filter.matches(event) {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+			fmt.Fprintln(os.Stderr, "changeNotifier: watcher is full, dropped", event.Op, "event of", event.ID)
+		}
+	}
+}
+
+func (notifier *changeNotifier) hasWatchers() bool {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	return len(notifier.watchers) > 0
+}
@@ -0,0 +1,396 @@
+package storage
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// decoder sets go values from records as engines return them,
+// i.e. maps, slices and scalars, coercing scalars to the kind of
+// the destination. Fields it fails to set are collected in
+// failures, by their path in the record e.g. address.city or tags[1]
+type decoder struct {
+	failures ValidationError
+}
+
+func (d *decoder) fail(path, reason string) {
+	if path == "" {
+		path = "."
+	}
+	d.failures.add(FieldError{Field: path, Rule: "type", Message: reason})
+}
+
+func (d *decoder) err() error {
+	return d.failures.orNil()
+}
+
+func (d *decoder) decode(dst reflect.Value, src any, path string) {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return
+	}
+
+	srcVal := reflect.ValueOf(src)
+	if srcVal.Type().AssignableTo(dst.Type()) {
+		dst.Set(srcVal)
+		return
+	}
+
+	if dst.Kind() == reflect.Pointer {
+		elem := reflect.New(dst.Type().Elem())
+		d.decode(elem.Elem(), src, path)
+		dst.Set(elem)
+		return
+	}
+
+	if d.decodeUnmarshaler(dst, src, path) {
+		return
+	}
+
+	switch dst.Kind() {
+	case reflect.Bool:
+		d.decodeBool(dst, src, path)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		d.decodeInt(dst, src, path)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		d.decodeUint(dst, src, path)
+	case reflect.Float32, reflect.Float64:
+		d.decodeFloat(dst, src, path)
+	case reflect.String:
+		str, ok := src.(string)
+		if !ok {
+			d.fail(path, fmt.Sprintf("expected a string, got %T", src))
+			return
+		}
+		dst.SetString(str)
+	case reflect.Struct:
+		mapRep, ok := src.(map[string]any)
+		if !ok {
+			d.fail(path, fmt.Sprintf("expected an object, got %T", src))
+			return
+		}
+		d.decodeStruct(dst, mapRep, path)
+	case reflect.Map:
+		d.decodeMap(dst, src, path)
+	case reflect.Slice:
+		d.decodeSlice(dst, src, path)
+	case reflect.Array:
+		d.decodeArray(dst, src, path)
+	case reflect.Interface:
+		d.fail(path, fmt.Sprintf("%T does not implement %s", src, dst.Type()))
+	default:
+		d.fail(path, "unsupported type "+dst.Type().String())
+	}
+}
+
+// decodeUnmarshaler decodes src into dst with the unmarshal method
+// dst implements, if any. time.Time is also decoded from unix
+// milliseconds, the unit of the metadata timestamps
+func (d *decoder) decodeUnmarshaler(dst reflect.Value, src any, path string) bool {
+	if dst.Type() == timeType {
+		if millis, isNum := getFloat64Equivalent(src); isNum {
+			dst.Set(reflect.ValueOf(time.UnixMilli(int64(millis))))
+			return true
+		}
+	}
+
+	if !dst.CanAddr() {
+		return false
+	}
+	ptr := dst.Addr()
+
+	if ptr.Type().Implements(textUnmarshalerType) {
+		if str, ok := src.(string); ok {
+			err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(str))
+			if err != nil {
+				d.fail(path, err.Error())
+			}
+			return true
+		}
+	}
+
+	if ptr.Type().Implements(jsonUnmarshalerType) {
+		jsonRep, err := json.Marshal(src)
+		if err == nil {
+			err = ptr.Interface().(json.Unmarshaler).UnmarshalJSON(jsonRep)
+		}
+		if err != nil {
+			d.fail(path, err.Error())
+		}
+		return true
+	}
+
+	return false
+}
+
+func (d *decoder) decodeBool(dst reflect.Value, src any, path string) {
+	switch val := src.(type) {
+	case bool:
+		dst.SetBool(val)
+	case string:
+		parsed, err := strconv.ParseBool(val)
+		if err != nil {
+			d.fail(path, fmt.Sprintf("expected a boolean, got %q", val))
+			return
+		}
+		dst.SetBool(parsed)
+	default:
+		d.fail(path, fmt.Sprintf("expected a boolean, got %T", src))
+	}
+}
+
+func (d *decoder) decodeInt(dst reflect.Value, src any, path string) {
+	var num int64
+	srcVal := reflect.ValueOf(src)
+	switch srcVal.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num = srcVal.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if srcVal.Uint() > math.MaxInt64 {
+			d.fail(path, "overflows "+dst.Type().String())
+			return
+		}
+		num = int64(srcVal.Uint())
+	case reflect.Float32, reflect.Float64:
+		float := srcVal.Float()
+		if float != math.Trunc(float) {
+			d.fail(path, fmt.Sprintf("expected an integer, got %v", float))
+			return
+		}
+		num = int64(float)
+	case reflect.String:
+		parsed, err := strconv.ParseInt(srcVal.String(), 10, 64)
+		if err != nil {
+			d.fail(path, fmt.Sprintf("expected an integer, got %q", srcVal.String()))
+			return
+		}
+		num = parsed
+	default:
+		d.fail(path, fmt.Sprintf("expected an integer, got %T", src))
+		return
+	}
+
+	if dst.OverflowInt(num) {
+		d.fail(path, "overflows "+dst.Type().String())
+		return
+	}
+	dst.SetInt(num)
+}
+
+func (d *decoder) decodeUint(dst reflect.Value, src any, path string) {
+	var num uint64
+	srcVal := reflect.ValueOf(src)
+	switch srcVal.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if srcVal.Int() < 0 {
+			d.fail(path, "expected a non negative integer")
+			return
+		}
+		num = uint64(srcVal.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		num = srcVal.Uint()
+	case reflect.Float32, reflect.Float64:
+		float := srcVal.Float()
+		if float != math.Trunc(float) || float < 0 {
+			d.fail(path, fmt.Sprintf("expected a non negative integer, got %v", float))
+			return
+		}
+		num = uint64(float)
+	case reflect.String:
+		parsed, err := strconv.ParseUint(srcVal.String(), 10, 64)
+		if err != nil {
+			d.fail(path, fmt.Sprintf("expected a non negative integer, got %q", srcVal.String()))
+			return
+		}
+		num = parsed
+	default:
+		d.fail(path, fmt.Sprintf("expected a non negative integer, got %T", src))
+		return
+	}
+
+	if dst.OverflowUint(num) {
+		d.fail(path, "overflows "+dst.Type().String())
+		return
+	}
+	dst.SetUint(num)
+}
+
+func (d *decoder) decodeFloat(dst reflect.Value, src any, path string) {
+	num, isNum := getFloat64Equivalent(src)
+	if str, isStr := src.(string); isStr {
+		parsed, err := strconv.ParseFloat(str, 64)
+		num, isNum = parsed, err == nil
+	}
+
+	if !isNum {
+		d.fail(path, fmt.Sprintf("expected a number, got %v", src))
+		return
+	}
+
+	if dst.OverflowFloat(num) {
+		d.fail(path, "overflows "+dst.Type().String())
+		return
+	}
+	dst.SetFloat(num)
+}
+
+// decodeStruct sets the fields of dst from mapRep keyed the way
+// encoding/json keys them: by json tag name, else by field name.
+// Fields tagged json:"-" are skipped and the fields of embedded
+// structs without a tag name are read from mapRep itself
+func (d *decoder) decodeStruct(dst reflect.Value, mapRep map[string]any, path string) {
+	typ := dst.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && field.Tag.Get("json") == "-" {
+			continue
+		}
+
+		fieldVal := dst.Field(i)
+		if field.Anonymous && name == "" {
+			d.decodeEmbedded(fieldVal, mapRep, path)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		val, exists := mapRep[name]
+		if !exists {
+			continue
+		}
+		d.decode(fieldVal, val, joinPath(path, name))
+	}
+}
+
+func (d *decoder) decodeEmbedded(dst reflect.Value, mapRep map[string]any, path string) {
+	typ := dst.Type()
+	isPtr := typ.Kind() == reflect.Pointer
+	if isPtr {
+		typ = typ.Elem()
+	}
+
+	if typ.Kind() != reflect.Struct {
+		// an embedded non struct is keyed by its type name
+		if val, exists := mapRep[typ.Name()]; exists && dst.CanSet() {
+			d.decode(dst, val, joinPath(path, typ.Name()))
+		}
+		return
+	}
+
+	if !isPtr {
+		d.decodeStruct(dst, mapRep, path)
+		return
+	}
+
+	// fields of an unexported embedded pointer cannot be reached
+	if !dst.CanSet() {
+		return
+	}
+	if dst.IsNil() {
+		dst.Set(reflect.New(typ))
+	}
+	d.decodeStruct(dst.Elem(), mapRep, path)
+}
+
+func (d *decoder) decodeMap(dst reflect.Value, src any, path string) {
+	mapRep, ok := src.(map[string]any)
+	if !ok {
+		d.fail(path, fmt.Sprintf("expected an object, got %T", src))
+		return
+	}
+
+	typ := dst.Type()
+	keyIsText := reflect.PointerTo(typ.Key()).Implements(textUnmarshalerType)
+	if typ.Key().Kind() != reflect.String && !keyIsText {
+		d.fail(path, "unsupported map key type "+typ.Key().String())
+		return
+	}
+
+	decoded := reflect.MakeMapWithSize(typ, len(mapRep))
+	for key, val := range mapRep {
+		keyVal := reflect.New(typ.Key()).Elem()
+		if keyIsText {
+			err := keyVal.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key))
+			if err != nil {
+				d.fail(joinPath(path, key), "invalid key: "+err.Error())
+				continue
+			}
+		} else {
+			keyVal.SetString(key)
+		}
+
+		elem := reflect.New(typ.Elem()).Elem()
+		d.decode(elem, val, joinPath(path, key))
+		decoded.SetMapIndex(keyVal, elem)
+	}
+	dst.Set(decoded)
+}
+
+func (d *decoder) decodeSlice(dst reflect.Value, src any, path string) {
+	// encoding/json encodes []byte as base64
+	if str, ok := src.(string); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+		bytes, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			d.fail(path, "expected base64 encoded bytes")
+			return
+		}
+		dst.SetBytes(bytes)
+		return
+	}
+
+	srcVal := reflect.ValueOf(src)
+	if srcVal.Kind() != reflect.Slice && srcVal.Kind() != reflect.Array {
+		d.fail(path, fmt.Sprintf("expected a list, got %T", src))
+		return
+	}
+
+	decoded := reflect.MakeSlice(dst.Type(), srcVal.Len(), srcVal.Len())
+	for i := 0; i < srcVal.Len(); i++ {
+		d.decode(decoded.Index(i), srcVal.Index(i).Interface(), fmt.Sprintf("%s[%d]", path, i))
+	}
+	dst.Set(decoded)
+}
+
+func (d *decoder) decodeArray(dst reflect.Value, src any, path string) {
+	srcVal := reflect.ValueOf(src)
+	if srcVal.Kind() != reflect.Slice && srcVal.Kind() != reflect.Array {
+		d.fail(path, fmt.Sprintf("expected a list, got %T", src))
+		return
+	}
+
+	if srcVal.Len() != dst.Len() {
+		d.fail(path, fmt.Sprintf("expected a list of %d elements, got %d", dst.Len(), srcVal.Len()))
+		return
+	}
+
+	for i := 0; i < srcVal.Len(); i++ {
+		d.decode(dst.Index(i), srcVal.Index(i).Interface(), fmt.Sprintf("%s[%d]", path, i))
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
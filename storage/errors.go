@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// errors returned by Storage and DB_Engine wrap one of these,
+// test for them with errors.Is
+var (
+	ErrNotFound   = errors.New("record not found")
+	ErrDuplicate  = errors.New("record already exists")
+	ErrValidation = errors.New("invalid record")
+	ErrConflict   = errors.New("record was modified concurrently")
+	// ErrBackend wraps failures of the underlying database
+	// itself, e.g. a lost connection
+	ErrBackend = errors.New("storage backend failure")
+)
+
+// ErrVersionsNotTracked is returned by UpdateIfVersion of engines
+// that do not keep record versions
+var ErrVersionsNotTracked = errors.New(
+	"record versions are not tracked, set config.TrackRecordMetadata to true")
+
+// FieldError describes a rule a field failed. Rule is a validate
+// tag rule such as required or email, or one of type, unknown,
+// readonly and format for values the storage itself rejects
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a record or an update of it
+// is rejected. Fields maps every offending field to the reason it
+// failed first, Errors lists every rule failed. It matches ErrValidation
+type ValidationError struct {
+	Fields map[string]string
+	Errors []FieldError
+}
+
+func newValidationError(field, rule, reason string) *ValidationError {
+	err := &ValidationError{}
+	err.add(FieldError{Field: field, Rule: rule, Message: reason})
+	return err
+}
+
+func (err *ValidationError) add(fieldErr FieldError) {
+	if err.Fields == nil {
+		err.Fields = map[string]string{}
+	}
+	if _, exists := err.Fields[fieldErr.Field]; !exists {
+		err.Fields[fieldErr.Field] = fieldErr.Message
+	}
+	err.Errors = append(err.Errors, fieldErr)
+}
+
+// orNil returns err as an error, or nil if no field failed
+func (err *ValidationError) orNil() error {
+	if len(err.Errors) == 0 {
+		return nil
+	}
+	return err
+}
+
+func (err *ValidationError) Error() string {
+	fields := make([]string, 0, len(err.Fields))
+	for field := range err.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	details := make([]string, 0, len(fields))
+	for _, field := range fields {
+		details = append(details, field+" "+err.Fields[field])
+	}
+	return ErrValidation.Error() + ": " + strings.Join(details, ", ")
+}
+
+func (err *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// VersionConflictError is returned by UpdateIfVersion when the
+// stored record's version is not the one the caller expected,
+// i.e. the record was changed since the caller last read it.
+// It matches ErrConflict
+type VersionConflictError struct {
+	ID       string
+	Expected int
+	Actual   int
+}
+
+func (err *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict on record %s: expected version %d, found %d",
+		err.ID, err.Expected, err.Actual)
+}
+
+func (err *VersionConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+func notFoundError(id string) error {
+	return fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+func duplicateError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrDuplicate, fmt.Sprintf(format, args...))
+}
+
+// backendError wraps err, a failure of the database, in ErrBackend
+func backendError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrBackend, err)
+}
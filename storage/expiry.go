@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// SaveOptions configures how SaveWithOptions saves a record
+type SaveOptions struct {
+	// how long the record lives, 0 keeps it until it is deleted.
+	// Once expired, a record is left out of all reads and erased
+	// by the engine, see config.ExpiredRecordsVacuumInterval
+	TTL time.Duration
+}
+
+// expiresAt returns the expiresAt, in unix milliseconds, of a
+// record saved with options
+func (options SaveOptions) expiresAt() (int64, bool) {
+	if options.TTL <= 0 {
+		return 0, false
+	}
+	return time.Now().Add(options.TTL).UnixMilli(), true
+}
+
+// isExpired reports whether record expires at or before now,
+// in unix milliseconds
+func isExpired(record map[string]any, now int64) bool {
+	expiresAt, expires := getFloat64Equivalent(record[EXPIRES_AT_FIELD])
+	return expires && expiresAt <= float64(now)
+}
+
+// runEvery runs task every interval in the background until the
+// returned stop is called. stop can be called more than once
+func runEvery(interval time.Duration, task func()) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				task()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,150 @@
+package storage
+
+import "sync"
+
+// Hooks holds the callbacks a Storage runs around persistence.
+// Hooks of a kind run in the order they were registered. A before
+// hook can mutate what it is passed, or veto the operation by
+// returning an error, in which case the hooks after it and the
+// operation itself do not run. After hooks run only once the
+// operation succeeded
+type Hooks[T any] struct {
+	mu           sync.RWMutex
+	beforeSave   []func(data *T) error
+	afterSave    []func(id string, data T)
+	beforeUpdate []func(id string, data *UpdateDesc) error
+	afterUpdate  []func(id string, data UpdateDesc)
+	beforeDelete []func(id string) error
+	afterDelete  []func(id string)
+	beforeGet    []func(id string) error
+	afterGet     []func(data *T)
+}
+
+// BeforeSave registers hook to run before Save and Upsert
+func (hooks *Hooks[T]) BeforeSave(hook func(data *T) error) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.beforeSave = append(hooks.beforeSave, hook)
+}
+
+// AfterSave registers hook to run after Save and Upsert
+func (hooks *Hooks[T]) AfterSave(hook func(id string, data T)) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.afterSave = append(hooks.afterSave, hook)
+}
+
+func (hooks *Hooks[T]) BeforeUpdate(hook func(id string, data *UpdateDesc) error) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.beforeUpdate = append(hooks.beforeUpdate, hook)
+}
+
+func (hooks *Hooks[T]) AfterUpdate(hook func(id string, data UpdateDesc)) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.afterUpdate = append(hooks.afterUpdate, hook)
+}
+
+func (hooks *Hooks[T]) BeforeDelete(hook func(id string) error) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.beforeDelete = append(hooks.beforeDelete, hook)
+}
+
+func (hooks *Hooks[T]) AfterDelete(hook func(id string)) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.afterDelete = append(hooks.afterDelete, hook)
+}
+
+// BeforeGet registers hook to run before Get
+func (hooks *Hooks[T]) BeforeGet(hook func(id string) error) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.beforeGet = append(hooks.beforeGet, hook)
+}
+
+// AfterGet registers hook to run on every object read, by Get
+// as well as GetByField and GetAll
+func (hooks *Hooks[T]) AfterGet(hook func(data *T)) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.afterGet = append(hooks.afterGet, hook)
+}
+
+func (hooks *Hooks[T]) runBeforeSave(data *T) error {
+	hooks.mu.RLock()
+	defer hooks.mu.RUnlock()
+	for _, hook := range hooks.beforeSave {
+		if err := hook(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hooks *Hooks[T]) runAfterSave(id string, data T) {
+	hooks.mu.RLock()
+	defer hooks.mu.RUnlock()
+	for _, hook := range hooks.afterSave {
+		hook(id, data)
+	}
+}
+
+func (hooks *Hooks[T]) runBeforeUpdate(id string, data *UpdateDesc) error {
+	hooks.mu.RLock()
+	defer hooks.mu.RUnlock()
+	for _, hook := range hooks.beforeUpdate {
+		if err := hook(id, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hooks *Hooks[T]) runAfterUpdate(id string, data UpdateDesc) {
+	hooks.mu.RLock()
+	defer hooks.mu.RUnlock()
+	for _, hook := range hooks.afterUpdate {
+		hook(id, data)
+	}
+}
+
+func (hooks *Hooks[T]) runBeforeDelete(id string) error {
+	hooks.mu.RLock()
+	defer hooks.mu.RUnlock()
+	for _, hook := range hooks.beforeDelete {
+		if err := hook(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hooks *Hooks[T]) runAfterDelete(id string) {
+	hooks.mu.RLock()
+	defer hooks.mu.RUnlock()
+	for _, hook := range hooks.afterDelete {
+		hook(id)
+	}
+}
+
+func (hooks *Hooks[T]) runBeforeGet(id string) error {
+	hooks.mu.RLock()
+	defer hooks.mu.RUnlock()
+	for _, hook := range hooks.beforeGet {
+		if err := hook(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hooks *Hooks[T]) runAfterGet(data *T) {
+	hooks.mu.RLock()
+	defer hooks.mu.RUnlock()
+	for _, hook := range hooks.afterGet {
+		hook(data)
+	}
+}
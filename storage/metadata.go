@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// fields maintained by engines on every record when
+// config.TrackRecordMetadata is true. createdAt and updatedAt
+// are unix milliseconds, version starts at 1 and is incremented
+// on every update
+const (
+	CREATED_AT_FIELD = "createdAt"
+	UPDATED_AT_FIELD = "updatedAt"
+	VERSION_FIELD    = "version"
+)
+
+// set on records soft deleted when config.SoftDelete is true,
+// in unix milliseconds
+const DELETED_AT_FIELD = "deletedAt"
+
+// set on records saved with a TTL, see SaveOptions, in unix
+// milliseconds. mongo stores it as a date for its TTL index
+const EXPIRES_AT_FIELD = "expiresAt"
+
+var METADATA_FIELDS = []string{CREATED_AT_FIELD, UPDATED_AT_FIELD, VERSION_FIELD}
+
+func IsMetadataField(field string) bool {
+	return slices.Contains(METADATA_FIELDS, field)
+}
+
+func metadataNow() int64 {
+	return time.Now().UnixMilli()
+}
+
+// setMetadataOnSave overwrites whatever metadata the caller passed
+// in mapRep with that of a newly created record
+func setMetadataOnSave(mapRep map[string]any) {
+	now := metadataNow()
+	mapRep[CREATED_AT_FIELD] = now
+	mapRep[UPDATED_AT_FIELD] = now
+	mapRep[VERSION_FIELD] = 1
+}
+
+// setMetadataOnUpdate stamps an existing record in place
+func setMetadataOnUpdate(record map[string]any) {
+	version, _ := getFloat64Equivalent(record[VERSION_FIELD])
+	record[UPDATED_AT_FIELD] = metadataNow()
+	record[VERSION_FIELD] = int(version) + 1
+}
+
+// removeMetadataFields deletes metadata from a map rep callers
+// passed in, engines own these fields
+func removeMetadataFields(mapRep map[string]any) {
+	for _, field := range METADATA_FIELDS {
+		delete(mapRep, field)
+	}
+}
+
+// SortRecordsByField sorts records in place by the number or string
+// at field, e.g. by createdAt to list records in order of creation.
+// Records missing the field are placed last
+func SortRecordsByField(records []map[string]any, field string, descending bool) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, aExists := getValInNestedFieldOfMap(field, records[i])
+		b, bExists := getValInNestedFieldOfMap(field, records[j])
+		if !aExists || a == nil {
+			return false
+		}
+		if !bExists || b == nil {
+			return true
+		}
+		if descending {
+			a, b = b, a
+		}
+		return lessThan(a, b)
+	})
+}
+
+func lessThan(a, b any) bool {
+	aNum, aIsNum := getFloat64Equivalent(a)
+	bNum, bIsNum := getFloat64Equivalent(b)
+	if aIsNum && bIsNum {
+		return aNum < bNum
+	}
+
+	aStr, aIsStr := a.(string)
+	bStr, bIsStr := b.(string)
+	if aIsStr && bIsStr {
+		return aStr < bStr
+	}
+
+	return false
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresReplica is a pool of read only connections to a replica
+// of the primary
+type postgresReplica struct {
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// makePostgresReplica makes the pool of the replica at replicaUrl,
+// it connects on first use
+func makePostgresReplica(replicaUrl, database, schema string) (*postgresReplica, error) {
+	replicaUrl, err := completeReplicaUrl(replicaUrl, database)
+	if err != nil {
+		return nil, err
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(replicaUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres replica url: %w", err)
+	}
+
+	if schema != "" {
+		poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			_, err := conn.Exec(ctx, fmt.Sprintf(`SET search_path TO "%s";`, schema))
+			return err
+		}
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres replica url: %w", err)
+	}
+	return &postgresReplica{pool: pool}, nil
+}
+
+// checkHealth marks replica down while it cannot be reached, the
+// pool replaces the connections it lost
+func (replica *postgresReplica) checkHealth() {
+	err := replica.pool.Ping(context.Background())
+	if err == nil {
+		replica.healthy.Store(true)
+		return
+	}
+
+	if replica.healthy.Swap(false) {
+		fmt.Fprintln(os.Stderr, "postgresReplica: replica is down:", err.Error())
+	}
+}
+
+func (replica *postgresReplica) read(stmt string, args ...any) ([]map[string]any, error) {
+	rows, err := replica.pool.Query(context.Background(), stmt, args...)
+	if err == nil {
+		var records []map[string]any
+		records, err = pgx.CollectRows(rows, pgx.RowToMap)
+		if err == nil {
+			return records, nil
+		}
+	}
+
+	// errors raised by the server leave the replica usable
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		replica.healthy.Store(false)
+	}
+	return nil, err
+}
+
+func (replica *postgresReplica) close() {
+	replica.pool.Close()
+	replica.healthy.Store(false)
+}
+
+// postgresReplicas routes the reads of a PostgresEngine to its
+// healthy replicas in turn. A nil *postgresReplicas has no
+// replicas, so every read goes to the primary
+type postgresReplicas struct {
+	replicas []*postgresReplica
+	next     atomic.Uint64
+	// lastWrites holds the unix milli time of the last write of
+	// each session, see PostgresEngine.Session
+	lastWrites        sync.Map
+	readYourWrites    time.Duration
+	stopHealthChecker func()
+}
+
+func makePostgresReplicas(urls []string, database, schema string, healthCheckInterval, readYourWrites time.Duration) (*postgresReplicas, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	replicas := &postgresReplicas{readYourWrites: readYourWrites}
+	for _, replicaUrl := range urls {
+		replica, err := makePostgresReplica(replicaUrl, database, schema)
+		if err != nil {
+			for _, made := range replicas.replicas {
+				made.close()
+			}
+			return nil, err
+		}
+		replicas.replicas = append(replicas.replicas, replica)
+	}
+
+	// replicas down now are picked up by the health checker
+	// once they recover
+	replicas.checkHealth()
+	replicas.stopHealthChecker = replicas.startHealthChecker(healthCheckInterval)
+	return replicas, nil
+}
+
+// completeReplicaUrl adds database to replicaUrl if it names none
+func completeReplicaUrl(replicaUrl, database string) (string, error) {
+	parsed, err := url.Parse(replicaUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid postgres replica url: %w", err)
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		parsed.Path = "/" + database
+	}
+	return parsed.String(), nil
+}
+
+func (replicas *postgresReplicas) checkHealth() {
+	for _, replica := range replicas.replicas {
+		replica.checkHealth()
+	}
+}
+
+func (replicas *postgresReplicas) startHealthChecker(interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				replicas.checkHealth()
+				replicas.forgetOldWrites()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// pick returns the next healthy replica, or nil if the reads of
+// session must go to the primary: there is no healthy replica, or
+// session made a write less than readYourWrites ago
+func (replicas *postgresReplicas) pick(session string) *postgresReplica {
+	if replicas == nil {
+		return nil
+	}
+
+	if replicas.wroteRecently(session) {
+		return nil
+	}
+
+	count := uint64(len(replicas.replicas))
+	start := replicas.next.Add(1)
+	for i := uint64(0); i < count; i++ {
+		replica := replicas.replicas[(start+i)%count]
+		if replica.healthy.Load() {
+			return replica
+		}
+	}
+	return nil
+}
+
+// wrote records that session sent a write to the primary
+func (replicas *postgresReplicas) wrote(session string) {
+	if replicas != nil && session != "" && replicas.readYourWrites > 0 {
+		replicas.lastWrites.Store(session, time.Now().UnixMilli())
+	}
+}
+
+func (replicas *postgresReplicas) wroteRecently(session string) bool {
+	if session == "" || replicas.readYourWrites <= 0 {
+		return false
+	}
+	lastWrite, found := replicas.lastWrites.Load(session)
+	return found && time.Since(time.UnixMilli(lastWrite.(int64))) < replicas.readYourWrites
+}
+
+// forgetOldWrites drops the writes made more than readYourWrites
+// ago, they no longer pin their session to the primary
+func (replicas *postgresReplicas) forgetOldWrites() {
+	replicas.lastWrites.Range(func(session, lastWrite any) bool {
+		if time.Since(time.UnixMilli(lastWrite.(int64))) >= replicas.readYourWrites {
+			replicas.lastWrites.CompareAndDelete(session, lastWrite)
+		}
+		return true
+	})
+}
+
+// healthy returns the number of replicas currently read from
+func (replicas *postgresReplicas) healthy() int {
+	if replicas == nil {
+		return 0
+	}
+
+	count := 0
+	for _, replica := range replicas.replicas {
+		if replica.healthy.Load() {
+			count++
+		}
+	}
+	return count
+}
+
+func (replicas *postgresReplicas) close() {
+	if replicas == nil {
+		return
+	}
+
+	replicas.stopHealthChecker()
+	for _, replica := range replicas.replicas {
+		replica.close()
+	}
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// SearchResult is a record matching a search. Records with a
+// higher Score match it better
+type SearchResult struct {
+	ID     string
+	Score  float64
+	Record map[string]any
+}
+
+// SearchHit is a SearchResult of a Storage, built into a T
+type SearchHit[T any] struct {
+	ID    string
+	Score float64
+	Data  T
+}
+
+// name under which engines return the rank of a record
+const SEARCH_SCORE_FIELD = "_searchScore"
+
+// prefixes of a term weigh less than the term itself, so that
+// jo finds john but ranks jo first
+const SEARCH_PREFIX_WEIGHT = 0.5
+
+// tokenize lowercases text and splits it into its runs of
+// letters and digits, e.g. John.Doe@mail.com into john, doe,
+// mail and com
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func validateSearchFields(fields []string) error {
+	if len(fields) == 0 {
+		return newValidationError("fields", "required", "must name the fields to search")
+	}
+	for _, field := range fields {
+		if field == "" || strings.ContainsAny(field, `"'.$`) {
+			return newValidationError("fields", "format", "has an invalid field "+field)
+		}
+	}
+	return nil
+}
+
+// CreateSearchIndex indexes fields of the records of engine for
+// Search, see PostgresEngine.CreateSearchIndex and
+// MongoWrapper.CreateSearchIndex. FileDb indexes its records in
+// memory on first search
+func CreateSearchIndex(engine DB_Engine, fields ...string) error {
+	switch engine := engine.(type) {
+	case *PostgresEngine:
+		return engine.CreateSearchIndex(fields...)
+	case *MongoWrapper:
+		return engine.CreateSearchIndex(fields...)
+	case *CachedEngine:
+		return CreateSearchIndex(engine.Engine(), fields...)
+	}
+	return nil
+}
+
+// searchIndex is an inverted index of the string fields of records
+type searchIndex struct {
+	// postings[token][id][field] is the number of times
+	// token occurs in field of the record with id
+	postings map[string]map[string]map[string]int
+	// sorted tokens, to find the ones a term prefixes
+	tokens []string
+	// tokensOf[id] are the tokens of the record with id, to
+	// remove its postings once it changes
+	tokensOf map[string][]string
+	records  map[string]map[string]any
+}
+
+func buildSearchIndex(records map[string]map[string]any) *searchIndex {
+	index := &searchIndex{
+		postings: map[string]map[string]map[string]int{},
+		tokensOf: map[string][]string{},
+		records:  map[string]map[string]any{},
+	}
+
+	for id, record := range records {
+		index.tokens = append(index.tokens, index.addPostings(id, record)...)
+	}
+	sort.Strings(index.tokens)
+	return index
+}
+
+// addPostings indexes record under id and returns the
+// tokens it added to the index
+func (index *searchIndex) addPostings(id string, record map[string]any) []string {
+	newTokens := []string{}
+	index.records[id] = record
+
+	for field, value := range record {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		for _, token := range tokenize(text) {
+			if index.postings[token] == nil {
+				index.postings[token] = map[string]map[string]int{}
+				newTokens = append(newTokens, token)
+			}
+			if index.postings[token][id] == nil {
+				index.postings[token][id] = map[string]int{}
+				index.tokensOf[id] = append(index.tokensOf[id], token)
+			}
+			index.postings[token][id][field]++
+		}
+	}
+	return newTokens
+}
+
+// update replaces the postings of the record with id by those
+// of record, nil removes the record from the index
+func (index *searchIndex) update(id string, record map[string]any) {
+	index.remove(id)
+	if record == nil {
+		return
+	}
+
+	for _, token := range index.addPostings(id, record) {
+		i := sort.SearchStrings(index.tokens, token)
+		index.tokens = slices.Insert(index.tokens, i, token)
+	}
+}
+
+func (index *searchIndex) remove(id string) {
+	for _, token := range index.tokensOf[id] {
+		delete(index.postings[token], id)
+		if len(index.postings[token]) == 0 {
+			delete(index.postings, token)
+			i := sort.SearchStrings(index.tokens, token)
+			index.tokens = slices.Delete(index.tokens, i, i+1)
+		}
+	}
+	delete(index.tokensOf, id)
+	delete(index.records, id)
+}
+
+// search ranks the records whose fields contain every term of
+// query, or a token the term prefixes, by tf-idf
+func (index *searchIndex) search(query string, fields []string, limit int) []SearchResult {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var scores map[string]float64
+	for _, term := range terms {
+		termScores := index.scoreTerm(term, fields)
+
+		// records must match every term
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id := range scores {
+			if termScore, matched := termScores[id]; matched {
+				scores[id] += termScore
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, SearchResult{ID: id, Score: score, Record: index.records[id]})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (index *searchIndex) scoreTerm(term string, fields []string) map[string]float64 {
+	scores := map[string]float64{}
+
+	start := sort.SearchStrings(index.tokens, term)
+	for _, token := range index.tokens[start:] {
+		if !strings.HasPrefix(token, term) {
+			break
+		}
+
+		weight := 1.0
+		if token != term {
+			weight = SEARCH_PREFIX_WEIGHT
+		}
+
+		postings := index.postings[token]
+		idf := math.Log(1 + float64(len(index.records))/float64(len(postings)))
+		for id, fieldCounts := range postings {
+			occurrences := 0
+			for _, field := range fields {
+				occurrences += fieldCounts[field]
+			}
+			if occurrences > 0 {
+				scores[id] += weight * float64(occurrences) * idf
+			}
+		}
+	}
+
+	return scores
+}
+
+// lazySearchIndex is a searchIndex built on first use after it
+// is reset. Once built, it is kept up to date by update
+type lazySearchIndex struct {
+	mu    sync.Mutex
+	index *searchIndex
+}
+
+func (lazy *lazySearchIndex) get(records func() map[string]map[string]any) *searchIndex {
+	lazy.mu.Lock()
+	defer lazy.mu.Unlock()
+
+	if lazy.index == nil {
+		lazy.index = buildSearchIndex(records())
+	}
+	return lazy.index
+}
+
+// update replaces the indexed record with id by record, or
+// removes it if record is nil. An index not built is left so
+func (lazy *lazySearchIndex) update(id string, record map[string]any) {
+	lazy.mu.Lock()
+	defer lazy.mu.Unlock()
+
+	if lazy.index != nil {
+		lazy.index.update(id, record)
+	}
+}
+
+func (lazy *lazySearchIndex) reset() {
+	lazy.mu.Lock()
+	defer lazy.mu.Unlock()
+	lazy.index = nil
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+type deletedRecordsPurger interface {
+	PurgeDeleted(olderThan time.Duration) (int, error)
+}
+
+// StartPurgingDeleted runs purger.PurgeDeleted(retention) every
+// interval in the background until the returned stop is called.
+// purger is any DB_Engine or Storage
+func StartPurgingDeleted(purger deletedRecordsPurger, retention, interval time.Duration) (stop func()) {
+	return runEvery(interval, func() {
+		_, err := purger.PurgeDeleted(retention)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "StartPurgingDeleted:", err.Error())
+		}
+	})
+}
+
+// StartPurgingDeletedOfTenants is StartPurgingDeleted for the
+// records of every tenant of storage, the tenants used after it
+// started included, see Storage.Tenants
+func StartPurgingDeletedOfTenants[T any](storage Storage[T], retention, interval time.Duration) (stop func()) {
+	return runEvery(interval, func() {
+		for _, tenantStorage := range storage.Tenants() {
+			_, err := tenantStorage.PurgeDeleted(retention)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "StartPurgingDeletedOfTenants:", err.Error())
+			}
+		}
+	})
+}
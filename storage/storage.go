@@ -1,41 +1,197 @@
 package storage
 
+import (
+	"io"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/config"
+)
+
 type UpdateDesc struct {
 	Field string
 	Value any
 }
 
-// Every table or collection must implement the Storage interface
+// Every table or collection must implement the Storage interface.
+// Errors returned wrap ErrNotFound, ErrDuplicate, ErrValidation,
+// ErrConflict or ErrBackend, see errors.go
 type Storage[T any] interface {
 	Get(id string) (T, error)
-	Save(data T) (msg string, success bool)
-	Update(id string, data UpdateDesc) bool
-	Delete(id string)
+	Save(data T) (id string, err error)
+	// SaveWithOptions is Save with options, e.g. a TTL after
+	// which the record expires
+	SaveWithOptions(data T, options SaveOptions) (id string, err error)
+	Update(id string, data UpdateDesc) error
+	// UpdateIfVersion updates the record only if its version is
+	// expectedVersion, else it returns a *VersionConflictError
+	UpdateIfVersion(id string, data UpdateDesc, expectedVersion int) error
+	// Upsert saves data if no record has value at field, else it
+	// updates the matching record with data. field "id" matches
+	// on the record id. created reports whether data was saved
+	Upsert(field string, value any, data T) (id string, created bool, err error)
+	Delete(id string) error
+	// Restore undoes a soft delete, see config.SoftDelete
+	Restore(id string) error
+	// PurgeDeleted erases records soft deleted more than olderThan ago
+	PurgeDeleted(olderThan time.Duration) (int, error)
 	GetByField(field string, value any) []T
 	GetIdByField(field string, value any) string
 	GetAll() []T
-	BuildClient(obj any) T
+	GetAllIncludingDeleted() []T
+	// Search returns the records whose fields match query, best
+	// match first, see DB_Engine.Search. No fields searches the
+	// default fields of T
+	Search(query string, fields []string, limit int) ([]SearchHit[T], error)
+	// Import saves the records read from r, see ImportRecords. Rows
+	// that fail are reported, the others are still saved unless
+	// options.DryRun is set
+	Import(r io.Reader, options ImportOptions) (ImportReport, error)
+	// Export writes every record to w, see ExportRecords
+	Export(w io.Writer, options ExportOptions) error
+	// BuildClient builds a T from a record, see GenericBuildClient
+	BuildClient(obj any) (T, error)
+	// Hooks returns the hooks run around this Storage's operations,
+	// they are shared by the Storages of every tenant
+	Hooks() *Hooks[T]
+	// ForTenant returns the Storage of the same records for tenant,
+	// which only reads and changes that tenant's records. "" is the
+	// default tenant, see ValidateTenant
+	ForTenant(tenant string) (Storage[T], error)
+	// Tenants returns the Storages of every tenant used so far in
+	// this process, through ForTenant or at setup
+	Tenants() []Storage[T]
+	// ForSession returns this Storage reading the writes made
+	// through the Storages of session, see SessionDB_Engine. The
+	// Storages it returns from ForTenant are of no session
+	ForSession(session string) Storage[T]
 }
 
+// DB_Engine errors wrap the same sentinels as Storage errors
 type DB_Engine interface {
 	Get(id string) (any, error)
 	Save(data any) (string, error)
+	// SaveWithOptions is Save with options. A record saved with a
+	// TTL is left out of all reads once expired, and later erased.
+	// Its expiresAt is maintained by the engine, Update rejects it
+	// and Upsert clears it, so upserted records never expire
+	SaveWithOptions(data any, options SaveOptions) (string, error)
 	// for document stores using noSql, Callers of this function
 	// must validate both fields passed in data else, unwanted fields
 	// may be added to the records on disc and values of
 	// an inappropriate type might be added, causing errors in
 	// rebuilding objects
-	Update(id string, data UpdateDesc) bool
-	Delete(id string)
+	Update(id string, data UpdateDesc) error
+	// UpdateIfVersion is Update for optimistic concurrency control, it
+	// updates the record only if its version, maintained when
+	// config.TrackRecordMetadata is true, is expectedVersion. Else
+	// it returns a *VersionConflictError
+	UpdateIfVersion(id string, data UpdateDesc, expectedVersion int) error
+	// Upsert inserts data as a new record if no record has value
+	// at field, else it sets data's fields on the matching record,
+	// atomically. Use "id" as field to upsert by record id.
+	// created reports whether a new record was inserted
+	Upsert(field string, value any, data any) (id string, created bool, err error)
+	// Delete erases the record, or marks it with a deletedAt
+	// timestamp if config.SoftDelete was true when the engine was
+	// made. Soft deleted records are left out of all reads
+	Delete(id string) error
+	Restore(id string) error
+	// GetDeleted returns the soft deleted record with id, or
+	// ErrNotFound if it is missing or not deleted
+	GetDeleted(id string) (any, error)
+	// PurgeDeleted erases records soft deleted more than olderThan
+	// ago and returns how many were erased
+	PurgeDeleted(olderThan time.Duration) (int, error)
 	// if FileDb is the Engine, field is the json tag if it
 	// is defined on the obj
 	GetRecordsByField(field string, value any) ([]map[string]any, error)
 	GetIdByFieldAndValue(field string, value any) string
 	GetAllOfRecords() []map[string]any
+	GetAllOfRecordsIncludingDeleted() []map[string]any
+	// Search returns at most limit, if above 0, of the records
+	// whose fields contain every term of query, best match first.
+	// Postgres and FileDb also match words a term is the start of,
+	// mongo only whole words. Postgres and mongo search the fields
+	// indexed with CreateSearchIndex, mongo cannot search without
+	// such an index
+	Search(query string, fields []string, limit int) ([]SearchResult, error)
+	// Watch streams the changes made to records that match filter
+	// until stop is called, after which the channel is closed
+	Watch(filter WatchFilter) (events <-chan ChangeEvent, stop func(), err error)
 	Commit() error
 }
 
+// GetDB_Engine returns the engine of engine_dbms for the records
+// recordsName of database, wrapped in a *CachedEngine if a TTL is
+// set for recordsName in config.CacheTTLs
 func GetDB_Engine(engine_dbms, database, recordsName string, fieldAndDesc ...SQL_TABLE_COLUMN_FIELD_AND_DESC) (DB_Engine, error) {
+	return GetTenantDB_Engine(engine_dbms, database, recordsName, "", fieldAndDesc...)
+}
+
+// SessionDB_Engine returns engine for session, e.g. a login session
+// or a request id. Engines reading from replicas read from the
+// primary for a while after a write of session, see
+// PostgresEngine.Session, others are returned as is
+func SessionDB_Engine(engine DB_Engine, session string) DB_Engine {
+	switch engine := engine.(type) {
+	case *PostgresEngine:
+		return engine.Session(session)
+	case *CachedEngine:
+		return engine.Session(session)
+	}
+	return engine
+}
+
+// primaryReadsDB_Engine returns engine reading from its primary
+// only, see PostgresEngine.PrimaryReads, and how long after a write
+// its sessions read from the primary. It returns nil for engines
+// without replicas
+func primaryReadsDB_Engine(engine DB_Engine) (DB_Engine, time.Duration) {
+	switch engine := engine.(type) {
+	case *PostgresEngine:
+		if engine.replicas != nil && engine.replicas.readYourWrites > 0 {
+			return engine.PrimaryReads(), engine.replicas.readYourWrites
+		}
+	}
+	return nil, 0
+}
+
+// CreateUniqueIndex makes field unique among the records of engine,
+// as Upsert on field needs, see PostgresEngine.CreateUniqueIndex.
+// Other engines check upserted fields without an index
+func CreateUniqueIndex(engine DB_Engine, field string) error {
+	switch engine := engine.(type) {
+	case *PostgresEngine:
+		return engine.CreateUniqueIndex(field)
+	case *CachedEngine:
+		return CreateUniqueIndex(engine.Engine(), field)
+	}
+	return nil
+}
+
+// cacheEngine wraps engine in a *CachedEngine if a TTL is set
+// for recordsName in config.CacheTTLs
+func cacheEngine(engine DB_Engine, database, recordsName string) DB_Engine {
+	ttl := config.CacheTTLs[recordsName]
+	if ttl <= 0 {
+		return engine
+	}
+
+	storeType := config.TempStoreType
+	if storeType != "redis" {
+		// the file store only drops expired keys on reload, so the keys
+		// orphaned by every write would grow its file without bound
+		storeType = "memory"
+	}
+	store := GET_TempStore(storeType, config.CacheDb, CACHE_RECORDS_NAME)
+	options := CacheOptions{TTL: ttl, NegativeTTL: config.CacheNegativeTTL}
+	return MakeCachedEngine(engine, store, database, recordsName, options)
+}
+
+// name of the records the temp store keeps the cache in
+const CACHE_RECORDS_NAME = "cache"
+
+func getUncachedDB_Engine(engine_dbms, database, recordsName string, fieldAndDesc ...SQL_TABLE_COLUMN_FIELD_AND_DESC) (DB_Engine, error) {
 	switch engine_dbms {
 	case "postgres":
 		return MakePostgresEngine(database, recordsName, fieldAndDesc...)
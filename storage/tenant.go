@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// tenants are used in file paths, database and schema names
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,63}$`)
+
+// ValidateTenant returns a *ValidationError if tenant cannot name
+// a tenant. "" is the default tenant, whose records are those
+// stored without tenancy
+func ValidateTenant(tenant string) error {
+	if tenant != "" && !tenantPattern.MatchString(tenant) {
+		return newValidationError("tenant", "format",
+			"must be at most 63 letters, digits, _ or -")
+	}
+	return nil
+}
+
+// TenantDatabase returns the database holding the records of
+// tenant for engine_dbms: a file next to database for FileDb and
+// a database of its own for mongo. On postgres tenants share the
+// database and get a schema of their own instead
+func TenantDatabase(engine_dbms, database, tenant string) string {
+	if tenant == "" {
+		return database
+	}
+
+	switch engine_dbms {
+	case "postgres":
+		return database
+	case "mongo", "mongodb":
+		return database + "_" + tenant
+	default:
+		ext := filepath.Ext(database)
+		return strings.TrimSuffix(database, ext) + "_" + tenant + ext
+	}
+}
+
+// GetTenantDB_Engine is GetDB_Engine for the records of tenant,
+// which no engine of another tenant can read or change
+func GetTenantDB_Engine(engine_dbms, database, recordsName, tenant string, fieldAndDesc ...SQL_TABLE_COLUMN_FIELD_AND_DESC) (DB_Engine, error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	var engine DB_Engine
+	var err error
+	switch engine_dbms {
+	case "postgres":
+		engine, err = MakePostgresEngineInSchema(database, tenant, recordsName, fieldAndDesc...)
+	default:
+		engine, err = getUncachedDB_Engine(engine_dbms,
+			TenantDatabase(engine_dbms, database, tenant), recordsName, fieldAndDesc...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cacheDatabase := database
+	if tenant != "" {
+		cacheDatabase += "/" + tenant
+	}
+	return cacheEngine(engine, cacheDatabase, recordsName), nil
+}
+
+// tenantStorages hands out the Storage of each tenant of a
+// records, made by makeStorage on first use
+type tenantStorages[T any] struct {
+	mu          sync.Mutex
+	makeStorage func(tenant string) (Storage[T], error)
+	storages    map[string]Storage[T]
+}
+
+// all returns the Storages made so far, the default tenant's included
+func (tenants *tenantStorages[T]) all() []Storage[T] {
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+
+	storages := make([]Storage[T], 0, len(tenants.storages))
+	for _, storage := range tenants.storages {
+		storages = append(storages, storage)
+	}
+	return storages
+}
+
+func (tenants *tenantStorages[T]) get(tenant string) (Storage[T], error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+
+	if storage, exists := tenants.storages[tenant]; exists {
+		return storage, nil
+	}
+
+	storage, err := tenants.makeStorage(tenant)
+	if err != nil {
+		return nil, err
+	}
+	tenants.storages[tenant] = storage
+	return storage, nil
+}
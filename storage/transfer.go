@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// formats records are imported from and exported to
+type DataFormat string
+
+const (
+	// comma separated values, with a header row naming the fields
+	CSV_FORMAT DataFormat = "csv"
+	// JSON Lines, one json object per line
+	JSONL_FORMAT DataFormat = "jsonl"
+)
+
+type ImportOptions struct {
+	Format DataFormat
+	// DryRun checks every row as it would be imported, reporting
+	// the failures, without saving any
+	DryRun bool
+}
+
+type ExportOptions struct {
+	Format DataFormat
+	// the fields exported, in order, by json name. All the fields
+	// of the records, but the sensitive ones, if empty
+	Fields []string
+	// IncludeSensitive exports the fields a Storage keeps out of
+	// exports, e.g. password hashes, it must be set to name them
+	// in Fields
+	IncludeSensitive bool
+}
+
+// RowError is the reason a row failed to import. Row is the line
+// the row starts on, the CSV header being line 1
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (err RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", err.Row, err.Err.Error())
+}
+
+func (err RowError) Unwrap() error {
+	return err.Err
+}
+
+// ImportReport sums up an import. Rows counts the rows read,
+// Imported the ones saved, or that would be on a dry run, and
+// Failed lists why the others were not
+type ImportReport struct {
+	Rows     int
+	Imported int
+	Failed   []RowError
+}
+
+// transferField is a field of the records of a Storage, named
+// as encoding/json names it
+type transferField struct {
+	name string
+	typ  reflect.Type
+}
+
+// transferFieldsOf returns the fields of typ, a struct, in order.
+// Fields of embedded structs without a json name are its own
+func transferFieldsOf(typ reflect.Type) []transferField {
+	fields := []transferField{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if tag == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				fields = append(fields, transferFieldsOf(embedded)...)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, transferField{name: name, typ: field.Type})
+	}
+	return fields
+}
+
+// normalizeHeader folds the spelling of a column header, so that
+// e.g. "First Name", first_name and firstName name the same field
+func normalizeHeader(header string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(header)))
+}
+
+// matchField returns the field of fields header names
+func matchField(fields []transferField, header string) (transferField, bool) {
+	normalized := normalizeHeader(header)
+	for _, field := range fields {
+		if normalizeHeader(field.name) == normalized {
+			return field, true
+		}
+	}
+	return transferField{}, false
+}
+
+// ImportRecords reads the rows of r, in format, builds each into
+// a T and hands it to importRow, which saves or checks it. Columns
+// and keys are matched to the json names of T's fields, regardless
+// of case, spaces, _ and -. Values are coerced to the type of their
+// field. A row failing to build, or rejected by importRow, is
+// reported and the import goes on. The returned error is set only
+// if r cannot be read as format, or its header names an unknown field
+func ImportRecords[T any](r io.Reader, format DataFormat, importRow func(T) error) (ImportReport, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return ImportReport{}, fmt.Errorf("ImportRecords: %s is not a struct", typ)
+	}
+	fields := transferFieldsOf(typ)
+
+	report := ImportReport{}
+	importMapRep := func(row int, mapRep map[string]any, err error) {
+		report.Rows++
+		var record T
+		if err == nil {
+			record, err = GenericBuildClient[T](mapRep)
+		}
+		if err == nil {
+			err = importRow(record)
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, RowError{Row: row, Err: err})
+			return
+		}
+		report.Imported++
+	}
+
+	switch format {
+	case CSV_FORMAT:
+		return report, readCSVRows(r, fields, importMapRep)
+	case JSONL_FORMAT:
+		return report, readJSONLRows(r, fields, importMapRep)
+	default:
+		return report, unknownFormatError(format)
+	}
+}
+
+func unknownFormatError(format DataFormat) error {
+	return newValidationError("format", "oneof",
+		fmt.Sprintf("must be %s or %s, got %q", CSV_FORMAT, JSONL_FORMAT, format))
+}
+
+func readCSVRows(r io.Reader, fields []transferField, importMapRep func(int, map[string]any, error)) error {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	headers, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return newValidationError("header", "format", err.Error())
+	}
+
+	columns := make([]transferField, len(headers))
+	for i, header := range headers {
+		field, exists := matchField(fields, header)
+		if !exists {
+			return newValidationError(header, "unknown", "matches no field")
+		}
+		columns[i] = field
+	}
+
+	for {
+		cells, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return err
+		}
+
+		if parseErr != nil {
+			importMapRep(parseErr.StartLine, nil, newValidationError("row", "format", parseErr.Err.Error()))
+			continue
+		}
+
+		row, _ := reader.FieldPos(0)
+		importMapRep(row, csvMapRep(columns, cells), nil)
+	}
+}
+
+// csvMapRep builds the map rep of a row from its cells. Empty cells
+// are left out, cells of objects and lists are read as json
+func csvMapRep(columns []transferField, cells []string) map[string]any {
+	mapRep := map[string]any{}
+	for i, cell := range cells {
+		if cell == "" {
+			continue
+		}
+
+		field := columns[i]
+		kind := field.typ.Kind()
+		if kind == reflect.Pointer {
+			kind = field.typ.Elem().Kind()
+		}
+
+		switch kind {
+		case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+			var value any
+			if err := json.Unmarshal([]byte(cell), &value); err != nil {
+				// e.g. a time.Time or []byte, decoded from a string
+				mapRep[field.name] = cell
+				continue
+			}
+			mapRep[field.name] = value
+		default:
+			mapRep[field.name] = cell
+		}
+	}
+	return mapRep
+}
+
+func readJSONLRows(r io.Reader, fields []transferField, importMapRep func(int, map[string]any, error)) error {
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		content, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if len(bytes.TrimSpace(content)) > 0 {
+			mapRep, rowErr := jsonlMapRep(fields, content)
+			importMapRep(line, mapRep, rowErr)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+func jsonlMapRep(fields []transferField, content []byte) (map[string]any, error) {
+	var decoded map[string]any
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return nil, newValidationError("row", "format", "is not a json object: "+err.Error())
+	}
+
+	mapRep := map[string]any{}
+	for key, value := range decoded {
+		field, exists := matchField(fields, key)
+		if !exists {
+			return nil, newValidationError(key, "unknown", "matches no field")
+		}
+		mapRep[field.name] = value
+	}
+	return mapRep, nil
+}
+
+// ExportRecords writes records to w in options.Format. Fields in
+// sensitive are left out unless options.IncludeSensitive is set
+func ExportRecords[T any](w io.Writer, records []T, options ExportOptions, sensitive []string) error {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("ExportRecords: %s is not a struct", typ)
+	}
+
+	names, err := exportedFieldNames(transferFieldsOf(typ), options, sensitive)
+	if err != nil {
+		return err
+	}
+
+	switch options.Format {
+	case CSV_FORMAT:
+		return writeCSVRows(w, records, names)
+	case JSONL_FORMAT:
+		return writeJSONLRows(w, records, names)
+	default:
+		return unknownFormatError(options.Format)
+	}
+}
+
+func exportedFieldNames(fields []transferField, options ExportOptions, sensitive []string) ([]string, error) {
+	if len(options.Fields) == 0 {
+		names := []string{}
+		for _, field := range fields {
+			if options.IncludeSensitive || !slices.Contains(sensitive, field.name) {
+				names = append(names, field.name)
+			}
+		}
+		return names, nil
+	}
+
+	names := []string{}
+	for _, name := range options.Fields {
+		field, exists := matchField(fields, name)
+		if !exists {
+			return nil, newValidationError(name, "unknown", "matches no field")
+		}
+		if !options.IncludeSensitive && slices.Contains(sensitive, field.name) {
+			return nil, newValidationError(field.name, "sensitive",
+				"is only exported if IncludeSensitive is set")
+		}
+		names = append(names, field.name)
+	}
+	return names, nil
+}
+
+func writeCSVRows[T any](w io.Writer, records []T, names []string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(names); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		mapRep, err := getMapRep(record)
+		if err != nil {
+			return err
+		}
+
+		cells := make([]string, len(names))
+		for i, name := range names {
+			cells[i], err = csvCell(mapRep[name])
+			if err != nil {
+				return err
+			}
+		}
+		if err := writer.Write(cells); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvCell formats a value of a map rep as a cell, objects and
+// lists as json
+func csvCell(value any) (string, error) {
+	switch val := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return val, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	default:
+		encoded, err := json.Marshal(val)
+		return string(encoded), err
+	}
+}
+
+func writeJSONLRows[T any](w io.Writer, records []T, names []string) error {
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		mapRep, err := getMapRep(record)
+		if err != nil {
+			return err
+		}
+
+		exported := map[string]any{}
+		for _, name := range names {
+			if value, exists := mapRep[name]; exists {
+				exported[name] = value
+			}
+		}
+		if err := encoder.Encode(exported); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,27 +1,28 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
 )
 
-func GenericBuildClient[T any](objDesc any) T {
-
-	// after recovery, zero value of enclosing function
-	// will be returned
-	defer RecoverFromPanic()
-
+// GenericBuildClient builds a T from objDesc, a record as returned
+// by a DB_Engine, see decoder for how values are coerced. If some
+// fields cannot be set, the T is returned with the other fields set
+// along with a *ValidationError listing the fields that failed
+func GenericBuildClient[T any](objDesc any) (T, error) {
 	var obj T
-	if map_rep, ok := objDesc.(map[string]any); ok {
-
-		jsonKeyToStructField := GetJsonKeyToStructField(obj)
-		for key, val := range map_rep {
-			SetProperty(&obj, jsonKeyToStructField[key], val)
-		}
+	var d decoder
 
+	dst := reflect.ValueOf(&obj).Elem()
+	if map_rep, ok := objDesc.(map[string]any); ok && dst.Kind() == reflect.Struct {
+		d.decodeStruct(dst, map_rep, "")
+	} else {
+		d.decode(dst, objDesc, "")
 	}
-	return obj
+
+	return obj, d.err()
 }
 
 func GetJsonKeyToStructField(obj any) map[string]string {
@@ -36,30 +37,60 @@ func GetJsonKeyToStructField(obj any) map[string]string {
 	return res
 }
 
-func SetProperty(obj any, propName string, propValue any) {
+// SetProperty sets the field named propName of the struct obj
+// points to, coercing propValue to the field's type
+func SetProperty(obj any, propName string, propValue any) error {
 	field := reflect.ValueOf(obj).Elem().FieldByName(propName)
+	if !field.IsValid() || !field.CanSet() {
+		return newValidationError(propName, "unknown", "is not a settable field")
+	}
+
+	var d decoder
+	d.decode(field, propValue, propName)
+	return d.err()
+}
 
-	defer RecoverFromPanic()
+// copyRecord returns a deep copy of record, a record as stored,
+// so that callers never share the maps and slices of the store
+func copyRecord(record map[string]any) map[string]any {
+	if record == nil {
+		return nil
+	}
+	copied := make(map[string]any, len(record))
+	for key, val := range record {
+		copied[key] = copyValue(val)
+	}
+	return copied
+}
+
+func copyValue(val any) any {
+	switch val := val.(type) {
+	case map[string]any:
+		return copyRecord(val)
+	case []any:
+		copied := make([]any, len(val))
+		for i, elem := range val {
+			copied[i] = copyValue(elem)
+		}
+		return copied
+	}
+	return val
+}
+
+// getMapRep returns the map rep of obj as it would be
+// stored, keyed by json tags
+func getMapRep(obj any) (map[string]any, error) {
+	json_rep, err := json.Marshal(obj) // test if it can be jsoned
+	if err != nil {
+		return nil, err
+	}
 
-	numVal, _ := getFloat64Equivalent(propValue)
-	switch field.Kind() {
-	case reflect.Int:
-		field.Set(reflect.ValueOf(int(numVal)))
-	case reflect.Int8:
-		field.Set(reflect.ValueOf(int8(numVal)))
-	case reflect.Int16:
-		field.Set(reflect.ValueOf(int16(numVal)))
-	case reflect.Int32:
-		field.Set(reflect.ValueOf(int32(numVal)))
-	case reflect.Int64:
-		field.Set(reflect.ValueOf(int64(numVal)))
-	case reflect.Float32:
-		field.Set(reflect.ValueOf(float32(numVal)))
-	case reflect.Float64:
-		field.Set(reflect.ValueOf(float64(numVal)))
-	default:
-		field.Set(reflect.ValueOf(propValue))
+	var mapRep map[string]any
+	err = json.Unmarshal(json_rep, &mapRep)
+	if err != nil {
+		return nil, err
 	}
+	return mapRep, nil
 }
 
 func RecoverFromPanic() {
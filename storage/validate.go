@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidatorFunc checks value, a field's value, against param, the
+// part of its rule after =, e.g. 8 for min=8. The error returned
+// says why value is invalid, e.g. "must be at least 8 characters"
+type ValidatorFunc func(value any, param string) error
+
+var validatorsMu sync.RWMutex
+var validators = map[string]ValidatorFunc{
+	"email": validateEmail,
+	"min":   validateMin,
+	"max":   validateMax,
+	"len":   validateLen,
+	"oneof": validateOneOf,
+	"regex": validateRegex,
+}
+
+// RegisterValidator makes fn available as rule name in validate
+// tags, replacing the rule of that name if any
+func RegisterValidator(name string, fn ValidatorFunc) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators[name] = fn
+}
+
+func getValidator(name string) ValidatorFunc {
+	validatorsMu.RLock()
+	defer validatorsMu.RUnlock()
+	return validators[name]
+}
+
+// Validate checks the fields of obj, a struct or a pointer to one,
+// against the rules in their validate tags, and returns a
+// *ValidationError listing every rule failed, or nil.
+//
+// Rules are comma separated, with a param after =, e.g.
+// validate:"required,email,max=128". regex must be the last rule
+// as its pattern may contain commas. Rules other than required are
+// not checked on zero values. Fields are reported by json key, and
+// the fields of nested structs as parent.child. A rule that is not
+// registered fails Validate with an error that is not ErrValidation
+func Validate(obj any) error {
+	var failures ValidationError
+
+	val := reflect.ValueOf(obj)
+	for val.Kind() == reflect.Pointer && !val.IsNil() {
+		val = val.Elem()
+	}
+
+	if val.Kind() == reflect.Struct {
+		if err := validateStruct(val, "", &failures); err != nil {
+			return err
+		}
+	}
+
+	return failures.orNil()
+}
+
+func validateStruct(val reflect.Value, path string, failures *ValidationError) error {
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if field.Anonymous && name == "" {
+			if err := validateNested(val.Field(i), path, failures); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+
+		fieldPath := joinPath(path, name)
+		if tag := field.Tag.Get("validate"); tag != "" && tag != "-" {
+			if err := validateField(val.Field(i), tag, fieldPath, failures); err != nil {
+				return err
+			}
+		}
+		if err := validateNested(val.Field(i), fieldPath, failures); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateNested validates the structs val holds, directly or
+// in a slice or array
+func validateNested(val reflect.Value, path string, failures *ValidationError) error {
+	for val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	switch val.Kind() {
+	case reflect.Struct:
+		return validateStruct(val, path, failures)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			err := validateNested(val.Index(i), fmt.Sprintf("%s[%d]", path, i), failures)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateField(val reflect.Value, tag, path string, failures *ValidationError) error {
+	isZero := val.IsZero()
+	for val.Kind() == reflect.Pointer && !val.IsNil() {
+		val = val.Elem()
+	}
+
+	for _, rule := range parseRules(tag) {
+		name, param, _ := strings.Cut(rule, "=")
+
+		if name == "required" {
+			if isZero {
+				failures.add(FieldError{Field: path, Rule: name, Message: "is required"})
+			}
+			continue
+		}
+
+		// looked up first, so that an unknown rule fails
+		// whether or not the field is set
+		validator := getValidator(name)
+		if validator == nil {
+			return fmt.Errorf("Validate: unknown validation rule %s on %s", name, path)
+		}
+
+		if isZero {
+			continue
+		}
+
+		if err := validator(val.Interface(), param); err != nil {
+			failures.add(FieldError{Field: path, Rule: name, Param: param, Message: err.Error()})
+		}
+	}
+	return nil
+}
+
+// parseRules splits tag into rules, the pattern of a
+// regex rule is the rest of the tag
+func parseRules(tag string) []string {
+	rules := []string{}
+	for tag != "" {
+		if strings.HasPrefix(tag, "regex=") {
+			return append(rules, tag)
+		}
+		rule, rest, _ := strings.Cut(tag, ",")
+		rules = append(rules, strings.TrimSpace(rule))
+		tag = strings.TrimSpace(rest)
+	}
+	return rules
+}
+
+// stringOf returns value if it is a string or of a string kind
+func stringOf(value any) (string, bool) {
+	val := reflect.ValueOf(value)
+	if val.Kind() != reflect.String {
+		return "", false
+	}
+	return val.String(), true
+}
+
+func validateEmail(value any, param string) error {
+	str, ok := stringOf(value)
+	if !ok {
+		return errors.New("must be a string")
+	}
+	address, err := mail.ParseAddress(str)
+	if err != nil || address.Address != str {
+		return errors.New("is not a valid email address")
+	}
+	return nil
+}
+
+// sizeOf returns the number of characters of a string, the
+// number of elements of a collection, or the value of a number
+func sizeOf(value any) (size float64, unit string, ok bool) {
+	if num, isNum := getFloat64Equivalent(value); isNum {
+		return num, "", true
+	}
+
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(val.String())), " characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(val.Len()), " elements", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), "", true
+	}
+	return 0, "", false
+}
+
+func compareSize(value any, param string, fails func(size, limit float64) bool, relation string) error {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return fmt.Errorf("has an invalid rule param %q", param)
+	}
+
+	size, unit, ok := sizeOf(value)
+	if !ok {
+		return errors.New("cannot be measured")
+	}
+
+	if fails(size, limit) {
+		return fmt.Errorf("must be %s %s%s", relation, param, unit)
+	}
+	return nil
+}
+
+func validateMin(value any, param string) error {
+	return compareSize(value, param, func(size, limit float64) bool { return size < limit }, "at least")
+}
+
+func validateMax(value any, param string) error {
+	return compareSize(value, param, func(size, limit float64) bool { return size > limit }, "at most")
+}
+
+func validateLen(value any, param string) error {
+	return compareSize(value, param, func(size, limit float64) bool { return size != limit }, "exactly")
+}
+
+// validateOneOf checks value is one of the space separated
+// options in param
+func validateOneOf(value any, param string) error {
+	options := strings.Fields(param)
+	if !slices.Contains(options, fmt.Sprint(value)) {
+		return fmt.Errorf("must be one of %s", strings.Join(options, ", "))
+	}
+	return nil
+}
+
+var compiledPatterns sync.Map
+
+func validateRegex(value any, param string) error {
+	str, ok := stringOf(value)
+	if !ok {
+		return errors.New("must be a string")
+	}
+
+	compiled, found := compiledPatterns.Load(param)
+	if !found {
+		pattern, err := regexp.Compile(param)
+		if err != nil {
+			return fmt.Errorf("has an invalid pattern %q", param)
+		}
+		compiled, _ = compiledPatterns.LoadOrStore(param, pattern)
+	}
+
+	if !compiled.(*regexp.Regexp).MatchString(str) {
+		return fmt.Errorf("must match %s", param)
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package tests
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"os"
 	"testing"
 
+	"github.com/Iyusuf40/goBackendUtils/api/controllers"
 	"github.com/Iyusuf40/goBackendUtils/auth"
 	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/Iyusuf40/goBackendUtils/models"
@@ -44,7 +48,7 @@ func TestHandleLogin(t *testing.T) {
 	defer afterEachAUTH_TEST()
 
 	email := "testmail@mail.com"
-	password := "xxx"
+	password := "xxxxxxxx"
 
 	user := models.User{
 		Email:     email,
@@ -54,10 +58,10 @@ func TestHandleLogin(t *testing.T) {
 		Password:  password,
 	}
 
-	_, success := AUTH_US.Save(user)
+	_, err := AUTH_US.Save(user)
 
-	if !success {
-		t.Fatal("TestHandleLogin: success should be true")
+	if err != nil {
+		t.Fatal("TestHandleLogin: save should succeed;", err)
 	}
 
 	// should get a session Token
@@ -83,7 +87,7 @@ func TestIsLoggedIn(t *testing.T) {
 	defer afterEachAUTH_TEST()
 
 	email := "testmail@mail.com"
-	password := "xxx"
+	password := "xxxxxxxx"
 
 	user := models.User{
 		Email:     email,
@@ -93,10 +97,10 @@ func TestIsLoggedIn(t *testing.T) {
 		Password:  password,
 	}
 
-	_, success := AUTH_US.Save(user)
+	_, err := AUTH_US.Save(user)
 
-	if !success {
-		t.Fatal("TestIsLoggedIn: success should be true")
+	if err != nil {
+		t.Fatal("TestIsLoggedIn: save should succeed;", err)
 	}
 
 	// should get a session Token
@@ -123,7 +127,7 @@ func TestHandleLogout(t *testing.T) {
 	defer afterEachAUTH_TEST()
 
 	email := "testmail@mail.com"
-	password := "xxx"
+	password := "xxxxxxxx"
 
 	user := models.User{
 		Email:     email,
@@ -133,10 +137,10 @@ func TestHandleLogout(t *testing.T) {
 		Password:  password,
 	}
 
-	_, success := AUTH_US.Save(user)
+	_, err := AUTH_US.Save(user)
 
-	if !success {
-		t.Fatal("TestHandleLogout: success should be true")
+	if err != nil {
+		t.Fatal("TestHandleLogout: save should succeed;", err)
 	}
 
 	// should get a session Token
@@ -171,7 +175,7 @@ func TestHandleForgotPassword(t *testing.T) {
 	}
 
 	email := "testmail@mail.com"
-	password := "xxx"
+	password := "xxxxxxxx"
 
 	user := models.User{
 		Email:     email,
@@ -181,10 +185,10 @@ func TestHandleForgotPassword(t *testing.T) {
 		Password:  password,
 	}
 
-	_, success := AUTH_US.Save(user)
+	_, err := AUTH_US.Save(user)
 
-	if !success {
-		t.Fatal("TestHandleForgotPassword: success should be true")
+	if err != nil {
+		t.Fatal("TestHandleForgotPassword: save should succeed;", err)
 	}
 
 	// test existing email
@@ -195,6 +199,68 @@ func TestHandleForgotPassword(t *testing.T) {
 	}
 }
 
+func TestHandleLogoutAll(t *testing.T) {
+	beforeEachAUTH_TEST()
+	defer afterEachAUTH_TEST()
+
+	AUTH_US.Save(models.User{Email: "first@mail.com", Password: "xxxxxxxx"})
+	AUTH_US.Save(models.User{Email: "second@mail.com", Password: "xxxxxxxx"})
+
+	firstSession := AUTH_HANDLER.HandleLogin("first@mail.com", "xxxxxxxx")
+	firstOtherSession := AUTH_HANDLER.HandleLogin("first@mail.com", "xxxxxxxx")
+	secondSession := AUTH_HANDLER.HandleLogin("second@mail.com", "xxxxxxxx")
+
+	if loggedOut := AUTH_HANDLER.HandleLogoutAll(firstSession); loggedOut != 2 {
+		t.Fatal("TestHandleLogoutAll: expected 2 sessions logged out, got", loggedOut)
+	}
+
+	if AUTH_HANDLER.IsLoggedIn(firstSession) || AUTH_HANDLER.IsLoggedIn(firstOtherSession) {
+		t.Fatal("TestHandleLogoutAll: expected every session of the user to be logged out")
+	}
+	if !AUTH_HANDLER.IsLoggedIn(secondSession) {
+		t.Fatal("TestHandleLogoutAll: expected the sessions of other users to be left")
+	}
+
+	if AUTH_HANDLER.HandleLogoutAll(firstSession) != 0 {
+		t.Fatal("TestHandleLogoutAll: expected an ended session to log out nothing")
+	}
+}
+
+func TestLegacySessions(t *testing.T) {
+	beforeEachAUTH_TEST()
+	defer afterEachAUTH_TEST()
+
+	id, _ := AUTH_US.Save(models.User{Email: "legacy@mail.com", Password: "xxxxxxxx"})
+	sessionId := AUTH_HANDLER.HandleLogin("legacy@mail.com", "xxxxxxxx")
+
+	// sessions and reset tokens made before they had a namespace
+	temp_store := storage.GET_TempStore(config.TempStoreType,
+		AuthHandler_tempDBstorage_test_db_path, Auth_Users_RecordsName)
+	temp_store.SetKeyToValWIthExpiry("legacy-session", controllers.MakeTenantValue("", id), 60)
+	temp_store.SetKeyToValWIthExpiry("legacy-token", controllers.MakeTenantValue("", id), 60)
+
+	if !AUTH_HANDLER.IsLoggedIn("legacy-session") {
+		t.Fatal("TestLegacySessions: expected a legacy session to be logged in")
+	}
+	if temp_store.GetVal("legacy-session") != "" {
+		t.Fatal("TestLegacySessions: expected the legacy session to be moved")
+	}
+
+	// test keys of other namespaces are not read as legacy sessions
+	if AUTH_HANDLER.IsLoggedIn(controllers.SESSIONS_NAMESPACE + ":" + sessionId) {
+		t.Fatal("TestLegacySessions: expected a namespaced key not to be a session")
+	}
+
+	// test the moved session is ended with the others of its user
+	if loggedOut := AUTH_HANDLER.HandleLogoutAll(sessionId); loggedOut != 2 {
+		t.Fatal("TestLegacySessions: expected 2 sessions logged out, got", loggedOut)
+	}
+
+	if !AUTH_HANDLER.HandleUpdatePassword("legacy-token", "new pass") {
+		t.Fatal("TestLegacySessions: expected a legacy reset token to reset the password")
+	}
+}
+
 func TestHandleResetPassword(t *testing.T) {
 	beforeEachAUTH_TEST()
 	defer afterEachAUTH_TEST()
@@ -208,7 +274,7 @@ func TestHandleResetPassword(t *testing.T) {
 	}
 
 	email := "testmail@mail.com"
-	password := "xxx"
+	password := "xxxxxxxx"
 
 	user := models.User{
 		Email:     email,
@@ -218,10 +284,10 @@ func TestHandleResetPassword(t *testing.T) {
 		Password:  password,
 	}
 
-	_, success := AUTH_US.Save(user)
+	_, err := AUTH_US.Save(user)
 
-	if !success {
-		t.Fatal("TestHandleUpdatePassword: success should be true")
+	if err != nil {
+		t.Fatal("TestHandleUpdatePassword: save should succeed;", err)
 	}
 
 	passwordResetToken := AUTH_HANDLER.HandleForgotPassword(email)
@@ -235,4 +301,88 @@ func TestHandleResetPassword(t *testing.T) {
 	if !passwordUpdateIsSuccessful {
 		t.Fatal("TestHandleUpdatePassword: passwordReset should be successful")
 	}
+
+	// test reset tokens are single use
+	if AUTH_HANDLER.HandleUpdatePassword(passwordResetToken, "another pass") {
+		t.Fatal("TestHandleUpdatePassword: passwordResetToken should only be usable once")
+	}
+}
+
+func TestHandleLoginTenant(t *testing.T) {
+	if config.DBMS != "file" {
+		t.Skip("TestHandleLoginTenant: cleans up file tenants only")
+	}
+
+	beforeEachAUTH_TEST()
+	defer afterEachAUTH_TEST()
+	defer afterEachTenant(AuthHandler_userstorage_test_db_path, Auth_Users_RecordsName, "acme")
+
+	email := "testmail@mail.com"
+	password := "xxxxxxxx"
+
+	acmeUsers, _ := AUTH_US.ForTenant("acme")
+	acmeUsers.Save(models.User{Email: email, Password: password})
+
+	acmeHandler, err := AUTH_HANDLER.ForTenant("acme")
+	if err != nil {
+		t.Fatal("TestHandleLoginTenant: expected no error got", err)
+	}
+
+	if AUTH_HANDLER.HandleLogin(email, password) != "" {
+		t.Fatal("TestHandleLoginTenant: user of acme should not login to the default tenant")
+	}
+
+	sessId := acmeHandler.HandleLogin(email, password)
+	if sessId == "" {
+		t.Fatal("TestHandleLoginTenant: expected a sessionId got empty string")
+	}
+
+	if !acmeHandler.IsLoggedIn(sessId) || AUTH_HANDLER.IsLoggedIn(sessId) {
+		t.Fatal("TestHandleLoginTenant: session should only be valid for its tenant")
+	}
+
+	// test reset tokens update the user of their tenant
+	passwordResetToken := acmeHandler.HandleForgotPassword(email)
+	if !AUTH_HANDLER.HandleUpdatePassword(passwordResetToken, "new pass") {
+		t.Fatal("TestHandleLoginTenant: passwordReset should be successful")
+	}
+
+	if acmeHandler.HandleLogin(email, "new pass") == "" {
+		t.Fatal("TestHandleLoginTenant: expected login with the new password")
+	}
+}
+
+// an unreachable temp store is not a logged out user
+func TestAuthHandlerTempStoreOutage(t *testing.T) {
+	storeType := config.TempStoreType
+	defer config.SetTempStoreType(storeType)
+	config.SetTempStoreType("redis")
+
+	withUnreachableRedis(func() {
+		beforeEachAUTH_TEST()
+		defer afterEachAUTH_TEST()
+
+		ctx := context.Background()
+		isLoggedIn, err := AUTH_HANDLER.IsLoggedInContext(ctx, "sessionId")
+		if isLoggedIn || !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestAuthHandlerTempStoreOutage: expected ErrBackend, got", isLoggedIn, err)
+		}
+		if controllers.ErrorStatus(err) != http.StatusServiceUnavailable {
+			t.Fatal("TestAuthHandlerTempStoreOutage: expected", http.StatusServiceUnavailable,
+				"got", controllers.ErrorStatus(err))
+		}
+
+		if err := AUTH_HANDLER.HandleLogoutContext(ctx, "sessionId"); !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestAuthHandlerTempStoreOutage: expected ErrBackend logging out, got", err)
+		}
+
+		err = AUTH_HANDLER.HandleUpdatePasswordContext(ctx, "token", "password")
+		if !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestAuthHandlerTempStoreOutage: expected ErrBackend updating password, got", err)
+		}
+
+		if AUTH_HANDLER.IsLoggedIn("sessionId") {
+			t.Fatal("TestAuthHandlerTempStoreOutage: expected IsLoggedIn to be false")
+		}
+	})
 }
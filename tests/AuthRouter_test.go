@@ -3,6 +3,7 @@ package tests
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -46,16 +47,16 @@ func TestLoginUser(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	_, success := USER_STORE.Save(user)
+	_, err := USER_STORE.Save(user)
 
-	if !success {
-		t.Fatal("TestLoginUser: success should be true")
+	if err != nil {
+		t.Fatal("TestLoginUser: save should succeed;", err)
 	}
 
-	loginDataJSON := `{"data": {"email":"testmail@mail.com", "password": "xxx"}}`
+	loginDataJSON := `{"data": {"email":"testmail@mail.com", "password": "xxxxxxxx"}}`
 
 	// test successfully login a user
 	headers := map[string]string{
@@ -140,16 +141,16 @@ func TestLogoutUser(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	_, success := USER_STORE.Save(user)
+	_, err := USER_STORE.Save(user)
 
-	if !success {
-		t.Fatal("TestLogoutUser: success should be true")
+	if err != nil {
+		t.Fatal("TestLogoutUser: save should succeed;", err)
 	}
 
-	loginDataJSON := `{"data": {"email":"testmail@mail.com", "password": "xxx"}}`
+	loginDataJSON := `{"data": {"email":"testmail@mail.com", "password": "xxxxxxxx"}}`
 
 	// test successfully login a user
 	headers := map[string]string{
@@ -227,13 +228,13 @@ func TestForgotPassword(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	_, success := USER_STORE.Save(user)
+	_, err := USER_STORE.Save(user)
 
-	if !success {
-		t.Fatal("TestForgotPassword: success should be true")
+	if err != nil {
+		t.Fatal("TestForgotPassword: save should succeed;", err)
 	}
 
 	// test wrong email
@@ -274,13 +275,13 @@ func TestResetPassword(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	_, success := USER_STORE.Save(user)
+	_, err := USER_STORE.Save(user)
 
-	if !success {
-		t.Fatal("TestForgotPassword: success should be true")
+	if err != nil {
+		t.Fatal("TestForgotPassword: save should succeed;", err)
 	}
 
 	headers := map[string]string{
@@ -317,18 +318,30 @@ func TestResetPassword(t *testing.T) {
 	passwordResetToken := recBody["passwordResetToken"].(string)
 	newPasswordJSON := `{"data": {"password":"newPassword"}}`
 
-	rec, c = SetupRequest(e, http.MethodPost, "/auth/reset_password/"+passwordResetToken,
-		newPasswordJSON, headers)
+	resetPassword := func(passwordJSON string) *httptest.ResponseRecorder {
+		rec, c := SetupRequest(e, http.MethodPost, "/auth/reset_password/"+passwordResetToken,
+			passwordJSON, headers)
+		c.SetParamNames("passwordResetToken")
+		c.SetParamValues(passwordResetToken)
+		auth.ResetPassword(c)
+		return rec
+	}
 
-	c.SetParamNames("passwordResetToken")
-	c.SetParamValues(passwordResetToken)
-	auth.ResetPassword(c)
+	// test a rejected password is a 400, and leaves the token usable
+	if rec = resetPassword(`{"data": {"password":"short"}}`); rec.Code != http.StatusBadRequest {
+		t.Fatal("POST /auth/reset_password: expected:", http.StatusBadRequest, "got:", rec.Code)
+	}
 
-	if http.StatusOK != rec.Code {
+	if rec = resetPassword(newPasswordJSON); http.StatusOK != rec.Code {
 		fmt.Println("body returned", rec.Body.String())
 		t.Fatal("POST /auth/reset_password: expected:", http.StatusOK, "got:", rec.Code)
 	}
 
+	// test a used token is a 404
+	if rec = resetPassword(newPasswordJSON); rec.Code != http.StatusNotFound {
+		t.Fatal("POST /auth/reset_password: expected:", http.StatusNotFound, "got:", rec.Code)
+	}
+
 	// test login with newPassword after update password
 	rec, c = SetupRequest(e, http.MethodPost, "/auth/login", loginDataJSON, headers)
 	auth.Login(c)
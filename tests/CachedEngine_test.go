@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/config"
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+var cached_engine_test_db_path = "cached_engine_test_db.json"
+var cached_engine_test_cache_path = "cached_engine_test_cache.json"
+
+func TestCachedEngine(t *testing.T) {
+	engine, _ := storage.MakeFileDb(cached_engine_test_db_path, "users")
+	store := storage.MakeTempStoreFileDbImpl(cached_engine_test_cache_path, storage.CACHE_RECORDS_NAME)
+	defer func() {
+		storage.RemoveDbSingleton(cached_engine_test_db_path, "users")
+		storage.RemoveDbSingleton(cached_engine_test_cache_path, storage.CACHE_RECORDS_NAME)
+		os.Remove(cached_engine_test_db_path)
+		os.Remove(cached_engine_test_cache_path)
+	}()
+
+	cache := storage.MakeCachedEngine(engine, store, cached_engine_test_db_path, "users",
+		storage.CacheOptions{TTL: time.Minute, NegativeTTL: time.Minute})
+
+	id, _ := cache.Save(map[string]any{"name": "cached", "age": 1})
+
+	// test first read misses and the second hits
+	cache.Get(id)
+	record, err := cache.Get(id)
+	if err != nil || record.(map[string]any)["name"] != "cached" {
+		t.Fatal("TestCachedEngine: expected cached record got", record, err)
+	}
+
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatal("TestCachedEngine: expected 1 hit and 1 miss got", stats)
+	}
+
+	// test writes bypassing the cache are not seen until invalidated
+	engine.Update(id, storage.UpdateDesc{Field: "name", Value: "bypassed"})
+	record, _ = cache.Get(id)
+	if record.(map[string]any)["name"] != "cached" {
+		t.Fatal("TestCachedEngine: expected record to be served from the cache got", record)
+	}
+
+	// test updates through the cache invalidate the record
+	cache.Update(id, storage.UpdateDesc{Field: "name", Value: "updated"})
+	record, _ = cache.Get(id)
+	if record.(map[string]any)["name"] != "updated" {
+		t.Fatal("TestCachedEngine: expected updated record got", record)
+	}
+
+	// test field queries are cached and invalidated by writes
+	records, _ := cache.GetRecordsByField("age", 1)
+	hits := cache.Stats().Hits
+	records, _ = cache.GetRecordsByField("age", 1)
+	if len(records) != 1 || cache.Stats().Hits != hits+1 {
+		t.Fatal("TestCachedEngine: expected field query to hit got", records, cache.Stats())
+	}
+
+	cache.Save(map[string]any{"name": "other", "age": 1})
+	records, _ = cache.GetRecordsByField("age", 1)
+	if len(records) != 2 {
+		t.Fatal("TestCachedEngine: expected save to invalidate field query got", records)
+	}
+
+	// test ids by field are cached and invalidated by writes
+	cache.GetIdByFieldAndValue("name", "updated")
+	hits = cache.Stats().Hits
+	if cache.GetIdByFieldAndValue("name", "updated") != id || cache.Stats().Hits != hits+1 {
+		t.Fatal("TestCachedEngine: expected id by field to hit got", cache.Stats())
+	}
+
+	cache.Update(id, storage.UpdateDesc{Field: "name", Value: "renamed"})
+	if cache.GetIdByFieldAndValue("name", "updated") != "" || cache.GetIdByFieldAndValue("name", "renamed") != id {
+		t.Fatal("TestCachedEngine: expected update to invalidate ids by field")
+	}
+
+	// test cached records are not read past their expiry
+	expiringId, _ := cache.SaveWithOptions(map[string]any{"name": "expiring", "age": 2},
+		storage.SaveOptions{TTL: time.Millisecond * 20})
+	cache.Get(expiringId)
+	cache.GetRecordsByField("age", 2)
+	time.Sleep(time.Millisecond * 30)
+
+	hits = cache.Stats().Hits
+	if _, err = cache.Get(expiringId); !errors.Is(err, storage.ErrNotFound) || cache.Stats().Hits != hits+1 {
+		t.Fatal("TestCachedEngine: expected cached record to expire got", err, cache.Stats())
+	}
+
+	if records, _ = cache.GetRecordsByField("age", 2); len(records) != 0 {
+		t.Fatal("TestCachedEngine: expected cached field query to leave out expired records got", records)
+	}
+
+	// test deleted records are negatively cached
+	cache.Delete(id)
+	if _, err = cache.Get(id); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestCachedEngine: expected ErrNotFound got", err)
+	}
+
+	hits = cache.Stats().Hits
+	if _, err = cache.Get(id); !errors.Is(err, storage.ErrNotFound) || cache.Stats().Hits != hits+1 {
+		t.Fatal("TestCachedEngine: expected cached ErrNotFound got", err, cache.Stats())
+	}
+
+	// test TTLs below a second are kept
+	shortCache := storage.MakeCachedEngine(engine, store, cached_engine_test_db_path, "short_users",
+		storage.CacheOptions{TTL: time.Millisecond * 50})
+	shortId, _ := shortCache.Save(map[string]any{"name": "short", "age": 3})
+	shortCache.Get(shortId)
+	time.Sleep(time.Millisecond * 100)
+
+	misses := shortCache.Stats().Misses
+	if shortCache.Get(shortId); shortCache.Stats().Misses != misses+1 {
+		t.Fatal("TestCachedEngine: expected the cached record to expire got", shortCache.Stats())
+	}
+}
+
+func TestCachedEngineConfig(t *testing.T) {
+	config.SetCacheTTL("cached_users", time.Minute)
+	config.SetCacheDb(cached_engine_test_cache_path)
+	defer func() {
+		delete(config.CacheTTLs, "cached_users")
+		config.SetCacheDb("cache")
+		storage.RemoveDbSingleton(cached_engine_test_db_path, "cached_users")
+		storage.RemoveMemoryTempStoreSingleton(cached_engine_test_cache_path, storage.CACHE_RECORDS_NAME)
+		os.Remove(cached_engine_test_db_path)
+		os.Remove(cached_engine_test_cache_path)
+	}()
+
+	engine, err := storage.GetDB_Engine("file", cached_engine_test_db_path, "cached_users")
+	if _, ok := engine.(*storage.CachedEngine); !ok || err != nil {
+		t.Fatal("TestCachedEngineConfig: expected a CachedEngine got", engine, err)
+	}
+
+	// test the engine of a session shares the cache
+	session := storage.SessionDB_Engine(engine, "session")
+	id, _ := session.Save(map[string]any{"name": "session"})
+	engine.Get(id)
+	hits := engine.(*storage.CachedEngine).Stats().Hits
+	if _, err = session.Get(id); err != nil || engine.(*storage.CachedEngine).Stats().Hits != hits+1 {
+		t.Fatal("TestCachedEngineConfig: expected the session to hit the cache got", err)
+	}
+
+	// test a file temp store does not keep the cache in a file
+	if _, err = os.Stat(cached_engine_test_cache_path); !os.IsNotExist(err) {
+		t.Fatal("TestCachedEngineConfig: expected the cache to be kept in memory got", err)
+	}
+
+	engine, _ = storage.GetDB_Engine("file", cached_engine_test_db_path, "uncached_users")
+	if _, ok := engine.(*storage.CachedEngine); ok {
+		t.Fatal("TestCachedEngineConfig: expected records without a TTL not to be cached")
+	}
+	storage.RemoveDbSingleton(cached_engine_test_db_path, "uncached_users")
+}
@@ -1,10 +1,15 @@
 package tests
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/Iyusuf40/goBackendUtils/storage"
 )
 
@@ -239,12 +244,12 @@ func TestUpdate(t *testing.T) {
 	id, _ := DB.Save(user)
 	updated_name := "updated_name"
 
-	resp := DB.Update(id, storage.UpdateDesc{
+	err := DB.Update(id, storage.UpdateDesc{
 		Field: "name",
 		Value: updated_name})
 
-	if !resp {
-		t.Fatal("TestUpdate: failed to update")
+	if err != nil {
+		t.Fatal("TestUpdate: failed to update", err)
 	}
 
 	obj, _ := DB.Get(id)
@@ -294,12 +299,12 @@ func TestUpdate(t *testing.T) {
 
 	updated_nested_field := 2
 
-	resp = DB.Update(id, storage.UpdateDesc{
+	err = DB.Update(id, storage.UpdateDesc{
 		Field: "B.BA",
 		Value: updated_nested_field})
 
-	if !resp {
-		t.Fatal("TestUpdate: failed to update")
+	if err != nil {
+		t.Fatal("TestUpdate: failed to update", err)
 	}
 
 	obj, _ = DB.Get(id)
@@ -311,6 +316,199 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestUpsert(t *testing.T) {
+
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	user := User{"test", 20}
+	id, created, err := DB.Upsert("name", user.Name, user)
+
+	if err != nil || !created {
+		t.Fatal("TestUpsert: expected record to be created, err:", err)
+	}
+
+	user.Age = 30
+	updatedId, created, err := DB.Upsert("name", user.Name, user)
+
+	if err != nil || created {
+		t.Fatal("TestUpsert: expected record to be updated, err:", err)
+	}
+
+	if updatedId != id {
+		t.Fatal("TestUpsert: expected id", id, "got", updatedId)
+	}
+
+	if DB.AllRecordsCount() != 1 {
+		t.Fatal("TestUpsert: all records count should be 1")
+	}
+
+	obj, _ := DB.Get(id)
+	saved_user := new(User).buildUser(obj)
+
+	if saved_user.Age != user.Age {
+		t.Fatal("TestUpsert: expected age", user.Age, "got", saved_user.Age)
+	}
+
+	// test upsert by id
+	user.Name = "renamed"
+	_, created, err = DB.Upsert("id", id, user)
+
+	if err != nil || created {
+		t.Fatal("TestUpsert: expected record to be updated by id, err:", err)
+	}
+
+	obj, _ = DB.Get(id)
+	saved_user = new(User).buildUser(obj)
+
+	if saved_user.Name != user.Name {
+		t.Fatal("TestUpsert: expected name", user.Name, "got", saved_user.Name)
+	}
+
+	if _, _, err = DB.Upsert("id", "not-a-record-id", user); err == nil {
+		t.Fatal("TestUpsert: expected upsert with invalid id to fail")
+	}
+
+	// test concurrent upserts of the same value create one record
+	var wg sync.WaitGroup
+	createdCount := atomic.Int32{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(age int) {
+			defer wg.Done()
+			_, created, _ := DB.Upsert("name", "concurrent", User{"concurrent", age})
+			if created {
+				createdCount.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if createdCount.Load() != 1 {
+		t.Fatal("TestUpsert: expected 1 record to be created, got", createdCount.Load())
+	}
+
+	if DB.AllRecordsCount() != 2 {
+		t.Fatal("TestUpsert: all records count should be 2")
+	}
+}
+
+func TestRecordMetadata(t *testing.T) {
+
+	config.SetTrackRecordMetadata(true)
+	defer config.SetTrackRecordMetadata(false)
+
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	user := User{"test", 20}
+	id, _ := DB.Save(user)
+	obj, _ := DB.Get(id)
+	record := obj.(map[string]any)
+
+	createdAt, _ := storage.GetFloat64Equivalent(record["createdAt"])
+	updatedAt, _ := storage.GetFloat64Equivalent(record["updatedAt"])
+	version, _ := storage.GetFloat64Equivalent(record["version"])
+
+	if createdAt == 0 || createdAt != updatedAt {
+		t.Fatal("TestRecordMetadata: createdAt should be set and equal updatedAt")
+	}
+
+	if version != 1 {
+		t.Fatal("TestRecordMetadata: version should be 1 got", version)
+	}
+
+	time.Sleep(time.Millisecond * 2)
+	DB.Update(id, storage.UpdateDesc{Field: "age", Value: 21})
+
+	obj, _ = DB.Get(id)
+	record = obj.(map[string]any)
+	updatedAt, _ = storage.GetFloat64Equivalent(record["updatedAt"])
+	version, _ = storage.GetFloat64Equivalent(record["version"])
+
+	if updatedAt <= createdAt {
+		t.Fatal("TestRecordMetadata: updatedAt should be after createdAt")
+	}
+
+	if version != 2 {
+		t.Fatal("TestRecordMetadata: version should be 2 got", version)
+	}
+
+	// metadata cannot be set by callers
+	if err := DB.Update(id, storage.UpdateDesc{Field: "version", Value: 10}); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestRecordMetadata: updating version should fail with ErrValidation got", err)
+	}
+
+	_, _, err := DB.Upsert("id", id, map[string]any{"name": "upserted", "createdAt": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, _ = DB.Get(id)
+	record = obj.(map[string]any)
+	upsertedCreatedAt, _ := storage.GetFloat64Equivalent(record["createdAt"])
+	version, _ = storage.GetFloat64Equivalent(record["version"])
+
+	if upsertedCreatedAt != createdAt || version != 3 {
+		t.Fatal("TestRecordMetadata: upsert should keep createdAt and increment version")
+	}
+
+	// test records are sortable by createdAt
+	time.Sleep(time.Millisecond * 2)
+	laterId, _ := DB.Save(User{"later", 30})
+	records := DB.GetAllOfRecords()
+	storage.SortRecordsByField(records, "createdAt", true)
+
+	if records[0]["name"] != "later" {
+		t.Fatal("TestRecordMetadata: latest record should be first, got", records[0]["name"], laterId)
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+
+	config.SetTrackRecordMetadata(true)
+	defer config.SetTrackRecordMetadata(false)
+
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	user := User{"test", 20}
+	id, _ := DB.Save(user)
+
+	err := DB.UpdateIfVersion(id, storage.UpdateDesc{Field: "age", Value: 21}, 1)
+	if err != nil {
+		t.Fatal("TestUpdateIfVersion: update of current version should pass, err:", err)
+	}
+
+	// version is now 2, a stale writer must fail
+	err = DB.UpdateIfVersion(id, storage.UpdateDesc{Field: "age", Value: 22}, 1)
+
+	var conflict *storage.VersionConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatal("TestUpdateIfVersion: expected a VersionConflictError got", err)
+	}
+
+	if conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Fatal("TestUpdateIfVersion: expected conflict between 1 and 2 got", conflict)
+	}
+
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatal("TestUpdateIfVersion: VersionConflictError should match ErrConflict")
+	}
+
+	obj, _ := DB.Get(id)
+	saved_user := new(User).buildUser(obj)
+
+	if saved_user.Age != 21 {
+		t.Fatal("TestUpdateIfVersion: stale update should not be applied, age:", saved_user.Age)
+	}
+
+	err = DB.UpdateIfVersion("User-missing", storage.UpdateDesc{Field: "age", Value: 22}, 1)
+	if !errors.Is(err, storage.ErrNotFound) || errors.As(err, &conflict) {
+		t.Fatal("TestUpdateIfVersion: update of missing record should fail with ErrNotFound")
+	}
+}
+
 func TestDelete(t *testing.T) {
 
 	beforeEachFDBT()
@@ -330,6 +528,385 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestSoftDelete(t *testing.T) {
+
+	config.SetSoftDelete(true)
+	defer config.SetSoftDelete(false)
+
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	user := User{"test", 20}
+	id, _ := DB.Save(user)
+	DB.Save(User{"other", 20})
+
+	DB.Delete(id)
+
+	if obj, err := DB.Get(id); obj != nil || err == nil {
+		t.Fatal("TestSoftDelete: soft deleted record should not be returned")
+	}
+
+	if records, _ := DB.GetRecordsByField("age", 20); len(records) != 1 {
+		t.Fatal("TestSoftDelete: expected 1 record by field got", len(records))
+	}
+
+	if DB.GetIdByFieldAndValue("name", "test") != "" {
+		t.Fatal("TestSoftDelete: soft deleted record id should not be returned")
+	}
+
+	if len(DB.GetAllOfRecords()) != 1 {
+		t.Fatal("TestSoftDelete: expected 1 record got", len(DB.GetAllOfRecords()))
+	}
+
+	if len(DB.GetAllOfRecordsIncludingDeleted()) != 2 {
+		t.Fatal("TestSoftDelete: expected 2 records including deleted")
+	}
+
+	if err := DB.Update(id, storage.UpdateDesc{Field: "name", Value: "x"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestSoftDelete: soft deleted record should not be updatable")
+	}
+
+	if record, err := DB.GetDeleted(id); record == nil || err != nil {
+		t.Fatal("TestSoftDelete: soft deleted record should be returned by GetDeleted", err)
+	}
+
+	// test restore
+	if err := DB.Restore(id); err != nil {
+		t.Fatal("TestSoftDelete: failed to restore", err)
+	}
+
+	if err := DB.Restore(id); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestSoftDelete: restoring a live record should fail")
+	}
+
+	if _, err := DB.GetDeleted(id); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestSoftDelete: live record should not be returned by GetDeleted")
+	}
+
+	if obj, err := DB.Get(id); obj == nil || err != nil {
+		t.Fatal("TestSoftDelete: restored record should be returned")
+	}
+
+	// test purge only erases records deleted before retention
+	DB.Delete(id)
+
+	if purged, _ := DB.PurgeDeleted(time.Hour); purged != 0 {
+		t.Fatal("TestSoftDelete: record deleted within retention should not be purged")
+	}
+
+	time.Sleep(time.Millisecond * 2)
+	if purged, _ := DB.PurgeDeleted(time.Millisecond); purged != 1 {
+		t.Fatal("TestSoftDelete: expected 1 record to be purged got", purged)
+	}
+
+	if len(DB.GetAllOfRecordsIncludingDeleted()) != 1 {
+		t.Fatal("TestSoftDelete: purged record should be erased")
+	}
+
+	if err := DB.Restore(id); err == nil {
+		t.Fatal("TestSoftDelete: purged record should not be restorable")
+	}
+}
+
+func TestPurgingDeletedInBackground(t *testing.T) {
+
+	config.SetSoftDelete(true)
+	defer config.SetSoftDelete(false)
+
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	id, _ := DB.Save(User{"test", 20})
+	DB.Delete(id)
+
+	stop := storage.StartPurgingDeleted(DB, 0, time.Millisecond*5)
+	time.Sleep(time.Millisecond * 50)
+	stop()
+
+	if len(DB.GetAllOfRecordsIncludingDeleted()) != 0 {
+		t.Fatal("TestPurgingDeletedInBackground: deleted record should be purged")
+	}
+}
+
+func TestSaveWithExpiry(t *testing.T) {
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	DB.Save(User{"permanent", 20})
+	id, err := DB.SaveWithOptions(User{"expiring", 30}, storage.SaveOptions{TTL: time.Millisecond * 20})
+	if err != nil {
+		t.Fatal("TestSaveWithExpiry: save should succeed;", err)
+	}
+
+	if obj, err := DB.Get(id); obj == nil || err != nil {
+		t.Fatal("TestSaveWithExpiry: record should be returned before it expires")
+	}
+
+	// test expiresAt is maintained by the engine
+	err = DB.Update(id, storage.UpdateDesc{Field: storage.EXPIRES_AT_FIELD, Value: 0})
+	if !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestSaveWithExpiry: expected ErrValidation updating expiresAt got", err)
+	}
+
+	time.Sleep(time.Millisecond * 30)
+
+	// test expired records are left out of every read
+	if _, err := DB.Get(id); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestSaveWithExpiry: expected ErrNotFound got", err)
+	}
+
+	if records, _ := DB.GetRecordsByField("name", "expiring"); len(records) != 0 {
+		t.Fatal("TestSaveWithExpiry: expired record should not be found by field")
+	}
+
+	if DB.GetIdByFieldAndValue("name", "expiring") != "" {
+		t.Fatal("TestSaveWithExpiry: expired record should not have its id found")
+	}
+
+	if len(DB.GetAllOfRecords()) != 1 || len(DB.GetAllOfRecordsIncludingDeleted()) != 1 {
+		t.Fatal("TestSaveWithExpiry: expired record should not be listed")
+	}
+
+	if results, _ := DB.Search("expiring", []string{"name"}, 0); len(results) != 0 {
+		t.Fatal("TestSaveWithExpiry: expired record should not be searchable got", results)
+	}
+
+	if err := DB.Update(id, storage.UpdateDesc{Field: "name", Value: "x"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestSaveWithExpiry: expired record should not be updatable")
+	}
+
+	if purged, _ := DB.PurgeExpired(); purged != 1 || DB.AllRecordsCount() != 1 {
+		t.Fatal("TestSaveWithExpiry: expected 1 record to be purged got", purged)
+	}
+
+	// test upserted records no longer expire
+	id, _ = DB.SaveWithOptions(User{"upserted", 40}, storage.SaveOptions{TTL: time.Millisecond * 20})
+	DB.Upsert("id", id, User{"upserted", 41})
+	time.Sleep(time.Millisecond * 30)
+
+	if obj, err := DB.Get(id); obj == nil || err != nil {
+		t.Fatal("TestSaveWithExpiry: upserted record should not expire")
+	}
+}
+
+func TestVacuumingExpired(t *testing.T) {
+	config.SetExpiredRecordsVacuumInterval(time.Millisecond * 5)
+	defer config.SetExpiredRecordsVacuumInterval(time.Minute)
+
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	DB.Save(User{"permanent", 20})
+	DB.SaveWithOptions(User{"expiring", 30}, storage.SaveOptions{TTL: time.Millisecond * 10})
+	time.Sleep(time.Millisecond * 50)
+
+	if DB.AllRecordsCount() != 1 {
+		t.Fatal("TestVacuumingExpired: expired record should be erased in the background")
+	}
+
+	// test expired records are erased on load
+	config.SetExpiredRecordsVacuumInterval(0)
+	DB.SaveWithOptions(User{"expiring", 30}, storage.SaveOptions{TTL: time.Millisecond * 10})
+	DB.Commit()
+	time.Sleep(time.Millisecond * 20)
+
+	if err := DB.Reload(); err != nil || DB.AllRecordsCount() != 1 {
+		t.Fatal("TestVacuumingExpired: expired record should be erased on load")
+	}
+}
+
+// nextChangeEvent returns the next event on events, failing
+// the test if none arrives in time
+func nextChangeEvent(t *testing.T, events <-chan storage.ChangeEvent) storage.ChangeEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("nextChangeEvent: events channel closed")
+		}
+		return event
+	case <-time.After(time.Second * 5):
+		t.Fatal("nextChangeEvent: no event received")
+	}
+	return storage.ChangeEvent{}
+}
+
+func TestWatch(t *testing.T) {
+
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	events, stop, err := DB.Watch(storage.WatchFilter{})
+	if err != nil {
+		t.Fatal("TestWatch: failed to watch", err)
+	}
+
+	id, _ := DB.Save(User{"test", 20})
+	event := nextChangeEvent(t, events)
+	if event.Op != storage.INSERT_OP || event.ID != id || event.Data["name"] != "test" {
+		t.Fatal("TestWatch: expected insert event of", id, "got", event)
+	}
+
+	DB.Update(id, storage.UpdateDesc{Field: "name", Value: "changed"})
+	event = nextChangeEvent(t, events)
+	if event.Op != storage.UPDATE_OP || event.ID != id || event.Data["name"] != "changed" {
+		t.Fatal("TestWatch: expected update event of", id, "got", event)
+	}
+
+	DB.Delete(id)
+	event = nextChangeEvent(t, events)
+	if event.Op != storage.DELETE_OP || event.ID != id || event.Data != nil {
+		t.Fatal("TestWatch: expected delete event of", id, "got", event)
+	}
+
+	stop()
+	if _, ok := <-events; ok {
+		t.Fatal("TestWatch: events channel should be closed after stop")
+	}
+
+	// test filter
+	otherId, _ := DB.Save(User{"other", 20})
+	events, stop, _ = DB.Watch(storage.WatchFilter{
+		IDs: []string{otherId},
+		Ops: []storage.ChangeOp{storage.UPDATE_OP},
+	})
+	defer stop()
+
+	anotherId, _ := DB.Save(User{"another", 20})
+	DB.Update(anotherId, storage.UpdateDesc{Field: "age", Value: 21})
+	DB.Update(otherId, storage.UpdateDesc{Field: "age", Value: 21})
+	DB.Delete(otherId)
+
+	event = nextChangeEvent(t, events)
+	if event.Op != storage.UPDATE_OP || event.ID != otherId {
+		t.Fatal("TestWatch: expected only update events of", otherId, "got", event)
+	}
+
+	select {
+	case event := <-events:
+		t.Fatal("TestWatch: unexpected event", event)
+	default:
+	}
+}
+
+func TestSearch(t *testing.T) {
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	testSearch(t, DB)
+
+	// test the index follows changes
+	id := DB.GetIdByFieldAndValue("name", "Jane Roe")
+	DB.Update(id, storage.UpdateDesc{Field: "name", Value: "Janet Roe"})
+	if results, _ := DB.Search("janet", []string{"name"}, 0); len(results) != 1 || results[0].ID != id {
+		t.Fatal("TestSearch: expected the updated record got", results)
+	}
+
+	// test results do not share the stored records
+	results, _ := DB.Search("janet", []string{"name"}, 0)
+	results[0].Record["name"] = "changed"
+	if record, _ := DB.Get(id); record.(map[string]any)["name"] != "Janet Roe" {
+		t.Fatal("TestSearch: expected the stored record to be unchanged got", record)
+	}
+
+	DB.Update(id, storage.UpdateDesc{Field: "name", Value: "Mary Roe"})
+	if results, _ := DB.Search("janet", []string{"name"}, 0); len(results) != 0 {
+		t.Fatal("TestSearch: expected the old name not to be found got", results)
+	}
+
+	DB.Delete(id)
+	if results, _ := DB.Search("mary", []string{"name"}, 0); len(results) != 0 {
+		t.Fatal("TestSearch: expected deleted record not to be found got", results)
+	}
+}
+
+func TestReadsReturnCopies(t *testing.T) {
+	beforeEachFDBT()
+	defer afterEachFDBT()
+
+	id, _ := DB.Save(map[string]any{"name": "stored", "address": map[string]any{"city": "Lagos"}})
+
+	record, _ := DB.Get(id)
+	record.(map[string]any)["address"].(map[string]any)["city"] = "changed"
+	records, _ := DB.GetRecordsByField("name", "stored")
+	records[0]["name"] = "changed"
+	DB.GetAllOfRecords()[0]["name"] = "changed"
+
+	stored, _ := DB.Get(id)
+	if stored.(map[string]any)["name"] != "stored" ||
+		stored.(map[string]any)["address"].(map[string]any)["city"] != "Lagos" {
+		t.Fatal("TestReadsReturnCopies: expected the stored record to be unchanged got", stored)
+	}
+
+	// readers racing with writers share no map
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			DB.Update(id, storage.UpdateDesc{Field: "name", Value: fmt.Sprint(i)})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if record, err := DB.Get(id); err == nil {
+				_ = fmt.Sprint(record)
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// testSearch checks the Search of engine, which must be empty
+func testSearch(t *testing.T, engine storage.DB_Engine) {
+	for _, user := range []User{{"John Doe", 30}, {"Jane Roe", 25}, {"Johnny Doe-Smith", 40}, {"Doe", 50}} {
+		if _, err := engine.Save(user); err != nil {
+			t.Fatal("testSearch: save should succeed;", err)
+		}
+	}
+
+	results, err := engine.Search("doe", []string{"name"}, 0)
+	if err != nil || len(results) != 3 {
+		t.Fatal("testSearch: expected 3 records got", results, err)
+	}
+
+	// test the best matches come first
+	for i := 1; i < len(results); i++ {
+		if results[i-1].Score < results[i].Score {
+			t.Fatal("testSearch: expected results sorted by score got", results)
+		}
+	}
+
+	if results[0].ID == "" {
+		t.Fatal("testSearch: expected results to have an ID")
+	}
+
+	// test every term must match, regardless of case, and
+	// exact matches rank before prefix matches
+	results, _ = engine.Search("JOHN doe", []string{"name"}, 0)
+	if len(results) != 2 || results[0].Record["name"] != "John Doe" {
+		t.Fatal("testSearch: expected John Doe then Johnny Doe-Smith got", results)
+	}
+
+	if results, _ = engine.Search("jane doe", []string{"name"}, 0); len(results) != 0 {
+		t.Fatal("testSearch: expected no record to match every term got", results)
+	}
+
+	results, _ = engine.Search("doe", []string{"name"}, 2)
+	if len(results) != 2 {
+		t.Fatal("testSearch: expected limit to be applied got", results)
+	}
+
+	if results, _ = engine.Search("...", []string{"name"}, 0); len(results) != 0 {
+		t.Fatal("testSearch: expected a query without terms to match nothing got", results)
+	}
+
+	if _, err = engine.Search("doe", nil, 0); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("testSearch: expected ErrValidation without fields got", err)
+	}
+}
+
 func TestAllRecordsCount(t *testing.T) {
 
 	beforeEachFDBT()
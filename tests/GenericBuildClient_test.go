@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+type Address struct {
+	City string `json:"city"`
+	Zip  int    `json:"zip"`
+}
+
+type Audit struct {
+	CreatedBy string `json:"createdBy"`
+}
+
+type Profile struct {
+	Audit
+	Name      string         `json:"name"`
+	Active    bool           `json:"active,omitempty"`
+	Score     float32        `json:"score"`
+	Count     uint8          `json:"count"`
+	Joined    time.Time      `json:"joined"`
+	Seen      time.Time      `json:"seen"`
+	Address   Address        `json:"address"`
+	Previous  *Address       `json:"previous"`
+	Tags      []string       `json:"tags"`
+	Points    [2]int         `json:"points"`
+	Labels    map[string]int `json:"labels"`
+	IP        net.IP         `json:"ip"`
+	Extra     any            `json:"extra"`
+	Secret    string         `json:"-"`
+	Untagged  string
+	Notes     map[string]string `json:"notes,omitempty"`
+	unexposed string
+}
+
+func TestGenericBuildClient(t *testing.T) {
+	joined := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := map[string]any{
+		"createdBy": "admin",
+		"name":      "test",
+		"active":    "true",
+		"score":     1.5,
+		"count":     float64(7),
+		"joined":    joined.Format(time.RFC3339),
+		"seen":      float64(joined.UnixMilli()),
+		"address":   map[string]any{"city": "Lagos", "zip": "100001"},
+		"previous":  map[string]any{"city": "Abuja"},
+		"tags":      []any{"a", "b"},
+		"points":    []any{float64(1), float64(2)},
+		"labels":    map[string]any{"x": float64(1)},
+		"ip":        "127.0.0.1",
+		"extra":     map[string]any{"k": "v"},
+		"Secret":    "leaked",
+		"-":         "leaked",
+		"Untagged":  "untagged",
+		"unexposed": "leaked",
+	}
+
+	profile, err := storage.GenericBuildClient[Profile](record)
+	if err != nil {
+		t.Fatal("TestGenericBuildClient: expected no error got", err)
+	}
+
+	if profile.CreatedBy != "admin" {
+		t.Fatal("TestGenericBuildClient: embedded field not set, got", profile.CreatedBy)
+	}
+
+	if profile.Name != "test" || !profile.Active || profile.Score != 1.5 || profile.Count != 7 {
+		t.Fatal("TestGenericBuildClient: scalar fields not set, got", profile)
+	}
+
+	if !profile.Joined.Equal(joined) || !profile.Seen.Equal(joined) {
+		t.Fatal("TestGenericBuildClient: time fields not set, got", profile.Joined, profile.Seen)
+	}
+
+	if profile.Address != (Address{"Lagos", 100001}) {
+		t.Fatal("TestGenericBuildClient: nested struct not set, got", profile.Address)
+	}
+
+	if profile.Previous == nil || profile.Previous.City != "Abuja" {
+		t.Fatal("TestGenericBuildClient: pointer to struct not set, got", profile.Previous)
+	}
+
+	if len(profile.Tags) != 2 || profile.Tags[1] != "b" || profile.Points != [2]int{1, 2} {
+		t.Fatal("TestGenericBuildClient: slice and array not set, got", profile.Tags, profile.Points)
+	}
+
+	if profile.Labels["x"] != 1 {
+		t.Fatal("TestGenericBuildClient: map not set, got", profile.Labels)
+	}
+
+	if !profile.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatal("TestGenericBuildClient: TextUnmarshaler not used, got", profile.IP)
+	}
+
+	if extra, ok := profile.Extra.(map[string]any); !ok || extra["k"] != "v" {
+		t.Fatal("TestGenericBuildClient: interface field not set, got", profile.Extra)
+	}
+
+	if profile.Secret != "" || profile.unexposed != "" {
+		t.Fatal("TestGenericBuildClient: skipped fields should not be set")
+	}
+
+	if profile.Untagged != "untagged" {
+		t.Fatal("TestGenericBuildClient: untagged field should be keyed by its name")
+	}
+
+	// test failed fields are reported and the others still set
+	record = map[string]any{
+		"name":    "test",
+		"active":  "maybe",
+		"count":   float64(300),
+		"address": map[string]any{"city": 5},
+		"tags":    []any{"a", 1},
+		"joined":  "yesterday",
+	}
+
+	profile, err = storage.GenericBuildClient[Profile](record)
+
+	var validationErr *storage.ValidationError
+	if !errors.As(err, &validationErr) || !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestGenericBuildClient: expected a ValidationError got", err)
+	}
+
+	for _, field := range []string{"active", "count", "address.city", "tags[1]", "joined"} {
+		if validationErr.Fields[field] == "" {
+			t.Fatal("TestGenericBuildClient: expected", field, "to fail, failures:", validationErr.Fields)
+		}
+	}
+
+	if len(validationErr.Fields) != 5 {
+		t.Fatal("TestGenericBuildClient: expected 5 failures got", validationErr.Fields)
+	}
+
+	if profile.Name != "test" {
+		t.Fatal("TestGenericBuildClient: valid fields should still be set")
+	}
+}
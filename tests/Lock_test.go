@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/lock"
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+// testLocks checks the locks of a Locker of store
+func testLocks(t *testing.T, store storage.TempStore) {
+	ctx := context.Background()
+	locker := new(lock.Locker).New(store)
+
+	held, err := locker.TryAcquire(ctx, "job", time.Minute)
+	if err != nil {
+		t.Fatal("testLocks: expected to acquire a free lock, got", err)
+	}
+	if _, err := locker.TryAcquire(ctx, "job", time.Minute); !errors.Is(err, lock.ErrNotAcquired) {
+		t.Fatal("testLocks: expected ErrNotAcquired for a held lock, got", err)
+	}
+
+	// Acquire gives up after timeout
+	start := time.Now()
+	if _, err := locker.Acquire(ctx, "job", time.Minute, 120*time.Millisecond); !errors.Is(err, lock.ErrNotAcquired) {
+		t.Fatal("testLocks: expected ErrNotAcquired after timeout, got", err)
+	}
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Fatal("testLocks: expected Acquire to wait for the timeout, waited", waited)
+	}
+
+	if err := held.Renew(ctx); err != nil {
+		t.Fatal("testLocks: expected to renew a held lock, got", err)
+	}
+	if err := held.Release(ctx); err != nil {
+		t.Fatal("testLocks: expected to release a held lock, got", err)
+	}
+	if err := held.Release(ctx); !errors.Is(err, lock.ErrNotHeld) {
+		t.Fatal("testLocks: expected ErrNotHeld releasing twice, got", err)
+	}
+
+	// a lease ends without its owner, who may no longer renew or
+	// release the lock taken since
+	expiring, _ := locker.TryAcquire(ctx, "lease", 100*time.Millisecond)
+	next, err := locker.Acquire(ctx, "lease", time.Minute, time.Second)
+	if err != nil {
+		t.Fatal("testLocks: expected to acquire the lock once its lease ended, got", err)
+	}
+	if err := expiring.Renew(ctx); !errors.Is(err, lock.ErrNotHeld) {
+		t.Fatal("testLocks: expected ErrNotHeld renewing an ended lease, got", err)
+	}
+	if err := expiring.Release(ctx); !errors.Is(err, lock.ErrNotHeld) {
+		t.Fatal("testLocks: expected ErrNotHeld releasing an ended lease, got", err)
+	}
+	if err := next.Release(ctx); err != nil {
+		t.Fatal("testLocks: expected the lock to be left to its new owner, got", err)
+	}
+
+	// owners under contention never overlap
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holders, maxHolders, runs := 0, 0, 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := locker.WithLock(ctx, "contended", time.Second, 5*time.Second, func(ctx context.Context) error {
+				mu.Lock()
+				holders++
+				maxHolders = max(maxHolders, holders)
+				runs++
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error("testLocks: expected WithLock to succeed, got", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxHolders != 1 || runs != 8 {
+		t.Fatal("testLocks: expected 8 runs holding the lock one at a time, got", runs, maxHolders)
+	}
+
+	// WithLock renews the lease of a long run
+	err = locker.WithLock(ctx, "long", 150*time.Millisecond, 0, func(ctx context.Context) error {
+		time.Sleep(400 * time.Millisecond)
+		if _, err := locker.TryAcquire(ctx, "long", time.Minute); !errors.Is(err, lock.ErrNotAcquired) {
+			t.Error("testLocks: expected the lease to be renewed, got", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("testLocks: expected the long run to release its lock, got", err)
+	}
+	if _, err := locker.TryAcquire(ctx, "long", time.Minute); err != nil {
+		t.Fatal("testLocks: expected the lock to be released after the run, got", err)
+	}
+}
+
+func TestLocksMemory(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	testLocks(t, MTS)
+}
+
+func TestLocksFile(t *testing.T) {
+	beforeEachTSF()
+	defer afterEachTSF()
+
+	testLocks(t, TS)
+}
+
+func TestLocksRS(t *testing.T) {
+	beforeEachRSF()
+	defer afterEachRSF()
+
+	testLocks(t, RS)
+}
+
+func TestLockTempStoreOutage(t *testing.T) {
+	withUnreachableRedis(func() {
+		locker := new(lock.Locker).New(storage.MakeRedisWrapper(UNREACHABLE_REDIS_DB))
+		_, err := locker.Acquire(context.Background(), "job", time.Minute, time.Second)
+		if !errors.Is(err, storage.ErrBackend) || errors.Is(err, lock.ErrNotAcquired) {
+			t.Fatal("TestLockTempStoreOutage: expected ErrBackend, got", err)
+		}
+	})
+}
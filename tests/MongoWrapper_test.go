@@ -1,10 +1,14 @@
 package tests
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/Iyusuf40/goBackendUtils/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var mongo_database = "test"
@@ -201,12 +205,12 @@ func TestUpdateMWR(t *testing.T) {
 	id, _ := MONGO_WRAPPER.Save(user)
 	updated_name := "updated_name"
 
-	resp := MONGO_WRAPPER.Update(id, storage.UpdateDesc{
+	err := MONGO_WRAPPER.Update(id, storage.UpdateDesc{
 		Field: "name",
 		Value: updated_name})
 
-	if !resp {
-		t.Fatal("TestUpdate: failed to update")
+	if err != nil {
+		t.Fatal("TestUpdate: failed to update", err)
 	}
 
 	obj, _ := MONGO_WRAPPER.Get(id)
@@ -252,12 +256,12 @@ func TestUpdateMWR(t *testing.T) {
 
 	updated_nested_field := 2
 
-	resp = MONGO_WRAPPER.Update(id, storage.UpdateDesc{
+	err = MONGO_WRAPPER.Update(id, storage.UpdateDesc{
 		Field: "B.BA",
 		Value: updated_nested_field})
 
-	if !resp {
-		t.Fatal("TestUpdate: failed to update")
+	if err != nil {
+		t.Fatal("TestUpdate: failed to update", err)
 	}
 
 	obj, _ = MONGO_WRAPPER.Get(id)
@@ -269,6 +273,207 @@ func TestUpdateMWR(t *testing.T) {
 	}
 }
 
+func TestUpsertMWR(t *testing.T) {
+
+	beforeEachMWRT()
+	defer afterEachMWRT()
+
+	user := User{"test", 20}
+	id, created, err := MONGO_WRAPPER.Upsert("name", user.Name, user)
+
+	if err != nil || !created {
+		t.Fatal("TestUpsert: expected record to be created, err:", err)
+	}
+
+	user.Age = 30
+	updatedId, created, err := MONGO_WRAPPER.Upsert("name", user.Name, user)
+
+	if err != nil || created {
+		t.Fatal("TestUpsert: expected record to be updated, err:", err)
+	}
+
+	if updatedId != id {
+		t.Fatal("TestUpsert: expected id", id, "got", updatedId)
+	}
+
+	obj, _ := MONGO_WRAPPER.Get(id)
+	saved_user := new(User).buildUser(obj)
+
+	if saved_user.Age != user.Age {
+		t.Fatal("TestUpsert: expected age", user.Age, "got", saved_user.Age)
+	}
+
+	// test upsert by id
+	user.Name = "renamed"
+	_, created, err = MONGO_WRAPPER.Upsert("id", id, user)
+
+	if err != nil || created {
+		t.Fatal("TestUpsert: expected record to be updated by id, err:", err)
+	}
+
+	obj, _ = MONGO_WRAPPER.Get(id)
+	saved_user = new(User).buildUser(obj)
+
+	if saved_user.Name != user.Name {
+		t.Fatal("TestUpsert: expected name", user.Name, "got", saved_user.Name)
+	}
+
+	_, created, err = MONGO_WRAPPER.Upsert("id", primitive.NewObjectID().Hex(), user)
+
+	if err != nil || !created {
+		t.Fatal("TestUpsert: expected record to be created by id, err:", err)
+	}
+
+	if MONGO_WRAPPER.AllRecordsCount() != 2 {
+		t.Fatal("TestUpsert: all records count should be 2")
+	}
+}
+
+func TestUpdateIfVersionMWR(t *testing.T) {
+
+	config.SetTrackRecordMetadata(true)
+	defer config.SetTrackRecordMetadata(false)
+
+	beforeEachMWRT()
+	defer afterEachMWRT()
+
+	user := User{"test", 20}
+	id, _ := MONGO_WRAPPER.Save(user)
+
+	err := MONGO_WRAPPER.UpdateIfVersion(id, storage.UpdateDesc{Field: "age", Value: 21}, 1)
+	if err != nil {
+		t.Fatal("TestUpdateIfVersion: update of current version should pass, err:", err)
+	}
+
+	// version is now 2, a stale writer must fail
+	err = MONGO_WRAPPER.UpdateIfVersion(id, storage.UpdateDesc{Field: "age", Value: 22}, 1)
+
+	var conflict *storage.VersionConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatal("TestUpdateIfVersion: expected a VersionConflictError got", err)
+	}
+
+	if conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Fatal("TestUpdateIfVersion: expected conflict between 1 and 2 got", conflict)
+	}
+
+	obj, _ := MONGO_WRAPPER.Get(id)
+	saved_user := new(User).buildUser(obj)
+
+	if saved_user.Age != 21 {
+		t.Fatal("TestUpdateIfVersion: stale update should not be applied, age:", saved_user.Age)
+	}
+
+	err = MONGO_WRAPPER.UpdateIfVersion(primitive.NewObjectID().Hex(), storage.UpdateDesc{Field: "age", Value: 22}, 1)
+	if err == nil || errors.As(err, &conflict) {
+		t.Fatal("TestUpdateIfVersion: update of missing record should fail without conflict")
+	}
+}
+
+func TestWatchMWR(t *testing.T) {
+
+	beforeEachMWRT()
+	defer afterEachMWRT()
+
+	// change streams need a replica set
+	events, stop, err := MONGO_WRAPPER.Watch(storage.WatchFilter{})
+	if err != nil {
+		t.Skip("TestWatch: change streams unavailable", err)
+	}
+	defer stop()
+
+	id, _ := MONGO_WRAPPER.Save(User{"test", 20})
+	event := nextChangeEvent(t, events)
+	if event.Op != storage.INSERT_OP || event.ID != id || event.Data["name"] != "test" {
+		t.Fatal("TestWatch: expected insert event of", id, "got", event)
+	}
+
+	MONGO_WRAPPER.Update(id, storage.UpdateDesc{Field: "name", Value: "changed"})
+	event = nextChangeEvent(t, events)
+	if event.Op != storage.UPDATE_OP || event.ID != id || event.Data["name"] != "changed" {
+		t.Fatal("TestWatch: expected update event of", id, "got", event)
+	}
+
+	MONGO_WRAPPER.Delete(id)
+	event = nextChangeEvent(t, events)
+	if event.Op != storage.DELETE_OP || event.ID != id {
+		t.Fatal("TestWatch: expected delete event of", id, "got", event)
+	}
+}
+
+func TestSearchMWR(t *testing.T) {
+	beforeEachMWRT()
+	defer afterEachMWRT()
+
+	if _, err := MONGO_WRAPPER.Search("doe", []string{"name"}, 0); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestSearchMWR: expected ErrValidation without a search index got", err)
+	}
+
+	if err := MONGO_WRAPPER.CreateSearchIndex("name", "nickname"); err != nil {
+		t.Fatal("TestSearchMWR: expected no error got", err)
+	}
+
+	for _, record := range []map[string]any{
+		{"name": "John Doe", "nickname": "jd"},
+		{"name": "Jane Roe", "nickname": "doe"},
+		{"name": "Johnny Doe-Smith"},
+	} {
+		MONGO_WRAPPER.Save(record)
+	}
+
+	// test only the fields searched are matched
+	if results, err := MONGO_WRAPPER.Search("doe", []string{"name"}, 0); err != nil || len(results) != 2 {
+		t.Fatal("TestSearchMWR: expected 2 records got", results, err)
+	}
+
+	if results, _ := MONGO_WRAPPER.Search("doe", []string{"nickname"}, 0); len(results) != 1 {
+		t.Fatal("TestSearchMWR: expected 1 record got", results)
+	}
+
+	// test every term must match a whole word
+	if results, _ := MONGO_WRAPPER.Search("JOHN doe", []string{"name"}, 0); len(results) != 1 {
+		t.Fatal("TestSearchMWR: expected John Doe only got", results)
+	}
+
+	if results, _ := MONGO_WRAPPER.Search("jo", []string{"name"}, 0); len(results) != 0 {
+		t.Fatal("TestSearchMWR: expected no prefix match got", results)
+	}
+}
+
+func TestSaveWithExpiryMWR(t *testing.T) {
+	beforeEachMWRT()
+	defer afterEachMWRT()
+
+	MONGO_WRAPPER.Save(User{"permanent", 20})
+	id, err := MONGO_WRAPPER.SaveWithOptions(User{"expiring", 30}, storage.SaveOptions{TTL: time.Millisecond * 50})
+	if err != nil {
+		t.Fatal("TestSaveWithExpiryMWR: save should succeed;", err)
+	}
+
+	if obj, err := MONGO_WRAPPER.Get(id); obj == nil || err != nil {
+		t.Fatal("TestSaveWithExpiryMWR: record should be returned before it expires")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	if _, err := MONGO_WRAPPER.Get(id); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestSaveWithExpiryMWR: expected ErrNotFound got", err)
+	}
+
+	if records, _ := MONGO_WRAPPER.GetRecordsByField("name", "expiring"); len(records) != 0 {
+		t.Fatal("TestSaveWithExpiryMWR: expired record should not be found by field")
+	}
+
+	if len(MONGO_WRAPPER.GetAllOfRecords()) != 1 {
+		t.Fatal("TestSaveWithExpiryMWR: expired record should not be listed")
+	}
+
+	MONGO_WRAPPER.PurgeExpired()
+	if MONGO_WRAPPER.AllRecordsCount() != 1 {
+		t.Fatal("TestSaveWithExpiryMWR: expired record should be purged")
+	}
+}
+
 func TestDeleteMWR(t *testing.T) {
 
 	beforeEachMWRT()
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+// receive returns the next message of sub, failing t if none comes
+func receive(t *testing.T, sub *storage.Subscription) storage.Message {
+	select {
+	case message := <-sub.Messages():
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("receive: expected a message")
+	}
+	return storage.Message{}
+}
+
+// testPubSub checks the subscriptions of pubsub
+func testPubSub(t *testing.T, pubsub storage.PubSub) {
+	ctx := context.Background()
+
+	users, err := pubsub.Subscribe(ctx, "user:updated")
+	if err != nil {
+		t.Fatal("testPubSub: expected to subscribe, got", err)
+	}
+	defer users.Unsubscribe()
+
+	sessions, err := pubsub.PSubscribe(ctx, "session:*")
+	if err != nil {
+		t.Fatal("testPubSub: expected to subscribe to a pattern, got", err)
+	}
+
+	if received, err := pubsub.Publish(ctx, "user:updated", "id1"); received != 1 || err != nil {
+		t.Fatal("testPubSub: expected one subscription to receive the message, got", received, err)
+	}
+	message := receive(t, users)
+	if message.Channel != "user:updated" || message.Payload != "id1" || message.Pattern != "" {
+		t.Fatal("testPubSub: expected the message published, got", message)
+	}
+
+	pubsub.Publish(ctx, "session:revoked", "session1")
+	message = receive(t, sessions)
+	if message.Channel != "session:revoked" || message.Pattern != "session:*" || message.Payload != "session1" {
+		t.Fatal("testPubSub: expected the message of the pattern, got", message)
+	}
+
+	// other channels are not received
+	if received, _ := pubsub.Publish(ctx, "user:deleted", "id1"); received != 0 {
+		t.Fatal("testPubSub: expected no subscription to receive the message, got", received)
+	}
+
+	// messages received before unsubscribing can still be read
+	pubsub.Publish(ctx, "session:revoked", "session2")
+	time.Sleep(50 * time.Millisecond)
+	if err := sessions.Unsubscribe(); err != nil {
+		t.Fatal("testPubSub: expected to unsubscribe, got", err)
+	}
+	if err := sessions.Unsubscribe(); err != nil {
+		t.Fatal("testPubSub: expected unsubscribing twice to succeed, got", err)
+	}
+	if message = receive(t, sessions); message.Payload != "session2" {
+		t.Fatal("testPubSub: expected the message received before unsubscribing, got", message)
+	}
+	if _, open := <-sessions.Messages(); open {
+		t.Fatal("testPubSub: expected the messages to be closed once unsubscribed")
+	}
+	if received, _ := pubsub.Publish(ctx, "session:revoked", "session3"); received != 0 {
+		t.Fatal("testPubSub: expected no subscription once unsubscribed, got", received)
+	}
+
+	if _, err := pubsub.Subscribe(ctx); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("testPubSub: expected ErrValidation subscribing to nothing, got", err)
+	}
+}
+
+func TestMemoryPubSub(t *testing.T) {
+	testPubSub(t, new(storage.MemoryPubSub).New())
+}
+
+func TestMemoryPubSubPatterns(t *testing.T) {
+	ctx := context.Background()
+	pubsub := new(storage.MemoryPubSub).New()
+
+	matches := map[string][]string{
+		"user.?":      {"user.1", "user.a", "user.*"},
+		"user.[0-9]*": {"user.1", "user.12"},
+		"user.[^0-9]": {"user.a", "user.*"},
+		`user.\*`:     {"user.*"},
+		"*":           {"user.1", "user.12", "user.a", "user.*"},
+	}
+	for pattern, channels := range matches {
+		sub, _ := pubsub.PSubscribe(ctx, pattern)
+		for _, channel := range []string{"user.1", "user.12", "user.a", "user.*"} {
+			pubsub.Publish(ctx, channel, "")
+		}
+		sub.Unsubscribe()
+
+		received := []string{}
+		for message := range sub.Messages() {
+			received = append(received, message.Channel)
+		}
+		if len(received) != len(channels) {
+			t.Fatal("TestMemoryPubSubPatterns: expected", pattern, "to match", channels, "got", received)
+		}
+		for i := range channels {
+			if received[i] != channels[i] {
+				t.Fatal("TestMemoryPubSubPatterns: expected", pattern, "to match", channels, "got", received)
+			}
+		}
+	}
+}
+
+func TestPubSubRS(t *testing.T) {
+	beforeEachRSF()
+	defer afterEachRSF()
+
+	testPubSub(t, storage.MakeRedisPubSub(TEST_DB))
+}
+
+func TestPubSubOutage(t *testing.T) {
+	withUnreachableRedis(func() {
+		pubsub := storage.MakeRedisPubSub(UNREACHABLE_REDIS_DB)
+		ctx := context.Background()
+
+		if _, err := pubsub.Publish(ctx, "channel", "message"); !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestPubSubOutage: expected ErrBackend publishing, got", err)
+		}
+		if _, err := pubsub.Subscribe(ctx, "channel"); !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestPubSubOutage: expected ErrBackend subscribing, got", err)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Iyusuf40/goBackendUtils/api/controllers"
+	"github.com/Iyusuf40/goBackendUtils/config"
+	"github.com/Iyusuf40/goBackendUtils/models"
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+var signup_temp_test_db_path = "signup_temp_test_db"
+var signup_users_test_db_path = "signup_users_test_db"
+var signup_test_recordsName = "users"
+
+var SIGNUP_HANDLER *controllers.SignupHandler
+
+func beforeEachSIGNUP_TEST() {
+	SIGNUP_HANDLER = controllers.MakeSignupHandler(signup_temp_test_db_path,
+		signup_users_test_db_path, signup_test_recordsName)
+}
+
+func afterEachSIGNUP_TEST() {
+	storage.RemoveDbSingleton(signup_temp_test_db_path, signup_test_recordsName)
+	storage.RemoveDbSingleton(signup_users_test_db_path, signup_test_recordsName)
+	os.Remove(signup_temp_test_db_path)
+	os.Remove(signup_users_test_db_path)
+}
+
+func TestHandleCompleteSignupOnce(t *testing.T) {
+	if config.DBMS != "file" || config.TempStoreType != "file" {
+		t.Skip("TestHandleCompleteSignupOnce: cleans up file stores only")
+	}
+
+	beforeEachSIGNUP_TEST()
+	defer afterEachSIGNUP_TEST()
+
+	email := "signup@mail.com"
+	signupId := SIGNUP_HANDLER.HandleSignup(models.User{Email: email, Password: "xxxxxxxx"})
+	if signupId == "" {
+		t.Fatal("TestHandleCompleteSignupOnce: expected a signupId")
+	}
+
+	userId, err := SIGNUP_HANDLER.HandleCompleteSignup(signupId)
+	if err != nil || userId == "" {
+		t.Fatal("TestHandleCompleteSignupOnce: expected signup to complete, got", err)
+	}
+
+	_, err = SIGNUP_HANDLER.HandleCompleteSignup(signupId)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestHandleCompleteSignupOnce: expected ErrNotFound completing twice, got", err)
+	}
+
+	// concurrent clicks on the link save the user once
+	email = "concurrent@mail.com"
+	signupId = SIGNUP_HANDLER.HandleSignup(models.User{Email: email, Password: "xxxxxxxx"})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	completed := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := SIGNUP_HANDLER.HandleCompleteSignup(signupId); err == nil {
+				mu.Lock()
+				completed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if completed != 1 {
+		t.Fatal("TestHandleCompleteSignupOnce: expected one completion, got", completed)
+	}
+
+	users := storage.MakeUserStorage(signup_users_test_db_path, signup_test_recordsName)
+	if len(users.GetByField("email", email)) != 1 {
+		t.Fatal("TestHandleCompleteSignupOnce: expected one user saved")
+	}
+}
+
+func TestSignupHandlerTempStoreOutage(t *testing.T) {
+	storeType := config.TempStoreType
+	defer config.SetTempStoreType(storeType)
+	config.SetTempStoreType("redis")
+
+	withUnreachableRedis(func() {
+		beforeEachSIGNUP_TEST()
+		defer afterEachSIGNUP_TEST()
+
+		ctx := context.Background()
+		signupId, err := SIGNUP_HANDLER.HandleSignupContext(ctx, models.User{Email: "outage@mail.com"})
+		if signupId != "" || !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestSignupHandlerTempStoreOutage: expected ErrBackend signing up, got", signupId, err)
+		}
+
+		// a signup that cannot be read is not a missing one
+		_, err = SIGNUP_HANDLER.HandleCompleteSignupContext(ctx, "signupId")
+		if !errors.Is(err, storage.ErrBackend) || errors.Is(err, storage.ErrNotFound) {
+			t.Fatal("TestSignupHandlerTempStoreOutage: expected ErrBackend completing, got", err)
+		}
+	})
+}
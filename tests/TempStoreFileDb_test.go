@@ -1,7 +1,11 @@
 package tests
 
 import (
+	"errors"
+	"maps"
 	"os"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -97,14 +101,14 @@ func TestSetKeyToValWithExpiry(t *testing.T) {
 	}
 
 	// value should still exists after half duration
-	time.Sleep(time.Second * time.Duration(expiry/2))
+	time.Sleep(time.Duration(expiry / 2 * float64(time.Second)))
 	got = TS.GetVal(key)
 	if got != val {
 		t.Fatal("TestSetKeyToValWIthExpiry: expected value to be " + val + " got " + got)
 	}
 
 	// value should not exist after duration
-	time.Sleep(time.Second * time.Duration(int(expiry)))
+	time.Sleep(time.Duration(expiry/2*float64(time.Second)) + 100*time.Millisecond)
 	got = TS.GetVal(key)
 	if got != "" {
 		t.Fatal("TestSetKeyToValWIthExpiry: expected value to be empty, got " + got)
@@ -140,9 +144,395 @@ func TestChangeKeyExpiry(t *testing.T) {
 
 	// value should not exist after half duration, since
 	// it has been shortened
-	time.Sleep(time.Second * time.Duration(expiry/2))
+	time.Sleep(time.Duration(expiry/2*float64(time.Second)) + 100*time.Millisecond)
 	got = TS.GetVal(key)
 	if got != "" {
 		t.Fatal("TestSetKeyToValWIthExpiry: expected value to be empty, got " + got)
 	}
 }
+
+// testCounters checks the counters of store, expiry is the
+// shortest expiry, in seconds, store honours
+func testCounters(t *testing.T, store storage.TempStore, expiry float64) {
+	key := "counter"
+	store.DelKey(key)
+
+	count, err := store.Incr(key, 0)
+	if err != nil || count != 1 {
+		t.Fatal("testCounters: expected count to be 1, got", count, err)
+	}
+	count, _ = store.IncrBy(key, 5, 0)
+	if count != 6 {
+		t.Fatal("testCounters: expected count to be 6, got", count)
+	}
+	count, _ = store.Decr(key, 0)
+	if count != 5 {
+		t.Fatal("testCounters: expected count to be 5, got", count)
+	}
+	if got := store.GetVal(key); got != "5" {
+		t.Fatal("testCounters: expected value to be 5, got " + got)
+	}
+
+	store.SetKeyToVal("text", "abc")
+	if _, err = store.Incr("text", 0); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("testCounters: expected incrementing a non integer to fail, got", err)
+	}
+	if _, err = store.Incr("text", expiry); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("testCounters: expected incrementing a non integer with an expiry to fail, got", err)
+	}
+	if got := store.GetVal("text"); got != "abc" {
+		t.Fatal("testCounters: expected value to be left as abc, got " + got)
+	}
+
+	// increments are atomic
+	key = "concurrent"
+	store.DelKey(key)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if _, err := store.Incr(key, 0); err != nil {
+					t.Error("testCounters: failed to increment:", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := store.GetVal(key); got != "200" {
+		t.Fatal("testCounters: expected value to be 200, got " + got)
+	}
+
+	// the expiry is set on the first increment only
+	key = "expiring"
+	store.DelKey(key)
+	store.Incr(key, expiry)
+	count, _ = store.Incr(key, expiry*100)
+	if count != 2 {
+		t.Fatal("testCounters: expected count to be 2, got", count)
+	}
+
+	time.Sleep(time.Duration(expiry * 2 * float64(time.Second)))
+	if got := store.GetVal(key); got != "" {
+		t.Fatal("testCounters: expected counter to have expired, got " + got)
+	}
+	count, _ = store.Incr(key, expiry)
+	if count != 1 {
+		t.Fatal("testCounters: expected expired counter to restart at 1, got", count)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	beforeEachTSF()
+	defer afterEachTSF()
+
+	testCounters(t, TS, 1)
+}
+
+// testAtomicOps checks SetNX, GetAndDelete, CompareAndSwap and
+// GetTTL of store, expiry is the shortest expiry, in seconds,
+// store honours
+func testAtomicOps(t *testing.T, store storage.TempStore, expiry float64) {
+	key := "atomic"
+	store.DelKey(key)
+
+	if store.GetTTL(key) != storage.TTL_NO_KEY {
+		t.Fatal("testAtomicOps: expected TTL_NO_KEY, got", store.GetTTL(key))
+	}
+
+	if !store.SetNX(key, "first", 0) {
+		t.Fatal("testAtomicOps: expected SetNX of a missing key to set it")
+	}
+	if store.SetNX(key, "second", 0) {
+		t.Fatal("testAtomicOps: expected SetNX of an existing key not to set it")
+	}
+	if got := store.GetVal(key); got != "first" {
+		t.Fatal("testAtomicOps: expected value to be first, got " + got)
+	}
+	if store.GetTTL(key) != storage.TTL_NO_EXPIRY {
+		t.Fatal("testAtomicOps: expected TTL_NO_EXPIRY, got", store.GetTTL(key))
+	}
+
+	if store.CompareAndSwap(key, "second", "third") {
+		t.Fatal("testAtomicOps: expected CompareAndSwap of another value to fail")
+	}
+	if !store.CompareAndSwap(key, "first", "third") {
+		t.Fatal("testAtomicOps: expected CompareAndSwap to succeed")
+	}
+	if got := store.GetVal(key); got != "third" {
+		t.Fatal("testAtomicOps: expected value to be third, got " + got)
+	}
+	if store.CompareAndSwap("missing", "", "value") {
+		t.Fatal("testAtomicOps: expected CompareAndSwap of a missing key to fail")
+	}
+
+	if got := store.GetAndDelete(key); got != "third" {
+		t.Fatal("testAtomicOps: expected GetAndDelete to return third, got " + got)
+	}
+	if got := store.GetAndDelete(key); got != "" {
+		t.Fatal("testAtomicOps: expected key to be deleted, got " + got)
+	}
+
+	// only one of concurrent callers gets the value
+	store.SetKeyToVal(key, "token")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	got := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if store.GetAndDelete(key) == "token" {
+				mu.Lock()
+				got++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if got != 1 {
+		t.Fatal("testAtomicOps: expected one caller to get the value, got", got)
+	}
+
+	// expiries are kept by CompareAndSwap, and end SetNX's hold
+	store.SetNX(key, "expiring", expiry*10)
+	if ttl := store.GetTTL(key); ttl <= 0 || ttl > expiry*10 {
+		t.Fatal("testAtomicOps: expected ttl to be within", expiry*10, "got", ttl)
+	}
+	store.ChangeKeyEpiry(key, expiry)
+	store.CompareAndSwap(key, "expiring", "swapped")
+	time.Sleep(time.Duration(expiry * 2 * float64(time.Second)))
+
+	if store.GetTTL(key) != storage.TTL_NO_KEY {
+		t.Fatal("testAtomicOps: expected key to have expired, got ttl", store.GetTTL(key))
+	}
+	if !store.SetNX(key, "after expiry", 0) {
+		t.Fatal("testAtomicOps: expected SetNX of an expired key to set it")
+	}
+}
+
+func TestAtomicOps(t *testing.T) {
+	beforeEachTSF()
+	defer afterEachTSF()
+
+	testAtomicOps(t, TS, 1)
+}
+
+// testScan checks Scan, DelPrefix and Count of store, which should
+// hold no keys starting with a: or [
+func testScan(t *testing.T, store storage.TempStore) {
+	store.SetKeyToVal("a:1", "value")
+	store.SetKeyToValWIthExpiry("a:2", "value", 60)
+	store.SetKeyToVal("b:1", "value")
+	store.SetKeyToValWIthExpiry("a:expired", "value", -1)
+
+	keys := store.Scan("a:")
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"a:1", "a:2"}) {
+		t.Fatal("testScan: expected keys a:1 and a:2, got", keys)
+	}
+	if store.Count("a:") != 2 {
+		t.Fatal("testScan: expected 2 keys, got", store.Count("a:"))
+	}
+	if !slices.Contains(store.Scan(""), "b:1") {
+		t.Fatal("testScan: expected scanning all keys to return b:1")
+	}
+
+	// prefixes are matched literally
+	store.SetKeyToVal("[a]*1", "value")
+	store.SetKeyToVal("a1", "value")
+	if keys = store.Scan("[a]*"); !slices.Equal(keys, []string{"[a]*1"}) {
+		t.Fatal("testScan: expected keys [a]*1, got", keys)
+	}
+	store.DelKey("[a]*1")
+	store.DelKey("a1")
+
+	if deleted := store.DelPrefix("a:"); deleted != 2 {
+		t.Fatal("testScan: expected 2 keys deleted, got", deleted)
+	}
+	if store.Count("a:") != 0 || store.GetVal("a:1") != "" {
+		t.Fatal("testScan: expected keys starting with a: to be deleted")
+	}
+	if store.GetVal("b:1") != "value" {
+		t.Fatal("testScan: expected b:1 not to be deleted")
+	}
+	store.DelKey("b:1")
+}
+
+func TestScan(t *testing.T) {
+	beforeEachTSF()
+	defer afterEachTSF()
+
+	testScan(t, TS)
+}
+
+// testStructuredValues checks the hashes, sets and lists of store,
+// expiry is the shortest expiry, in seconds, store honours
+func testStructuredValues(t *testing.T, store storage.TempStore, expiry float64) {
+	for _, key := range []string{"hash", "set", "list", "string"} {
+		store.DelKey(key)
+	}
+
+	// hashes
+	if !store.HSet("hash", map[string]string{"name": "john", "role": "admin"}) {
+		t.Fatal("testStructuredValues: failed to set hash fields")
+	}
+	store.HSet("hash", map[string]string{"role": "user"})
+	if got := store.HGet("hash", "role"); got != "user" {
+		t.Fatal("testStructuredValues: expected role to be user, got " + got)
+	}
+	if got := store.HGetAll("hash"); !maps.Equal(got, map[string]string{"name": "john", "role": "user"}) {
+		t.Fatal("testStructuredValues: expected name and role fields, got", got)
+	}
+	if deleted := store.HDel("hash", "role", "missing"); deleted != 1 {
+		t.Fatal("testStructuredValues: expected 1 field deleted, got", deleted)
+	}
+
+	// sets
+	if added := store.SAdd("set", "a", "b", "a"); added != 2 {
+		t.Fatal("testStructuredValues: expected 2 members added, got", added)
+	}
+	if added := store.SAdd("set", "b", "c"); added != 1 {
+		t.Fatal("testStructuredValues: expected 1 member added, got", added)
+	}
+	if removed := store.SRem("set", "a", "missing"); removed != 1 {
+		t.Fatal("testStructuredValues: expected 1 member removed, got", removed)
+	}
+	members := store.SMembers("set")
+	slices.Sort(members)
+	if !slices.Equal(members, []string{"b", "c"}) {
+		t.Fatal("testStructuredValues: expected members b and c, got", members)
+	}
+
+	// lists
+	store.RPush("list", "b", "c")
+	if length := store.LPush("list", "a", "z"); length != 4 {
+		t.Fatal("testStructuredValues: expected a length of 4, got", length)
+	}
+	if got := store.LRange("list", 0, -1); !slices.Equal(got, []string{"z", "a", "b", "c"}) {
+		t.Fatal("testStructuredValues: expected z a b c, got", got)
+	}
+	if got := store.LRange("list", -2, 10); !slices.Equal(got, []string{"b", "c"}) {
+		t.Fatal("testStructuredValues: expected b c, got", got)
+	}
+	if got := store.LRange("list", 3, 1); len(got) != 0 {
+		t.Fatal("testStructuredValues: expected an empty range, got", got)
+	}
+	if store.LPop("list") != "z" || store.RPop("list") != "c" {
+		t.Fatal("testStructuredValues: expected to pop z and c")
+	}
+
+	// emptied keys are deleted
+	store.LPop("list")
+	store.LPop("list")
+	if store.LPop("list") != "" || store.GetTTL("list") != storage.TTL_NO_KEY {
+		t.Fatal("testStructuredValues: expected the emptied list to be deleted")
+	}
+
+	// keys of another kind are left alone
+	store.SetKeyToVal("string", "value")
+	if store.HSet("string", map[string]string{"a": "b"}) || store.SAdd("string", "a") != 0 ||
+		store.RPush("string", "a") != 0 || store.HGet("string", "a") != "" {
+		t.Fatal("testStructuredValues: expected a string key not to be used as a hash, set or list")
+	}
+	if store.GetVal("hash") != "" || store.GetAndDelete("set") != "" {
+		t.Fatal("testStructuredValues: expected a hash or set not to be read as a string")
+	}
+	if len(store.SMembers("set")) != 2 || store.GetVal("string") != "value" {
+		t.Fatal("testStructuredValues: expected set and string to be left as they were")
+	}
+
+	// keys of every kind expire
+	for _, key := range []string{"hash", "set"} {
+		store.ChangeKeyEpiry(key, expiry)
+	}
+	store.RPush("list", "a")
+	store.ChangeKeyEpiry("list", expiry)
+	store.RPush("list", "b")
+	time.Sleep(time.Duration(expiry * 2 * float64(time.Second)))
+
+	if len(store.HGetAll("hash")) != 0 || len(store.SMembers("set")) != 0 ||
+		len(store.LRange("list", 0, -1)) != 0 {
+		t.Fatal("testStructuredValues: expected hash, set and list to have expired")
+	}
+	if store.RPush("list", "c") != 1 {
+		t.Fatal("testStructuredValues: expected an expired list to restart empty")
+	}
+	store.DelKey("list")
+	store.DelKey("string")
+}
+
+func TestStructuredValues(t *testing.T) {
+	beforeEachTSF()
+	defer afterEachTSF()
+
+	testStructuredValues(t, TS, 1)
+
+	// structured values read back from the file
+	TS.HSet("hash", map[string]string{"name": "john"})
+	TS.SAdd("set", "a")
+	TS.RPush("list", "a", "b")
+	storage.RemoveDbSingleton(temp_test_db_path, "T")
+	beforeEachTSF()
+
+	if TS.HGet("hash", "name") != "john" || !slices.Equal(TS.SMembers("set"), []string{"a"}) ||
+		!slices.Equal(TS.LRange("list", 0, -1), []string{"a", "b"}) {
+		t.Fatal("TestStructuredValues: expected structured values to be reloaded")
+	}
+}
+
+// testDurationExpiries checks SetWithTTL, Expire, Persist and TTL of
+// store, and that expiries are not rounded to the second
+func testDurationExpiries(t *testing.T, store storage.TempStore) {
+	key := "expiring"
+	store.DelKey(key)
+
+	if store.TTL(key) != storage.TTL_NO_KEY {
+		t.Fatal("testDurationExpiries: expected TTL_NO_KEY, got", store.TTL(key))
+	}
+
+	store.SetWithTTL(key, "value", 1500*time.Millisecond)
+	if ttl := store.TTL(key); ttl <= time.Second || ttl > 1500*time.Millisecond {
+		t.Fatal("testDurationExpiries: expected a ttl of about 1.5s, got", ttl)
+	}
+	if ttl := store.GetTTL(key); ttl <= 1 || ttl > 1.5 {
+		t.Fatal("testDurationExpiries: expected GetTTL to be about 1.5, got", ttl)
+	}
+
+	if !store.Persist(key) || store.TTL(key) != storage.TTL_NO_EXPIRY {
+		t.Fatal("testDurationExpiries: expected Persist to remove the expiry, got", store.TTL(key))
+	}
+	if store.Persist(key) || store.Persist("missing") {
+		t.Fatal("testDurationExpiries: expected Persist of a key without expiry to do nothing")
+	}
+
+	if !store.Expire(key, 200*time.Millisecond) {
+		t.Fatal("testDurationExpiries: expected Expire of an existing key to report it")
+	}
+	if store.Expire("missing", time.Second) {
+		t.Fatal("testDurationExpiries: expected Expire of a missing key to report it missing")
+	}
+	if ttl := store.TTL(key); ttl <= 0 || ttl > 200*time.Millisecond {
+		t.Fatal("testDurationExpiries: expected a ttl of at most 200ms, got", ttl)
+	}
+
+	// sub-second expiries, in seconds too, are kept
+	store.SetKeyToValWIthExpiry("seconds", "value", 0.2)
+	time.Sleep(300 * time.Millisecond)
+	if store.GetVal(key) != "" || store.GetVal("seconds") != "" {
+		t.Fatal("testDurationExpiries: expected the keys to expire after 200ms")
+	}
+
+	store.SetWithTTL(key, "value", 0)
+	if store.TTL(key) != storage.TTL_NO_EXPIRY || store.GetVal(key) != "value" {
+		t.Fatal("testDurationExpiries: expected a ttl of 0 to set no expiry")
+	}
+}
+
+func TestDurationExpiries(t *testing.T) {
+	beforeEachTSF()
+	defer afterEachTSF()
+
+	testDurationExpiries(t, TS)
+}
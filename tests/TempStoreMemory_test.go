@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+var memory_snapshot_test_path = "memory_temp_store_test_snapshot.json"
+var MTS *storage.MemoryTempStore
+
+func beforeEachMTS() {
+	MTS = new(storage.MemoryTempStore).New(storage.MemoryTempStoreOptions{})
+}
+
+func afterEachMTS() {
+	MTS.Close()
+	os.Remove(memory_snapshot_test_path)
+}
+
+func TestMemoryTempStoreSetGetAndDel(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	key := "key"
+	if got := MTS.GetVal(key); got != "" {
+		t.Fatal("TestMemoryTempStoreSetGetAndDel: expected value to be empty, got " + got)
+	}
+
+	val := "value"
+	if !MTS.SetKeyToVal(key, val) {
+		t.Fatal("TestMemoryTempStoreSetGetAndDel: failed to set value")
+	}
+	if got := MTS.GetVal(key); got != val {
+		t.Fatal("TestMemoryTempStoreSetGetAndDel: expected value to be " + val + " got " + got)
+	}
+
+	if !MTS.DelKey(key) {
+		t.Fatal("TestMemoryTempStoreSetGetAndDel: failed to delete value")
+	}
+	if got := MTS.GetVal(key); got != "" {
+		t.Fatal("TestMemoryTempStoreSetGetAndDel: expected value to be empty, got " + got)
+	}
+}
+
+func TestMemoryTempStoreExpiry(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	MTS.SetKeyToValWIthExpiry("short", "value", 0.05)
+	MTS.SetKeyToValWIthExpiry("long", "value", 60)
+	MTS.SetKeyToValWIthExpiry("shortened", "value", 60)
+	MTS.SetKeyToValWIthExpiry("lengthened", "value", 0.05)
+	MTS.SetKeyToVal("forever", "value")
+
+	if !MTS.ChangeKeyEpiry("shortened", 0.05) || !MTS.ChangeKeyEpiry("lengthened", 60) {
+		t.Fatal("TestMemoryTempStoreExpiry: failed to change expiry")
+	}
+	if MTS.ChangeKeyEpiry("missing", 60) {
+		t.Fatal("TestMemoryTempStoreExpiry: changing the expiry of a missing key should fail")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	for _, key := range []string{"short", "shortened"} {
+		if got := MTS.GetVal(key); got != "" {
+			t.Fatal("TestMemoryTempStoreExpiry: expected " + key + " to have expired, got " + got)
+		}
+	}
+	for _, key := range []string{"long", "lengthened", "forever"} {
+		if got := MTS.GetVal(key); got != "value" {
+			t.Fatal("TestMemoryTempStoreExpiry: expected " + key + " to be value, got " + got)
+		}
+	}
+
+	// the sweeper erased the expired keys without them being read
+	if MTS.Len() != 3 {
+		t.Fatal("TestMemoryTempStoreExpiry: expected 3 keys left, got", MTS.Len())
+	}
+
+	// resetting a key drops its expiry
+	MTS.SetKeyToValWIthExpiry("reset", "value", 0.05)
+	MTS.SetKeyToVal("reset", "value")
+	time.Sleep(200 * time.Millisecond)
+	if got := MTS.GetVal("reset"); got != "value" {
+		t.Fatal("TestMemoryTempStoreExpiry: expected reset key to be value, got " + got)
+	}
+}
+
+func TestMemoryTempStoreCounters(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	testCounters(t, MTS, 0.05)
+}
+
+func TestMemoryTempStoreAtomicOps(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	testAtomicOps(t, MTS, 0.05)
+}
+
+func TestMemoryTempStoreDurationExpiries(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	testDurationExpiries(t, MTS)
+}
+
+func TestMemoryTempStoreScan(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	testScan(t, MTS)
+}
+
+func TestMemoryTempStoreStructuredValues(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	testStructuredValues(t, MTS, 0.05)
+}
+
+func TestMemoryTempStoreConcurrentUse(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprintf("%d-%d", worker, j)
+				MTS.SetKeyToValWIthExpiry(key, key, 60)
+				if got := MTS.GetVal(key); got != key {
+					t.Error("TestMemoryTempStoreConcurrentUse: expected " + key + " got " + got)
+				}
+				if j%2 == 0 {
+					MTS.DelKey(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if MTS.Len() != 16*100 {
+		t.Fatal("TestMemoryTempStoreConcurrentUse: expected", 16*100, "keys, got", MTS.Len())
+	}
+}
+
+func TestMemoryTempStoreSnapshot(t *testing.T) {
+	os.Remove(memory_snapshot_test_path)
+	options := storage.MemoryTempStoreOptions{
+		SnapshotPath:     memory_snapshot_test_path,
+		SnapshotInterval: 50 * time.Millisecond,
+	}
+
+	MTS = new(storage.MemoryTempStore).New(options)
+	defer afterEachMTS()
+
+	MTS.SetKeyToVal("forever", "value")
+	MTS.SetKeyToValWIthExpiry("long", "value", 60)
+	MTS.SetKeyToValWIthExpiry("short", "value", 0.05)
+
+	// snapshots are taken in the background
+	time.Sleep(150 * time.Millisecond)
+	if _, err := os.Stat(memory_snapshot_test_path); err != nil {
+		t.Fatal("TestMemoryTempStoreSnapshot: expected a snapshot to be taken:", err)
+	}
+
+	// and on close
+	MTS.SetKeyToVal("late", "value")
+	MTS.HSet("hash", map[string]string{"name": "john"})
+	MTS.RPush("list", "a", "b")
+	if err := MTS.Close(); err != nil {
+		t.Fatal("TestMemoryTempStoreSnapshot: failed to close:", err)
+	}
+
+	MTS = new(storage.MemoryTempStore).New(options)
+	for _, key := range []string{"forever", "long", "late"} {
+		if got := MTS.GetVal(key); got != "value" {
+			t.Fatal("TestMemoryTempStoreSnapshot: expected " + key + " to be restored, got " + got)
+		}
+	}
+	if MTS.HGet("hash", "name") != "john" || len(MTS.LRange("list", 0, -1)) != 2 {
+		t.Fatal("TestMemoryTempStoreSnapshot: expected structured values to be restored")
+	}
+	if got := MTS.GetVal("short"); got != "" {
+		t.Fatal("TestMemoryTempStoreSnapshot: expected expired key not to be restored, got " + got)
+	}
+
+	// restored keys are swept once they expire
+	MTS.ChangeKeyEpiry("long", 0.05)
+	time.Sleep(150 * time.Millisecond)
+	if MTS.Len() != 4 {
+		t.Fatal("TestMemoryTempStoreSnapshot: expected 4 keys left, got", MTS.Len())
+	}
+}
+
+func TestGetMemoryTempStore(t *testing.T) {
+	store := storage.GET_TempStore("memory", "memory_test_db", "T")
+	defer storage.RemoveMemoryTempStoreSingleton("memory_test_db", "T")
+
+	if _, ok := store.(*storage.MemoryTempStore); !ok {
+		t.Fatal("TestGetMemoryTempStore: expected a MemoryTempStore")
+	}
+
+	store.SetKeyToVal("key", "value")
+	if got := storage.GET_TempStore("memory", "memory_test_db", "T").GetVal("key"); got != "value" {
+		t.Fatal("TestGetMemoryTempStore: expected the same store to be returned, got " + got)
+	}
+}
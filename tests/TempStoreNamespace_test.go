@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+func TestNamespacedTempStore(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	sessions := storage.MakeNamespacedTempStore(MTS, "session")
+	signups := storage.MakeNamespacedTempStore(MTS, "signup")
+
+	sessions.SetKeyToVal("id", "session value")
+	signups.SetKeyToValWIthExpiry("id", "signup value", 60)
+	signups.SetKeyToVal("other", "signup value")
+
+	if got := sessions.GetVal("id"); got != "session value" {
+		t.Fatal("TestNamespacedTempStore: expected session value, got " + got)
+	}
+	if got := signups.GetVal("id"); got != "signup value" {
+		t.Fatal("TestNamespacedTempStore: expected signup value, got " + got)
+	}
+	if got := MTS.GetVal("session:id"); got != "session value" {
+		t.Fatal("TestNamespacedTempStore: expected keys to be stored as namespace:key, got " + got)
+	}
+
+	keys := signups.Scan("")
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"id", "other"}) {
+		t.Fatal("TestNamespacedTempStore: expected keys id and other, got", keys)
+	}
+	if signups.Count("") != 2 || sessions.Count("") != 1 {
+		t.Fatal("TestNamespacedTempStore: expected 2 signups and 1 session")
+	}
+
+	if signups.GetTTL("id") <= 0 || signups.GetTTL("other") != storage.TTL_NO_EXPIRY {
+		t.Fatal("TestNamespacedTempStore: expected ttls to be read in the namespace")
+	}
+
+	if deleted := signups.DelPrefix(""); deleted != 2 {
+		t.Fatal("TestNamespacedTempStore: expected 2 keys deleted, got", deleted)
+	}
+	if sessions.GetVal("id") != "session value" {
+		t.Fatal("TestNamespacedTempStore: expected sessions not to be deleted")
+	}
+
+	// namespaces nest
+	acme := storage.MakeNamespacedTempStore(sessions, "acme")
+	acme.SetKeyToVal("id", "acme value")
+	if got := MTS.GetVal("session:acme:id"); got != "acme value" {
+		t.Fatal("TestNamespacedTempStore: expected nested namespaces, got " + got)
+	}
+	if got := acme.GetAndDelete("id"); got != "acme value" || sessions.Count("") != 1 {
+		t.Fatal("TestNamespacedTempStore: expected acme:id to be deleted, got " + got)
+	}
+}
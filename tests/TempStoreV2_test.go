@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Iyusuf40/goBackendUtils/config"
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+// the db of the redis withUnreachableRedis points to
+var UNREACHABLE_REDIS_DB = 11
+
+// withUnreachableRedis runs test with config.RedisUrl and
+// config.RedisDb pointing to a redis that refuses connections
+func withUnreachableRedis(test func()) {
+	url, db, dialTimeout := config.RedisUrl, config.RedisDb, config.RedisDialTimeout
+	defer func() {
+		storage.RemoveRedisWrapperSingleton(UNREACHABLE_REDIS_DB)
+		config.SetRedisUrl(url)
+		config.SetRedisDb(db)
+		config.RedisDialTimeout = dialTimeout
+	}()
+
+	config.SetRedisUrl("127.0.0.1:1")
+	config.SetRedisDb(UNREACHABLE_REDIS_DB)
+	config.RedisDialTimeout = 100 * time.Millisecond
+	test()
+}
+
+func TestTempStoreV2Of(t *testing.T) {
+	beforeEachMTS()
+	defer afterEachMTS()
+
+	ctx := context.Background()
+	store := storage.TempStoreV2Of(MTS)
+
+	if _, found, err := store.Get(ctx, "key"); found || err != nil {
+		t.Fatal("TestTempStoreV2Of: expected a missing key not to be found, got", found, err)
+	}
+
+	// an empty value is told from a missing key
+	if err := store.Set(ctx, "empty", "", 0); err != nil {
+		t.Fatal("TestTempStoreV2Of: expected Set to succeed, got", err)
+	}
+	if value, found, err := store.Get(ctx, "empty"); value != "" || !found || err != nil {
+		t.Fatal("TestTempStoreV2Of: expected an empty value to be found, got", value, found, err)
+	}
+	if ttl, found, _ := store.TTL(ctx, "empty"); ttl != storage.TTL_NO_EXPIRY || !found {
+		t.Fatal("TestTempStoreV2Of: expected no expiry, got", ttl, found)
+	}
+
+	store.Set(ctx, "key", "value", time.Minute)
+	if ttl, found, _ := store.TTL(ctx, "key"); ttl <= 0 || ttl > time.Minute || !found {
+		t.Fatal("TestTempStoreV2Of: expected a ttl of at most a minute, got", ttl, found)
+	}
+	if persisted, _ := store.Persist(ctx, "key"); !persisted {
+		t.Fatal("TestTempStoreV2Of: expected the expiry to be removed")
+	}
+	if ttl, _, _ := store.TTL(ctx, "key"); ttl != storage.TTL_NO_EXPIRY {
+		t.Fatal("TestTempStoreV2Of: expected no expiry once persisted, got", ttl)
+	}
+	if deleted, _ := store.Delete(ctx, "key"); !deleted {
+		t.Fatal("TestTempStoreV2Of: expected the key to be deleted")
+	}
+	if deleted, _ := store.Delete(ctx, "key"); deleted {
+		t.Fatal("TestTempStoreV2Of: expected a missing key not to be deleted")
+	}
+	if _, found, _ := store.TTL(ctx, "key"); found {
+		t.Fatal("TestTempStoreV2Of: expected no ttl for a missing key")
+	}
+
+	store.HSet(ctx, "hash", map[string]string{"field": ""})
+	if _, found, _ := store.HGet(ctx, "hash", "field"); !found {
+		t.Fatal("TestTempStoreV2Of: expected an empty field to be found")
+	}
+
+	store.Set(ctx, "string", "value", 0)
+	if _, err := store.IncrBy(ctx, "string", 1, 0); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestTempStoreV2Of: expected ErrValidation incrementing a string, got", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := store.Get(cancelled, "string"); !errors.Is(err, storage.ErrBackend) {
+		t.Fatal("TestTempStoreV2Of: expected ErrBackend once ctx ended, got", err)
+	}
+
+	// namespaces are kept
+	namespaced := storage.TempStoreV2Of(storage.MakeNamespacedTempStore(MTS, "ns"))
+	namespaced.Set(ctx, "key", "value", 0)
+	if MTS.GetVal("ns:key") != "value" {
+		t.Fatal("TestTempStoreV2Of: expected the key to be namespaced")
+	}
+	if keys, _ := namespaced.Scan(ctx, ""); !slices.Equal(keys, []string{"key"}) {
+		t.Fatal("TestTempStoreV2Of: expected the keys of the namespace, got", keys)
+	}
+
+	// the TempStore of a TempStoreV2 is unwrapped
+	legacy := storage.MakeLegacyTempStore(store)
+	if legacy.GetVal("string") != "value" || storage.TempStoreV2Of(legacy) != store {
+		t.Fatal("TestTempStoreV2Of: expected the legacy store to wrap store")
+	}
+}
+
+// redis connects lazily, so an unreachable one is only found out
+// when used
+func TestRedisStoreUnreachable(t *testing.T) {
+	withUnreachableRedis(func() {
+		ctx := context.Background()
+		store := storage.MakeRedisStore(UNREACHABLE_REDIS_DB)
+
+		if _, found, err := store.Get(ctx, "key"); found || !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestRedisStoreUnreachable: expected ErrBackend, got", found, err)
+		}
+		if err := store.Set(ctx, "key", "value", 0); !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestRedisStoreUnreachable: expected ErrBackend setting, got", err)
+		}
+		if _, err := store.Scan(ctx, ""); !errors.Is(err, storage.ErrBackend) {
+			t.Fatal("TestRedisStoreUnreachable: expected ErrBackend scanning, got", err)
+		}
+
+		// the TempStore reads failures as missing keys
+		wrapper := storage.MakeRedisWrapper(UNREACHABLE_REDIS_DB)
+		if wrapper.GetVal("key") != "" || wrapper.SetKeyToVal("key", "value") {
+			t.Fatal("TestRedisStoreUnreachable: expected the TempStore to read and write nothing")
+		}
+		if storage.TempStoreV2Of(wrapper) != store {
+			t.Fatal("TestRedisStoreUnreachable: expected the RedisStore of the wrapper")
+		}
+	})
+}
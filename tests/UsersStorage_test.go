@@ -1,9 +1,13 @@
 package tests
 
 import (
+	"errors"
 	"os"
 	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/Iyusuf40/goBackendUtils/models"
@@ -38,12 +42,12 @@ func TestSaveAndGetUser(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	id, success := US.Save(user)
-	if !success {
-		t.Fatal("TestSaveAndGetUser: success should be true;", id)
+	id, err := US.Save(user)
+	if err != nil {
+		t.Fatal("TestSaveAndGetUser: save should succeed;", err)
 	}
 
 	retrievedUser, _ := US.Get(id)
@@ -53,8 +57,14 @@ func TestSaveAndGetUser(t *testing.T) {
 	}
 
 	// test user with similar mail cannot be duplicated
-	if _, success = US.Save(user); success {
-		t.Fatal("TestSaveAndGetUser: success should be false")
+	if _, err = US.Save(user); !errors.Is(err, storage.ErrDuplicate) {
+		t.Fatal("TestSaveAndGetUser: expected ErrDuplicate got", err)
+	}
+
+	// test user with an invalid email is not saved
+	user.Email = "not-an-email"
+	if _, err = US.Save(user); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestSaveAndGetUser: expected ErrValidation got", err)
 	}
 }
 
@@ -67,15 +77,15 @@ func TestUpdateUser(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
 	updateField := "phone"
 	updateValue := 9000
 
-	id, success := US.Save(user)
-	if !success {
-		t.Fatal("TestUpdateUser: success should be true; msg:", id)
+	id, err := US.Save(user)
+	if err != nil {
+		t.Fatal("TestUpdateUser: save should succeed;", err)
 	}
 
 	retrievedUser, _ := US.Get(id)
@@ -91,13 +101,21 @@ func TestUpdateUser(t *testing.T) {
 	if retrievedUser.Phone != updateValue {
 		t.Fatal("TestUpdateUser: retrievedUser.Phone should be equal", updateValue)
 	}
+
+	// test update with a value of the wrong type
+	err = US.Update(id, storage.UpdateDesc{Field: updateField, Value: "not a number"})
+
+	var validationErr *storage.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Fields[updateField] == "" {
+		t.Fatal("TestUpdateUser: expected phone to fail validation got", err)
+	}
 }
 
 func TestUpdateUserPassword(t *testing.T) {
 	beforeEachUST()
 	defer afterEachUST()
 
-	password := "xxx"
+	password := "xxxxxxxx"
 
 	user := models.User{
 		Email:     "testmail@mail.com",
@@ -107,14 +125,14 @@ func TestUpdateUserPassword(t *testing.T) {
 		Password:  password,
 	}
 
-	newPass := "yyy"
+	newPass := "yyyyyyyy"
 
 	updateField := "password"
 	updateValue := newPass
 
-	id, success := US.Save(user)
-	if !success {
-		t.Fatal("TestUpdateUserPassword: success should be true; msg:", id)
+	id, err := US.Save(user)
+	if err != nil {
+		t.Fatal("TestUpdateUserPassword: save should succeed;", err)
 	}
 
 	retrievedUser, _ := US.Get(id)
@@ -130,6 +148,98 @@ func TestUpdateUserPassword(t *testing.T) {
 	if !retrievedUser.IsCorrectPassword(newPass) {
 		t.Fatal("TestUpdateUser: retrievedUser.Phone should be equal", updateValue)
 	}
+
+	// test the password, not its hash, is validated
+	err = US.Update(id, storage.UpdateDesc{Field: updateField, Value: "short"})
+	if !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestUpdateUserPassword: update to a short password should fail validation, err:", err)
+	}
+}
+
+func TestUpsertUser(t *testing.T) {
+	beforeEachUST()
+	defer afterEachUST()
+
+	user := models.User{
+		Email:     "testmail@mail.com",
+		FirstName: "f_name",
+		LastName:  "l_name",
+		Phone:     8000,
+		Password:  "xxxxxxxx",
+	}
+
+	id, created, err := US.Upsert("email", user.Email, user)
+	if err != nil || !created {
+		t.Fatal("TestUpsertUser: expected user to be created, err:", err)
+	}
+
+	user.Phone = 9000
+	updatedId, created, err := US.Upsert("email", user.Email, user)
+	if err != nil || created {
+		t.Fatal("TestUpsertUser: expected user to be updated, err:", err)
+	}
+
+	if updatedId != id {
+		t.Fatal("TestUpsertUser: expected id", id, "got", updatedId)
+	}
+
+	retrievedUser, _ := US.Get(id)
+
+	if usersAreEqual(retrievedUser, user) == false {
+		t.Fatal("TestUpsertUser: retrievedUser should be equal to upserted")
+	}
+
+	if !retrievedUser.IsCorrectPassword(user.Password) {
+		t.Fatal("TestUpsertUser: password should be hashed and match")
+	}
+
+	if len(US.GetAll()) != 1 {
+		t.Fatal("TestUpsertUser: there should be only one user")
+	}
+
+	// test another user cannot take an existing email
+	other := user
+	other.Email = "othermail@mail.com"
+	otherId, _, _ := US.Upsert("email", other.Email, other)
+
+	if _, _, err = US.Upsert("id", otherId, user); err == nil {
+		t.Fatal("TestUpsertUser: upsert to an existing email should fail")
+	}
+
+	if _, _, err = US.Upsert("email", other.Email, user); !errors.Is(err, storage.ErrDuplicate) {
+		t.Fatal("TestUpsertUser: upsert by email to another existing email should fail, err:", err)
+	}
+
+	// test invalid user
+	if _, _, err = US.Upsert("email", "", models.User{}); err == nil {
+		t.Fatal("TestUpsertUser: upsert of invalid user should fail")
+	}
+}
+
+func TestUpsertUserByEmailPOSTGRES(t *testing.T) {
+	dbms := config.DBMS
+	config.SetDBMS("postgres")
+	defer config.SetDBMS(dbms)
+
+	users := storage.MakeUserStorage(users_storage_test_db_path, "upserted_users")
+	defer storage.RemovePostgressEngineSingleton(users_storage_test_db_path, "upserted_users", true)
+
+	user := models.User{Email: "upserted@mail.com", Password: "xxxxxxxx", Phone: 8000}
+	id, created, err := users.Upsert("email", user.Email, user)
+	if err != nil || !created {
+		t.Fatal("TestUpsertUserByEmailPOSTGRES: expected user to be created, err:", err)
+	}
+
+	user.Phone = 9000
+	updatedId, created, err := users.Upsert("email", user.Email, user)
+	if err != nil || created || updatedId != id {
+		t.Fatal("TestUpsertUserByEmailPOSTGRES: expected user", id, "to be updated got", updatedId, err)
+	}
+
+	retrieved := users.GetByField("email", user.Email)
+	if len(retrieved) != 1 || retrieved[0].Phone != 9000 {
+		t.Fatal("TestUpsertUserByEmailPOSTGRES: expected one updated user got", retrieved)
+	}
 }
 
 func TestDeleteUser(t *testing.T) {
@@ -141,12 +251,12 @@ func TestDeleteUser(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	id, success := US.Save(user)
-	if !success {
-		t.Fatal("TestDeleteUser: success should be true")
+	id, err := US.Save(user)
+	if err != nil {
+		t.Fatal("TestDeleteUser: save should succeed;", err)
 	}
 
 	retrievedUser, _ := US.Get(id)
@@ -155,15 +265,115 @@ func TestDeleteUser(t *testing.T) {
 		t.Fatal("TestDeleteUser: retrievedUser should be equal to saved")
 	}
 
-	US.Delete(id)
-	retrievedUser, err := US.Get(id)
+	if err = US.Delete(id); err != nil {
+		t.Fatal("TestDeleteUser: delete should succeed;", err)
+	}
+	retrievedUser, err = US.Get(id)
 
 	if usersAreEqual(retrievedUser, models.User{}) != true {
 		t.Fatal("TestDeleteUser: retrievedUser should be empty")
 	}
 
-	if err == nil {
-		t.Fatal("TestDeleteUser: getting nonexistent user should return error")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestDeleteUser: getting nonexistent user should return ErrNotFound got", err)
+	}
+
+	if err = US.Delete(id); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestDeleteUser: deleting nonexistent user should return ErrNotFound got", err)
+	}
+}
+
+func TestUserStorageHooks(t *testing.T) {
+	beforeEachUST()
+	defer afterEachUST()
+
+	calls := []string{}
+
+	// hooks should run in order of registration, after the
+	// password hashing hook registered by MakeUserStorage
+	US.Hooks().BeforeSave(func(user *models.User) error {
+		calls = append(calls, "beforeSave1")
+		user.Email = strings.ToLower(user.Email)
+		return nil
+	})
+	US.Hooks().BeforeSave(func(user *models.User) error {
+		calls = append(calls, "beforeSave2")
+		if user.FirstName == "vetoed" {
+			return errors.New("first name not allowed")
+		}
+		return nil
+	})
+	US.Hooks().AfterSave(func(id string, user models.User) {
+		calls = append(calls, "afterSave")
+	})
+	US.Hooks().BeforeUpdate(func(id string, data *storage.UpdateDesc) error {
+		calls = append(calls, "beforeUpdate")
+		if data.Field == "firstName" {
+			data.Value = strings.TrimSpace(data.Value.(string))
+		}
+		return nil
+	})
+	US.Hooks().AfterUpdate(func(id string, data storage.UpdateDesc) {
+		calls = append(calls, "afterUpdate")
+	})
+	US.Hooks().BeforeDelete(func(id string) error {
+		calls = append(calls, "beforeDelete")
+		return errors.New("deletes are disabled")
+	})
+	US.Hooks().AfterDelete(func(id string) {
+		calls = append(calls, "afterDelete")
+	})
+	US.Hooks().AfterGet(func(user *models.User) {
+		user.Password = ""
+	})
+
+	user := models.User{
+		Email:     "TestMail@Mail.com",
+		FirstName: "f_name",
+		LastName:  "l_name",
+		Phone:     8000,
+		Password:  "xxxxxxxx",
+	}
+
+	id, err := US.Save(user)
+	if err != nil {
+		t.Fatal("TestUserStorageHooks: save should succeed;", err)
+	}
+
+	US.Update(id, storage.UpdateDesc{Field: "firstName", Value: "  spaced "})
+	US.Delete(id)
+
+	retrievedUser, err := US.Get(id)
+	if err != nil {
+		t.Fatal("TestUserStorageHooks: vetoed delete should keep user, err:", err)
+	}
+
+	if retrievedUser.Email != "testmail@mail.com" {
+		t.Fatal("TestUserStorageHooks: email should be normalized got", retrievedUser.Email)
+	}
+
+	if retrievedUser.FirstName != "spaced" {
+		t.Fatal("TestUserStorageHooks: first name should be trimmed got", retrievedUser.FirstName)
+	}
+
+	if retrievedUser.Password != "" {
+		t.Fatal("TestUserStorageHooks: after get hook should clear password")
+	}
+
+	expectedCalls := []string{"beforeSave1", "beforeSave2", "afterSave",
+		"beforeUpdate", "afterUpdate", "beforeDelete"}
+	if !slices.Equal(calls, expectedCalls) {
+		t.Fatal("TestUserStorageHooks: expected calls", expectedCalls, "got", calls)
+	}
+
+	user.Email = "other@mail.com"
+	user.FirstName = "vetoed"
+	if _, err = US.Save(user); err == nil {
+		t.Fatal("TestUserStorageHooks: vetoed save should fail")
+	}
+
+	if len(US.GetByField("email", user.Email)) != 0 {
+		t.Fatal("TestUserStorageHooks: vetoed save should not save")
 	}
 }
 
@@ -177,12 +387,12 @@ func TestGetUserByField(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	_, success := US.Save(user)
-	if !success {
-		t.Fatal("TestGetUserByField: success should be true")
+	_, err := US.Save(user)
+	if err != nil {
+		t.Fatal("TestGetUserByField: save should succeed;", err)
 	}
 
 	retrievedUser := US.GetByField("email", email)[0]
@@ -202,12 +412,12 @@ func TestGetUserIdByField(t *testing.T) {
 		FirstName: "f_name",
 		LastName:  "l_name",
 		Phone:     8000,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	id, success := US.Save(user)
-	if !success {
-		t.Fatal("TestGetUserIdByField: success should be true")
+	id, err := US.Save(user)
+	if err != nil {
+		t.Fatal("TestGetUserIdByField: save should succeed;", err)
 	}
 
 	retrievedId := US.GetIdByField("email", email)
@@ -236,11 +446,11 @@ func TestGetAllUsers(t *testing.T) {
 			FirstName: "f_name",
 			LastName:  "l_name",
 			Phone:     8000,
-			Password:  "xxx",
+			Password:  "xxxxxxxx",
 		}
-		_, success := US.Save(user)
-		if !success {
-			t.Fatal("TestGetUserByField: success should be true")
+		_, err := US.Save(user)
+		if err != nil {
+			t.Fatal("TestGetUserByField: save should succeed;", err)
 		}
 	}
 
@@ -257,6 +467,289 @@ func TestGetAllUsers(t *testing.T) {
 	}
 }
 
+func TestSearchUsers(t *testing.T) {
+	beforeEachUST()
+	defer afterEachUST()
+
+	for _, user := range []models.User{
+		{Email: "ada@mail.com", FirstName: "Ada", LastName: "Lovelace", Password: "lovelace"},
+		{Email: "alan@mail.com", FirstName: "Alan", LastName: "Turing", Password: "lovelace"},
+	} {
+		if _, err := US.Save(user); err != nil {
+			t.Fatal("TestSearchUsers: save should succeed;", err)
+		}
+	}
+
+	hits, err := US.Search("lovelace", nil, 0)
+	if err != nil || len(hits) != 1 || hits[0].Data.Email != "ada@mail.com" || hits[0].ID == "" {
+		t.Fatal("TestSearchUsers: expected Ada only got", hits, err)
+	}
+
+	if hits, _ = US.Search("mail", []string{"email"}, 1); len(hits) != 1 {
+		t.Fatal("TestSearchUsers: expected limit to be applied got", hits)
+	}
+
+	// test passwords cannot be searched
+	if _, err = US.Search("lovelace", []string{"password"}, 0); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestSearchUsers: expected ErrValidation got", err)
+	}
+}
+
+func TestImportUsers(t *testing.T) {
+	beforeEachUST()
+	defer afterEachUST()
+
+	US.Save(models.User{Email: "existing@mail.com", Password: "xxxxxxxx"})
+	saved := len(US.GetAll())
+
+	csv := "Email,First Name,phone,password\n" +
+		"ada@mail.com,Ada,8000,plaintext\n" +
+		"existing@mail.com,Existing,1,xxxxxxxx\n" +
+		"alan@mail.com,Alan,not-a-number,xxxxxxxx\n" +
+		"not-an-email,Bad,1,xxxxxxxx\n" +
+		"ada@mail.com,Again,1,xxxxxxxx\n"
+
+	// test a dry run reports the failing rows without saving any
+	report, err := US.Import(strings.NewReader(csv), storage.ImportOptions{Format: storage.CSV_FORMAT, DryRun: true})
+	if err != nil || report.Rows != 5 || report.Imported != 1 || len(report.Failed) != 4 {
+		t.Fatal("TestImportUsers: expected 1 of 5 rows to pass got", report, err)
+	}
+
+	if len(US.GetAll()) != saved {
+		t.Fatal("TestImportUsers: a dry run should save no user")
+	}
+
+	rows := []int{}
+	for _, failed := range report.Failed {
+		rows = append(rows, failed.Row)
+	}
+	if !slices.Equal(rows, []int{3, 4, 5, 6}) {
+		t.Fatal("TestImportUsers: expected rows 3 to 6 to fail got", rows)
+	}
+
+	if !errors.Is(report.Failed[0], storage.ErrDuplicate) || !errors.Is(report.Failed[1], storage.ErrValidation) ||
+		!errors.Is(report.Failed[2], storage.ErrValidation) || !errors.Is(report.Failed[3], storage.ErrDuplicate) {
+		t.Fatal("TestImportUsers: unexpected row errors", report.Failed)
+	}
+
+	report, _ = US.Import(strings.NewReader(csv), storage.ImportOptions{Format: storage.CSV_FORMAT})
+	if report.Imported != 1 {
+		t.Fatal("TestImportUsers: expected 1 user to be imported got", report)
+	}
+
+	// test values are coerced and plaintext passwords hashed
+	users := US.GetByField("email", "ada@mail.com")
+	if len(users) != 1 || users[0].FirstName != "Ada" || users[0].Phone != 8000 ||
+		!users[0].IsCorrectPassword("plaintext") {
+		t.Fatal("TestImportUsers: unexpected imported user", users)
+	}
+
+	// test password hashes are kept
+	hash := users[0].Password
+	jsonl := `{"email": "grace@mail.com", "password": "` + hash + `"}` + "\n\n{not json}\n"
+	report, err = US.Import(strings.NewReader(jsonl), storage.ImportOptions{Format: storage.JSONL_FORMAT})
+	if err != nil || report.Imported != 1 || len(report.Failed) != 1 || report.Failed[0].Row != 3 {
+		t.Fatal("TestImportUsers: expected 1 user imported and row 3 to fail got", report, err)
+	}
+
+	if users = US.GetByField("email", "grace@mail.com"); len(users) != 1 || !users[0].IsCorrectPassword("plaintext") {
+		t.Fatal("TestImportUsers: expected the password hash to be kept")
+	}
+
+	// test unknown columns and formats fail the import
+	_, err = US.Import(strings.NewReader("email,unknown\n"), storage.ImportOptions{Format: storage.CSV_FORMAT})
+	if !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestImportUsers: expected ErrValidation for an unknown column got", err)
+	}
+
+	_, err = US.Import(strings.NewReader(""), storage.ImportOptions{Format: "xml"})
+	if !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestImportUsers: expected ErrValidation for an unknown format got", err)
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	beforeEachUST()
+	defer afterEachUST()
+
+	US.Save(models.User{Email: "ada@mail.com", FirstName: "Ada, Countess", Phone: 8000, Password: "xxxxxxxx"})
+
+	var out strings.Builder
+	err := US.Export(&out, storage.ExportOptions{Format: storage.CSV_FORMAT, Fields: []string{"email", "first name", "phone"}})
+	if err != nil || !strings.HasPrefix(out.String(), "email,firstName,phone\n") ||
+		!strings.Contains(out.String(), "\nada@mail.com,\"Ada, Countess\",8000\n") {
+		t.Fatal("TestExportUsers: unexpected csv export", out.String(), err)
+	}
+
+	// test password hashes are only exported when requested
+	out.Reset()
+	US.Export(&out, storage.ExportOptions{Format: storage.JSONL_FORMAT})
+	if strings.Contains(out.String(), "password") || !strings.Contains(out.String(), `"email":"ada@mail.com"`) {
+		t.Fatal("TestExportUsers: unexpected jsonl export", out.String())
+	}
+
+	err = US.Export(&out, storage.ExportOptions{Format: storage.CSV_FORMAT, Fields: []string{"password"}})
+	if !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestExportUsers: expected ErrValidation exporting password got", err)
+	}
+
+	out.Reset()
+	US.Export(&out, storage.ExportOptions{Format: storage.JSONL_FORMAT, IncludeSensitive: true})
+	if !strings.Contains(out.String(), `"password":"$2`) {
+		t.Fatal("TestExportUsers: expected the password hash to be exported", out.String())
+	}
+
+	// test an export can be imported back
+	afterEachUST()
+	beforeEachUST()
+	report, err := US.Import(strings.NewReader(out.String()), storage.ImportOptions{Format: storage.JSONL_FORMAT})
+	if err != nil || report.Imported < 1 {
+		t.Fatal("TestExportUsers: expected the export to be imported got", report, err)
+	}
+
+	if users := US.GetByField("email", "ada@mail.com"); len(users) != 1 || users[0].FirstName != "Ada, Countess" || !users[0].IsCorrectPassword("xxxxxxxx") {
+		t.Fatal("TestExportUsers: unexpected user imported back", users)
+	}
+}
+
+func TestUserStorageTenants(t *testing.T) {
+	if config.DBMS != "file" {
+		t.Skip("TestUserStorageTenants: cleans up file tenants only")
+	}
+
+	beforeEachUST()
+	defer afterEachUST()
+	defer afterEachTenant(users_storage_test_db_path, "users", "acme", "globex")
+
+	user := models.User{Email: "tenant@mail.com", Password: "xxxxxxxx"}
+
+	acme, err := US.ForTenant("acme")
+	if err != nil {
+		t.Fatal("TestUserStorageTenants: expected no error got", err)
+	}
+
+	globex, _ := US.ForTenant("globex")
+
+	if again, _ := US.ForTenant("acme"); again != acme {
+		t.Fatal("TestUserStorageTenants: expected the same Storage for a tenant")
+	}
+
+	id, err := acme.Save(user)
+	if err != nil {
+		t.Fatal("TestUserStorageTenants: save should succeed;", err)
+	}
+
+	// test other tenants do not see the user
+	if len(globex.GetByField("email", user.Email)) != 0 || len(US.GetByField("email", user.Email)) != 0 {
+		t.Fatal("TestUserStorageTenants: user should only be visible to its tenant")
+	}
+
+	if _, err = globex.Get(id); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal("TestUserStorageTenants: expected ErrNotFound got", err)
+	}
+
+	// test the same email can register with another tenant
+	if _, err = globex.Save(user); err != nil {
+		t.Fatal("TestUserStorageTenants: save in another tenant should succeed;", err)
+	}
+
+	// test hooks are shared by tenants
+	saved, _ := acme.Get(id)
+	if saved.Password == user.Password || !saved.IsCorrectPassword(user.Password) {
+		t.Fatal("TestUserStorageTenants: password should be hashed by the shared hooks")
+	}
+
+	if defaultUS, _ := acme.ForTenant(""); defaultUS != US {
+		t.Fatal("TestUserStorageTenants: expected the default Storage for tenant ''")
+	}
+
+	if _, err = US.ForTenant("../acme"); !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestUserStorageTenants: expected ErrValidation got", err)
+	}
+}
+
+// afterEachTenant removes the file dbs of tenants
+func TestUserStorageTenantsConcurrently(t *testing.T) {
+	beforeEachUST()
+	defer afterEachUST()
+	defer afterEachTenant(users_storage_test_db_path, "users", "acme")
+
+	// each UserStorage makes its tenant engines apart
+	storages := []storage.Storage[models.User]{US, storage.MakeUserStorage(users_storage_test_db_path, "users")}
+	tenantStorages := make([]storage.Storage[models.User], len(storages))
+
+	var wg sync.WaitGroup
+	for i, users := range storages {
+		wg.Add(1)
+		go func(i int, users storage.Storage[models.User]) {
+			defer wg.Done()
+			tenantStorages[i], _ = users.ForTenant("acme")
+		}(i, users)
+	}
+	wg.Wait()
+
+	first, firstOk := tenantStorages[0].(*storage.UserStorage)
+	second, secondOk := tenantStorages[1].(*storage.UserStorage)
+	if !firstOk || !secondOk || first.DB != second.DB {
+		t.Fatal("TestUserStorageTenantsConcurrently: expected both storages to share the engine of the tenant")
+	}
+}
+
+func TestPurgingDeletedOfTenants(t *testing.T) {
+	if config.DBMS != "file" {
+		t.Skip("TestPurgingDeletedOfTenants: cleans up file tenants only")
+	}
+
+	config.SetSoftDelete(true)
+	defer config.SetSoftDelete(false)
+
+	beforeEachUST()
+	defer afterEachUST()
+	defer afterEachTenant(users_storage_test_db_path, "users", "acme", "globex")
+
+	saveAndDelete := func(users storage.Storage[models.User]) {
+		id, err := users.Save(models.User{Email: "deleted@mail.com", Password: "xxxxxxxx"})
+		if err != nil {
+			t.Fatal("TestPurgingDeletedOfTenants: save should succeed;", err)
+		}
+		users.Delete(id)
+	}
+
+	acme, _ := US.ForTenant("acme")
+	saveAndDelete(acme)
+
+	stop := storage.StartPurgingDeletedOfTenants(US, 0, time.Millisecond*5)
+	defer stop()
+
+	// a tenant used after purging started is purged too
+	globex, _ := US.ForTenant("globex")
+	saveAndDelete(globex)
+	time.Sleep(time.Millisecond * 50)
+
+	for _, users := range []storage.Storage[models.User]{acme, globex} {
+		if deleted := users.GetAllIncludingDeleted(); len(deleted) != 0 {
+			t.Fatal("TestPurgingDeletedOfTenants: expected deleted users to be purged got", deleted)
+		}
+	}
+}
+
+func afterEachTenant(database, recordsName string, tenants ...string) {
+	for _, tenant := range tenants {
+		if config.DBMS == "postgres" {
+			storage.RemovePostgressEngineSingletonInSchema(database, tenant, recordsName, true)
+			continue
+		}
+
+		tenantDatabase := storage.TenantDatabase(config.DBMS, database, tenant)
+		if config.DBMS == "mongo" {
+			storage.RemoveMongoSingleton(tenantDatabase, recordsName, true)
+		} else {
+			storage.RemoveDbSingleton(tenantDatabase, recordsName)
+			os.Remove(tenantDatabase)
+		}
+	}
+}
+
 func usersAreEqual(u1 models.User, u2 models.User) bool {
 	if u1.Email != u2.Email ||
 		u1.FirstName != u2.FirstName ||
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Iyusuf40/goBackendUtils/storage"
+)
+
+type Role string
+
+type Member struct {
+	Email    string   `json:"email" validate:"required,email"`
+	Name     string   `json:"name" validate:"min=2,max=5"`
+	Age      int      `json:"age" validate:"min=18"`
+	Role     Role     `json:"role" validate:"oneof=admin user"`
+	Code     string   `json:"code" validate:"len=3,regex=^[a-z]{1,3}$"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Nickname string   `json:"nickname" validate:"even"`
+	Address  struct {
+		City string `json:"city" validate:"required"`
+	} `json:"address"`
+	Friends []struct {
+		Email string `json:"email" validate:"email"`
+	} `json:"friends"`
+}
+
+func TestValidate(t *testing.T) {
+	storage.RegisterValidator("even", func(value any, param string) error {
+		if len(value.(string))%2 != 0 {
+			return errors.New("must have an even length")
+		}
+		return nil
+	})
+
+	member := Member{Email: "member@mail.com", Name: "mem", Age: 20, Role: "admin",
+		Code: "abc", Tags: []string{"a"}, Nickname: "ab"}
+	member.Address.City = "Lagos"
+
+	if err := storage.Validate(member); err != nil {
+		t.Fatal("TestValidate: expected no error got", err)
+	}
+
+	if err := storage.Validate(&member); err != nil {
+		t.Fatal("TestValidate: pointer should be validated, expected no error got", err)
+	}
+
+	// test zero values only fail required
+	if err := storage.Validate(Member{Email: "member@mail.com", Address: member.Address}); err != nil {
+		t.Fatal("TestValidate: expected zero values to pass got", err)
+	}
+
+	member = Member{Email: "member", Name: "m", Age: 17, Role: "guest",
+		Code: "AB", Tags: []string{"a", "b", "c"}, Nickname: "abc"}
+	member.Friends = append(member.Friends, struct {
+		Email string `json:"email" validate:"email"`
+	}{Email: "friend"})
+
+	err := storage.Validate(member)
+
+	var validationErr *storage.ValidationError
+	if !errors.As(err, &validationErr) || !errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestValidate: expected a ValidationError got", err)
+	}
+
+	expected := map[string][]string{
+		"email":            {"email"},
+		"name":             {"min"},
+		"age":              {"min"},
+		"role":             {"oneof"},
+		"code":             {"len", "regex"},
+		"tags":             {"max"},
+		"nickname":         {"even"},
+		"address.city":     {"required"},
+		"friends[0].email": {"email"},
+	}
+
+	failed := map[string][]string{}
+	for _, fieldErr := range validationErr.Errors {
+		failed[fieldErr.Field] = append(failed[fieldErr.Field], fieldErr.Rule)
+	}
+
+	if len(failed) != len(expected) {
+		t.Fatal("TestValidate: expected", expected, "to fail got", failed)
+	}
+
+	for field, rules := range expected {
+		if strings.Join(failed[field], ",") != strings.Join(rules, ",") {
+			t.Fatal("TestValidate: expected", field, "to fail", rules, "got", failed[field])
+		}
+		if validationErr.Fields[field] == "" {
+			t.Fatal("TestValidate: expected", field, "in Fields got", validationErr.Fields)
+		}
+	}
+
+	if validationErr.Fields["age"] != "must be at least 18" {
+		t.Fatal("TestValidate: unexpected message for age:", validationErr.Fields["age"])
+	}
+}
+
+func TestValidateUnknownRule(t *testing.T) {
+	type Unruly struct {
+		Name string `json:"name" validate:"required,unregistered"`
+	}
+
+	err := storage.Validate(Unruly{Name: "name"})
+	if err == nil || errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestValidateUnknownRule: expected an error other than ErrValidation got", err)
+	}
+
+	// an unset field still has its rules checked
+	err = storage.Validate(Unruly{})
+	if err == nil || errors.Is(err, storage.ErrValidation) {
+		t.Fatal("TestValidateUnknownRule: expected an unset field to fail too got", err)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Iyusuf40/goBackendUtils/api/controllers"
 	"github.com/Iyusuf40/goBackendUtils/api/controllers/user_controller"
 	"github.com/Iyusuf40/goBackendUtils/config"
 	"github.com/Iyusuf40/goBackendUtils/models"
@@ -44,7 +46,7 @@ func TestPOSTUser(t *testing.T) {
 
 	e := echo.New()
 	userJSON := `{"data": {"firstName":"John", "lastName": "Doe","email":"mail@mail.com", 
-	"password": "xx", "phone": 90543434}}`
+	"password": "xxxxxxxx", "phone": 90543434}}`
 
 	// test successfully saving a user
 	headers := map[string]string{
@@ -62,9 +64,9 @@ func TestPOSTUser(t *testing.T) {
 	rec, c = SetupRequest(e, http.MethodPost, "/api/users", userJSON, headers)
 	user_controller.SaveUser(c)
 
-	if http.StatusBadRequest != rec.Code {
+	if http.StatusConflict != rec.Code {
 		fmt.Println("body returned", rec.Body.String())
-		t.Fatal("POST /api/users: expected:", http.StatusBadRequest, "got:", rec.Code)
+		t.Fatal("POST /api/users: expected:", http.StatusConflict, "got:", rec.Code)
 	}
 
 	// test failed saving of user with missing userfield
@@ -76,6 +78,33 @@ func TestPOSTUser(t *testing.T) {
 		fmt.Println("body returned", rec.Body.String())
 		t.Fatal("POST /api/users: expected:", http.StatusBadRequest, "got:", rec.Code)
 	}
+
+	body := controllers.ReadFromReaderIntoMap(rec.Body)
+	fields, _ := body["fields"].(map[string]any)
+	if fields["email"] == nil || fields["password"] == nil {
+		t.Fatal("POST /api/users: expected invalid fields email and password got:", fields)
+	}
+
+	// test failed rules are listed with the rule names
+	userJSON = `{"data": {"email":"not-an-email", "password": "xxxxxxxx", "phone": -1}}`
+	rec, c = SetupRequest(e, http.MethodPost, "/api/users", userJSON, headers)
+	user_controller.SaveUser(c)
+
+	if http.StatusBadRequest != rec.Code {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("POST /api/users: expected:", http.StatusBadRequest, "got:", rec.Code)
+	}
+
+	body = controllers.ReadFromReaderIntoMap(rec.Body)
+	failed, _ := body["errors"].([]any)
+	rules := map[string]any{}
+	for _, fieldErr := range failed {
+		fieldErr, _ := fieldErr.(map[string]any)
+		rules[fmt.Sprint(fieldErr["field"])] = fieldErr["rule"]
+	}
+	if len(rules) != 2 || rules["email"] != "email" || rules["phone"] != "min" {
+		t.Fatal("POST /api/users: expected email and min rules to fail got:", failed)
+	}
 }
 
 func TestGETUser(t *testing.T) {
@@ -87,13 +116,13 @@ func TestGETUser(t *testing.T) {
 		FirstName: "fname",
 		LastName:  "lname",
 		Phone:     999,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	id, saved := user_controller.UserStorage.Save(user)
+	id, err := user_controller.UserStorage.Save(user)
 
-	if !saved {
-		t.Fatal("GET /api/user/:id: expected: true got:", saved)
+	if err != nil {
+		t.Fatal("GET /api/user/:id: save should succeed;", err)
 	}
 
 	e := echo.New()
@@ -119,6 +148,89 @@ func TestGETUser(t *testing.T) {
 	}
 }
 
+func TestGETUserWithMetadata(t *testing.T) {
+	config.SetTrackRecordMetadata(true)
+	defer config.SetTrackRecordMetadata(false)
+
+	// Setup
+	beforeEachUAPIT()
+	defer afterEachUAPIT()
+
+	user := models.User{Email: "testmail2@mail.com",
+		FirstName: "fname",
+		LastName:  "lname",
+		Phone:     999,
+		Password:  "xxxxxxxx",
+	}
+
+	id, err := user_controller.UserStorage.Save(user)
+
+	if err != nil {
+		t.Fatal("GET /api/user/:id: save should succeed;", err)
+	}
+
+	e := echo.New()
+	rec, c := SetupRequest(e, http.MethodGet, "/api/users", "", nil)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	user_controller.GetUser(c)
+
+	if http.StatusOK != rec.Code {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("GET /api/users/:id : expected:", http.StatusOK, "got:", rec.Code)
+	}
+
+	recBody := controllers.ReadFromReaderIntoMap(rec.Body)
+
+	for _, field := range []string{"createdAt", "updatedAt", "version"} {
+		if _, ok := recBody[field].(float64); !ok {
+			t.Fatal("GET /api/users/:id : expected", field, "in response got:", recBody)
+		}
+	}
+
+	if _, ok := recBody["password"]; ok {
+		t.Fatal("GET /api/users/:id : password should not be in response")
+	}
+}
+
+func TestGETUsersSearch(t *testing.T) {
+	beforeEachUAPIT()
+	defer afterEachUAPIT()
+
+	user := models.User{Email: "grace@mail.com", FirstName: "Grace", LastName: "Hopper", Password: "xxxxxxxx"}
+	id, err := user_controller.UserStorage.Save(user)
+	if err != nil {
+		t.Fatal("GET /api/users: save should succeed;", err)
+	}
+
+	e := echo.New()
+	rec, c := SetupRequest(e, http.MethodGet, "/api/users?q=hop&limit=5", "", nil)
+	user_controller.SearchUsers(c)
+
+	if http.StatusOK != rec.Code {
+		t.Fatal("GET /api/users: expected:", http.StatusOK, "got:", rec.Code)
+	}
+
+	found, _ := controllers.ReadFromReaderIntoMap(rec.Body)["users"].([]any)
+	if len(found) != 1 {
+		t.Fatal("GET /api/users: expected 1 user got:", found)
+	}
+
+	foundUser, _ := found[0].(map[string]any)
+	if foundUser["id"] != id || foundUser["score"] == nil || foundUser["password"] != nil {
+		t.Fatal("GET /api/users: expected id and score without password got:", foundUser)
+	}
+
+	for _, route := range []string{"/api/users", "/api/users?q=hop&limit=0", "/api/users?q=hop&fields=password"} {
+		rec, c = SetupRequest(e, http.MethodGet, route, "", nil)
+		user_controller.SearchUsers(c)
+
+		if http.StatusBadRequest != rec.Code {
+			t.Fatal("GET", route, ": expected:", http.StatusBadRequest, "got:", rec.Code)
+		}
+	}
+}
+
 func TestPUTUser(t *testing.T) {
 	// Setup
 	beforeEachUAPIT()
@@ -128,13 +240,13 @@ func TestPUTUser(t *testing.T) {
 		FirstName: "fname",
 		LastName:  "lname",
 		Phone:     999,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	id, saved := user_controller.UserStorage.Save(user)
+	id, err := user_controller.UserStorage.Save(user)
 
-	if !saved {
-		t.Fatal("PUT /api/user/:id: expected: true got:", saved)
+	if err != nil {
+		t.Fatal("PUT /api/user/:id: save should succeed;", err)
 	}
 
 	newPhone := 99
@@ -165,6 +277,115 @@ func TestPUTUser(t *testing.T) {
 	}
 }
 
+func TestPUTUserIfMatch(t *testing.T) {
+	config.SetTrackRecordMetadata(true)
+	defer config.SetTrackRecordMetadata(false)
+
+	// Setup
+	beforeEachUAPIT()
+	defer afterEachUAPIT()
+
+	user := models.User{Email: "testmail@mail.com",
+		FirstName: "fname",
+		LastName:  "lname",
+		Phone:     999,
+		Password:  "xxxxxxxx",
+	}
+
+	id, err := user_controller.UserStorage.Save(user)
+
+	if err != nil {
+		t.Fatal("PUT /api/user/:id: save should succeed;", err)
+	}
+
+	e := echo.New()
+	rec, c := SetupRequest(e, http.MethodGet, "/api/users/:id", "", nil)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	user_controller.GetUser(c)
+
+	etag := rec.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatal("GET /api/users/:id : expected ETag \"1\" got:", etag)
+	}
+
+	upadateJSON := `{"data": {"field":"phone", "value": 100}}`
+	headers := map[string]string{
+		echo.HeaderContentType: echo.MIMEApplicationJSON,
+		"If-Match":             etag,
+	}
+
+	// test update with current ETag
+	rec, c = SetupRequest(e, http.MethodPut, "/api/users/:id", upadateJSON, headers)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	user_controller.UpdateUser(c)
+
+	if rec.Code != http.StatusOK {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("PUT /api/users/:id : expected:", http.StatusOK, "got:", rec.Code)
+	}
+
+	if rec.Header().Get("ETag") != `"2"` {
+		t.Fatal("PUT /api/users/:id : expected ETag \"2\" got:", rec.Header().Get("ETag"))
+	}
+
+	// test update with stale ETag
+	upadateJSON = `{"data": {"field":"phone", "value": 200}}`
+	rec, c = SetupRequest(e, http.MethodPut, "/api/users/:id", upadateJSON, headers)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	user_controller.UpdateUser(c)
+
+	if rec.Code != http.StatusPreconditionFailed {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("PUT /api/users/:id : expected:", http.StatusPreconditionFailed, "got:", rec.Code)
+	}
+
+	upadatedUser, _ := user_controller.UserStorage.Get(id)
+
+	if upadatedUser.Phone != 100 {
+		t.Fatal("PUT /api/users/:id : expected retrieved user.Phone:", 100,
+			"got:", upadatedUser.Phone)
+	}
+}
+
+func TestPUTUserIfMatchUntracked(t *testing.T) {
+	// Setup
+	beforeEachUAPIT()
+	defer afterEachUAPIT()
+
+	user := models.User{Email: "testmail@mail.com",
+		FirstName: "fname",
+		LastName:  "lname",
+		Phone:     999,
+		Password:  "xxxxxxxx",
+	}
+
+	id, err := user_controller.UserStorage.Save(user)
+
+	if err != nil {
+		t.Fatal("PUT /api/user/:id: save should succeed;", err)
+	}
+
+	upadateJSON := `{"data": {"field":"phone", "value": 100}}`
+	headers := map[string]string{
+		echo.HeaderContentType: echo.MIMEApplicationJSON,
+		"If-Match":             `"1"`,
+	}
+
+	e := echo.New()
+	rec, c := SetupRequest(e, http.MethodPut, "/api/users/:id", upadateJSON, headers)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	user_controller.UpdateUser(c)
+
+	if rec.Code != http.StatusPreconditionFailed {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("PUT /api/users/:id : expected:", http.StatusPreconditionFailed, "got:", rec.Code)
+	}
+}
+
 func TestDELETEUser(t *testing.T) {
 	// Setup
 	beforeEachUAPIT()
@@ -174,17 +395,17 @@ func TestDELETEUser(t *testing.T) {
 		FirstName: "fname",
 		LastName:  "lname",
 		Phone:     999,
-		Password:  "xxx",
+		Password:  "xxxxxxxx",
 	}
 
-	id, saved := user_controller.UserStorage.Save(user)
+	id, err := user_controller.UserStorage.Save(user)
 
-	if !saved {
-		t.Fatal("DELETE /api/user/:id: expected: true got:", saved)
+	if err != nil {
+		t.Fatal("DELETE /api/user/:id: save should succeed;", err)
 	}
 
 	// attempt to get user
-	_, err := user_controller.UserStorage.Get(id)
+	_, err = user_controller.UserStorage.Get(id)
 
 	if err != nil {
 		t.Fatal("DELETE /api/user/:id: expected error to be nil got:", err)
@@ -210,6 +431,184 @@ func TestDELETEUser(t *testing.T) {
 	}
 }
 
+func TestRESTOREUser(t *testing.T) {
+	config.SetSoftDelete(true)
+	defer config.SetSoftDelete(false)
+
+	// Setup
+	beforeEachUAPIT()
+	defer afterEachUAPIT()
+
+	user := models.User{Email: "testmail@mail.com",
+		FirstName: "fname",
+		LastName:  "lname",
+		Phone:     999,
+		Password:  "xxxxxxxx",
+	}
+
+	id, err := user_controller.UserStorage.Save(user)
+
+	if err != nil {
+		t.Fatal("POST /api/user/:id/restore: save should succeed;", err)
+	}
+
+	e := echo.New()
+	rec, c := SetupRequest(e, http.MethodDelete, "/api/users/:id", "", nil)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	user_controller.DeleteUser(c)
+
+	if _, err := user_controller.UserStorage.Get(id); err == nil {
+		t.Fatal("POST /api/user/:id/restore: deleted user should not be found")
+	}
+
+	if len(user_controller.UserStorage.GetAllIncludingDeleted()) != 1 {
+		t.Fatal("POST /api/user/:id/restore: deleted user should be listed with deleted users")
+	}
+
+	rec, c = SetupRequest(e, http.MethodPost, "/api/users/:id/restore", "", nil)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	user_controller.RestoreUser(c)
+
+	if rec.Code != http.StatusOK {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("POST /api/users/:id/restore : expected:", http.StatusOK, "got:", rec.Code)
+	}
+
+	if _, err := user_controller.UserStorage.Get(id); err != nil {
+		t.Fatal("POST /api/user/:id/restore: restored user should be found")
+	}
+
+	// test restoring a user whose email was taken after deletion
+	user_controller.UserStorage.Delete(id)
+	user_controller.UserStorage.Save(user)
+
+	deletedAt := func() int64 {
+		for _, user := range user_controller.UserStorage.GetAllIncludingDeleted() {
+			if user.DeletedAt != 0 {
+				return user.DeletedAt
+			}
+		}
+		return 0
+	}
+	deletedAtBefore := deletedAt()
+	time.Sleep(time.Millisecond * 2)
+
+	rec, c = SetupRequest(e, http.MethodPost, "/api/users/:id/restore", "", nil)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	user_controller.RestoreUser(c)
+
+	if rec.Code != http.StatusConflict {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("POST /api/users/:id/restore : expected:", http.StatusConflict, "got:", rec.Code)
+	}
+
+	if deletedAtBefore == 0 || deletedAt() != deletedAtBefore {
+		t.Fatal("POST /api/users/:id/restore: a refused restore should keep deletedAt", deletedAtBefore)
+	}
+
+	// test restoring a nonexistent user
+	rec, c = SetupRequest(e, http.MethodPost, "/api/users/:id/restore", "", nil)
+	c.SetParamNames("id")
+	c.SetParamValues("nonexistent")
+	user_controller.RestoreUser(c)
+
+	if rec.Code != http.StatusNotFound {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("POST /api/users/:id/restore : expected:", http.StatusNotFound, "got:", rec.Code)
+	}
+}
+
+func TestTenantUsers(t *testing.T) {
+	if config.DBMS != "file" {
+		t.Skip("TestTenantUsers: cleans up file tenants only")
+	}
+
+	// Setup
+	beforeEachUAPIT()
+	defer afterEachUAPIT()
+	defer afterEachTenant(users_api_test_db_path, users_api_test_recordsName, "acme", "globex")
+
+	config.SetMultiTenant(true)
+	config.SetTenantDomain("example.com")
+	defer config.SetMultiTenant(false)
+	defer config.SetTenantDomain("")
+
+	e := echo.New()
+	acmeHeaders := map[string]string{
+		echo.HeaderContentType: echo.MIMEApplicationJSON,
+		config.TenantHeader:    "acme",
+	}
+
+	userJSON := `{"data": {"email":"tenant@mail.com", "password": "xxxxxxxx"}}`
+	rec, c := SetupRequest(e, http.MethodPost, "/api/users", userJSON, acmeHeaders)
+	user_controller.SaveUser(c)
+
+	if http.StatusCreated != rec.Code {
+		fmt.Println("body returned", rec.Body.String())
+		t.Fatal("POST /api/users: expected:", http.StatusCreated, "got:", rec.Code)
+	}
+
+	userId := controllers.ReadFromReaderIntoMap(rec.Body)["userId"].(string)
+
+	getUser := func(headers map[string]string, host string) int {
+		rec, c := SetupRequest(e, http.MethodGet, "/", "", headers)
+		c.Request().Host = host
+		c.SetPath("/api/users/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(userId)
+		user_controller.GetUser(c)
+		return rec.Code
+	}
+
+	if code := getUser(acmeHeaders, ""); code != http.StatusOK {
+		t.Fatal("GET /api/users/:id: expected:", http.StatusOK, "got:", code)
+	}
+
+	// test other tenants do not see the user
+	if code := getUser(map[string]string{config.TenantHeader: "globex"}, ""); code != http.StatusNotFound {
+		t.Fatal("GET /api/users/:id: expected:", http.StatusNotFound, "got:", code)
+	}
+
+	if code := getUser(nil, ""); code != http.StatusNotFound {
+		t.Fatal("GET /api/users/:id: expected:", http.StatusNotFound, "got:", code)
+	}
+
+	// test tenant is read from the subdomain
+	if code := getUser(nil, "acme.example.com:8081"); code != http.StatusOK {
+		t.Fatal("GET /api/users/:id: expected:", http.StatusOK, "got:", code)
+	}
+
+	if code := getUser(map[string]string{config.TenantHeader: "../acme"}, ""); code != http.StatusBadRequest {
+		t.Fatal("GET /api/users/:id: expected:", http.StatusBadRequest, "got:", code)
+	}
+
+	// test sessions name their tenant
+	sessionStore := storage.GET_TempStore("file", users_api_test_db_path, users_api_test_recordsName)
+	controllers.SessionStore = sessionStore
+	defer func() { controllers.SessionStore = nil }()
+
+	sessionStore.SetKeyToVal("session", controllers.MakeTenantValue("acme", userId))
+	if code := getUser(map[string]string{config.SessionHeader: "session"}, ""); code != http.StatusOK {
+		t.Fatal("GET /api/users/:id: expected:", http.StatusOK, "got:", code)
+	}
+
+	headers := map[string]string{config.SessionHeader: "session", config.TenantHeader: "globex"}
+	if code := getUser(headers, ""); code != http.StatusForbidden {
+		t.Fatal("GET /api/users/:id: expected:", http.StatusForbidden, "got:", code)
+	}
+
+	// test sessions made before they had a namespace name their tenant
+	controllers.SessionStore = storage.MakeNamespacedTempStore(sessionStore, controllers.SESSIONS_NAMESPACE)
+	sessionStore.SetKeyToVal("legacy-session", controllers.MakeTenantValue("globex", userId))
+	headers = map[string]string{config.SessionHeader: "legacy-session", config.TenantHeader: "acme"}
+	if code := getUser(headers, ""); code != http.StatusForbidden {
+		t.Fatal("GET /api/users/:id: expected:", http.StatusForbidden, "got:", code)
+	}
+}
+
 func SetupRequest(
 	e *echo.Echo,
 	httpMethod,
@@ -23,6 +23,10 @@ func (ut *Utils) GetDB_Engine(engine_dbms, database, recordsName string, fieldAn
 	return storage.GetDB_Engine(engine_dbms, database, recordsName, fieldAndDesc...)
 }
 
+func (ut *Utils) GetTenantDB_Engine(engine_dbms, database, recordsName, tenant string, fieldAndDesc ...storage.SQL_TABLE_COLUMN_FIELD_AND_DESC) (storage.DB_Engine, error) {
+	return storage.GetTenantDB_Engine(engine_dbms, database, recordsName, tenant, fieldAndDesc...)
+}
+
 func (ut *Utils) GET_TempStore(typ, database, recordsName string) storage.TempStore {
 	return storage.GET_TempStore(typ, database, recordsName)
 }